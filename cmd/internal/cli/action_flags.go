@@ -16,13 +16,18 @@ var (
 	BindPaths       []string
 	HomePath        string
 	OverlayPath     []string
+	OverlaySubdir   string
+	OverlayMounts   []string
+	SecureRelax     []string
 	ScratchPath     []string
+	ScratchSize     string
 	WorkdirPath     string
 	PwdPath         string
 	ShellPath       string
 	Hostname        string
 	Network         string
 	NetworkArgs     []string
+	Sysctls         []string
 	DNS             string
 	Security        []string
 	CgroupsPath     string
@@ -30,23 +35,38 @@ var (
 	VMCPU           string
 	VMIP            string
 	ContainLibsPath []string
+	ContainerUser   string
+	GroupAdd        []string
+	Scheduling      string
+	Umask           string
+	OomScoreAdj     string
+	Entrypoint      string
+	DiagnosticFile  string
 	encryptionKey   string
 
 	IsBoot          bool
 	IsFakeroot      bool
 	IsCleanEnv      bool
+	IsEnvPass       bool
+	DumpConfig      bool
 	IsContained     bool
 	IsContainAll    bool
 	IsWritable      bool
 	IsWritableTmpfs bool
 	Nvidia          bool
+	NvDevicesOnly   bool
+	NvCCLI          bool
+	KernelModules   bool
 	NoHome          bool
+	NoImageBinds    bool
 	NoInit          bool
 	NoNvidia        bool
+	NoSchedCgroup   bool
 	VM              bool
 	VMErr           bool
 	NoNet           bool
 	IsSyOS          bool
+	IsLoginShell    bool
 	disableCache    bool
 
 	NetNamespace  bool
@@ -80,7 +100,7 @@ var actionBindFlag = cmdline.Flag{
 	DefaultValue: []string{},
 	Name:         "bind",
 	ShortHand:    "B",
-	Usage:        "a user-bind path specification.  spec has the format src[:dest[:opts]], where src and dest are outside and inside paths.  If dest is not given, it is set equal to src.  Mount options ('opts') may be specified as 'ro' (read-only) or 'rw' (read/write, which is the default). Multiple bind paths can be given by a comma separated list.",
+	Usage:        "a user-bind path specification.  spec has the format src[:dest[:opts]], where src and dest are outside and inside paths.  If dest is not given, it is set equal to src.  Mount options ('opts') may be specified as 'ro' (read-only) or 'rw' (read/write, which is the default), and 'optional' to skip the bind if src doesn't exist while still creating dest in the container. Multiple bind paths can be given by a comma separated list. Bind paths set with SINGULARITY_BIND or SINGULARITY_BINDPATH environment variables are added after those given with this option.",
 	EnvKeys:      []string{"BIND", "BINDPATH"},
 	Tag:          "<spec>",
 	EnvHandler:   cmdline.EnvAppendValue,
@@ -105,12 +125,36 @@ var actionOverlayFlag = cmdline.Flag{
 	DefaultValue: []string{},
 	Name:         "overlay",
 	ShortHand:    "o",
-	Usage:        "use an overlayFS image for persistent data storage or as read-only layer of container",
+	Usage:        "use an overlayFS image for persistent data storage or as read-only layer of container, squashfs images served over HTTP(S) are used read-only when enabled by system administrator",
 	EnvKeys:      []string{"OVERLAY", "OVERLAYIMAGE"},
 	Tag:          "<path>",
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --overlay-subdir
+var actionOverlaySubdirFlag = cmdline.Flag{
+	ID:           "actionOverlaySubdirFlag",
+	Value:        &OverlaySubdir,
+	DefaultValue: "",
+	Name:         "overlay-subdir",
+	Usage:        "relative path within the writable overlay image where upper and work directories are created, allowing several containers to share the same overlay image",
+	EnvKeys:      []string{"OVERLAY_SUBDIR"},
+	Tag:          "<path>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --overlay-mount
+var actionOverlayMountFlag = cmdline.Flag{
+	ID:           "actionOverlayMountFlag",
+	Value:        &OverlayMounts,
+	DefaultValue: []string{},
+	Name:         "overlay-mount",
+	Usage:        "mount a writable overlay at dest in the container with upper and work directories located in subdir of the writable overlay image, spec has the format <subdir>:<dest>",
+	EnvKeys:      []string{"OVERLAY_MOUNT"},
+	Tag:          "<spec>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // -S|--scratch
 var actionScratchFlag = cmdline.Flag{
 	ID:           "actionScratchFlag",
@@ -118,12 +162,24 @@ var actionScratchFlag = cmdline.Flag{
 	DefaultValue: []string{},
 	Name:         "scratch",
 	ShortHand:    "S",
-	Usage:        "include a scratch directory within the container that is linked to a temporary dir (use -W to force location)",
+	Usage:        "include a scratch directory within the container that is linked to a temporary dir (use -W to force location, append :<size> for a size limited tmpfs, e.g. /scratch:10G)",
 	EnvKeys:      []string{"SCRATCH", "SCRATCHDIR"},
 	Tag:          "<path>",
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --scratch-size
+var actionScratchSizeFlag = cmdline.Flag{
+	ID:           "actionScratchSizeFlag",
+	Value:        &ScratchSize,
+	DefaultValue: "",
+	Name:         "scratch-size",
+	Usage:        "default size of tmpfs backing scratch directories, and /tmp and /var/tmp with --contain, when no working directory is used (default to 'sessiondir max size' for scratch directories)",
+	EnvKeys:      []string{"SCRATCH_SIZE"},
+	Tag:          "<size>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // -W|--workdir
 var actionWorkdirFlag = cmdline.Flag{
 	ID:           "actionWorkdirFlag",
@@ -160,6 +216,41 @@ var actionShellFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --login
+var actionLoginFlag = cmdline.Flag{
+	ID:           "actionLoginFlag",
+	Value:        &IsLoginShell,
+	DefaultValue: false,
+	Name:         "login",
+	Usage:        "start the interactive shell as a login shell sourcing /etc/profile",
+	EnvKeys:      []string{"LOGIN"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --entrypoint
+var actionEntrypointFlag = cmdline.Flag{
+	ID:           "actionEntrypointFlag",
+	Value:        &Entrypoint,
+	DefaultValue: "",
+	Name:         "entrypoint",
+	Usage:        "program to execute in place of the container runscript, arguments are passed to it",
+	EnvKeys:      []string{"ENTRYPOINT"},
+	Tag:          "<path>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --diagnostic-file
+var actionDiagnosticFileFlag = cmdline.Flag{
+	ID:           "actionDiagnosticFileFlag",
+	Value:        &DiagnosticFile,
+	DefaultValue: "",
+	Name:         "diagnostic-file",
+	Usage:        "write a JSON diagnostic of the failure in path when the container creation fails",
+	EnvKeys:      []string{"DIAGNOSTIC_FILE"},
+	Tag:          "<path>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --pwd
 var actionPwdFlag = cmdline.Flag{
 	ID:           "actionPwdFlag",
@@ -208,6 +299,18 @@ var actionNetworkArgsFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --sysctl
+var actionSysctlFlag = cmdline.Flag{
+	ID:           "actionSysctlFlag",
+	Value:        &Sysctls,
+	DefaultValue: []string{},
+	Name:         "sysctl",
+	Usage:        "set namespaced kernel parameters (eg: net.ipv4.ip_local_port_range=\"1024 65000\"), network parameters require --net and IPC parameters require --ipc",
+	EnvKeys:      []string{"SYSCTL"},
+	Tag:          "<key=value>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --dns
 var actionDNSFlag = cmdline.Flag{
 	ID:           "actionDnsFlag",
@@ -230,6 +333,66 @@ var actionSecurityFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --user
+var actionUserFlag = cmdline.Flag{
+	ID:           "actionUserFlag",
+	Value:        &ContainerUser,
+	DefaultValue: "",
+	Name:         "user",
+	Usage:        "run container process as the specified user and group (<uid|name>[:<gid|name>]), unprivileged users are restricted to IDs mapped in the user namespace",
+	EnvKeys:      []string{"CONTAINER_USER"},
+	Tag:          "<spec>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --scheduling
+var actionSchedulingFlag = cmdline.Flag{
+	ID:           "actionSchedulingFlag",
+	Value:        &Scheduling,
+	DefaultValue: "",
+	Name:         "scheduling",
+	Usage:        "run container process with the scheduling policy other, batch, idle, fifo or rr (<policy>[:<nice|rt priority>]), unprivileged users can only lower priority",
+	EnvKeys:      []string{"SCHEDULING"},
+	Tag:          "<spec>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --umask
+var actionUmaskFlag = cmdline.Flag{
+	ID:           "actionUmaskFlag",
+	Value:        &Umask,
+	DefaultValue: "",
+	Name:         "umask",
+	Usage:        "set the container process umask as an octal value (eg: 0022), the inherited umask is kept by default",
+	EnvKeys:      []string{"UMASK"},
+	Tag:          "<mask>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --oom-score-adj
+var actionOomScoreAdjFlag = cmdline.Flag{
+	ID:           "actionOomScoreAdjFlag",
+	Value:        &OomScoreAdj,
+	DefaultValue: "",
+	Name:         "oom-score-adj",
+	Usage:        "set the container process OOM score adjustment (-1000 to 1000), only root can set a value lower than the configured one",
+	EnvKeys:      []string{"OOM_SCORE_ADJ"},
+	Tag:          "<adj>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --group-add
+var actionGroupAddFlag = cmdline.Flag{
+	ID:           "actionGroupAddFlag",
+	Value:        &GroupAdd,
+	DefaultValue: []string{},
+	Name:         "group-add",
+	Usage:        "add supplementary groups (name or gid) to the container process, unprivileged users are restricted to groups they belong to",
+	EnvKeys:      []string{"GROUP_ADD"},
+	Tag:          "<group>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --apply-cgroups
 var actionApplyCgroupsFlag = cmdline.Flag{
 	ID:           "actionApplyCgroupsFlag",
@@ -241,6 +404,17 @@ var actionApplyCgroupsFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --no-scheduler-cgroup
+var actionNoSchedulerCgroupFlag = cmdline.Flag{
+	ID:           "actionNoSchedulerCgroupFlag",
+	Value:        &NoSchedCgroup,
+	DefaultValue: false,
+	Name:         "no-scheduler-cgroup",
+	Usage:        "do NOT create container cgroup within the batch scheduler job cgroup",
+	EnvKeys:      []string{"NO_SCHEDULER_CGROUP"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --vm-ram
 var actionVMRAMFlag = cmdline.Flag{
 	ID:           "actionVMRAMFlag",
@@ -373,6 +547,28 @@ var actionCleanEnvFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --env-pass
+var actionEnvPassFlag = cmdline.Flag{
+	ID:           "actionEnvPassFlag",
+	Value:        &IsEnvPass,
+	DefaultValue: false,
+	Name:         "env-pass",
+	Usage:        "pass caller environment even if the image requests a clean environment",
+	EnvKeys:      []string{"ENV_PASS"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --dump-config
+var actionDumpConfigFlag = cmdline.Flag{
+	ID:           "actionDumpConfigFlag",
+	Value:        &DumpConfig,
+	DefaultValue: false,
+	Name:         "dump-config",
+	Usage:        "print the effective container configuration as JSON and exit",
+	EnvKeys:      []string{"DUMP_CONFIG"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // -c|--contain
 var actionContainFlag = cmdline.Flag{
 	ID:           "actionContainFlag",
@@ -408,6 +604,28 @@ var actionNvidiaFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --nv-devices-only
+var actionNvDevicesOnlyFlag = cmdline.Flag{
+	ID:           "actionNvDevicesOnlyFlag",
+	Value:        &NvDevicesOnly,
+	DefaultValue: false,
+	Name:         "nv-devices-only",
+	Usage:        "enable Nvidia devices without binding Nvidia libraries and binaries (overrides library binding of --nv)",
+	EnvKeys:      []string{"NV_DEVICES_ONLY"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --nvccli
+var actionNvCCLIFlag = cmdline.Flag{
+	ID:           "actionNvCCLIFlag",
+	Value:        &NvCCLI,
+	DefaultValue: false,
+	Name:         "nvccli",
+	Usage:        "use nvidia-container-cli to set up Nvidia GPUs and libraries with --nv",
+	EnvKeys:      []string{"NVCCLI"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // -w|--writable
 var actionWritableFlag = cmdline.Flag{
 	ID:           "actionWritableFlag",
@@ -442,6 +660,40 @@ var actionNoHomeFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --relax
+var actionRelaxFlag = cmdline.Flag{
+	ID:           "actionRelaxFlag",
+	Value:        &SecureRelax,
+	DefaultValue: []string{},
+	Name:         "relax",
+	Usage:        "relax features of the secure profile enabled by administrator (contain,cleanenv,no-new-privs,seccomp,proc-readonly,private-dev)",
+	EnvKeys:      []string{"RELAX"},
+	Tag:          "<feature>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --no-image-binds
+var actionNoImageBindsFlag = cmdline.Flag{
+	ID:           "actionNoImageBindsFlag",
+	Value:        &NoImageBinds,
+	DefaultValue: false,
+	Name:         "no-image-binds",
+	Usage:        "do NOT bind default host paths declared by the image runtime options",
+	EnvKeys:      []string{"NO_IMAGE_BINDS"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --kernel-modules
+var actionKernelModulesFlag = cmdline.Flag{
+	ID:           "actionKernelModulesFlag",
+	Value:        &KernelModules,
+	DefaultValue: false,
+	Name:         "kernel-modules",
+	Usage:        "bind host kernel modules and sources (/lib/modules/$(uname -r) and /usr/src) read-only into the container",
+	EnvKeys:      []string{"KERNEL_MODULES"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --no-init
 var actionNoInitFlag = cmdline.Flag{
 	ID:           "actionNoInitFlag",
@@ -568,7 +820,7 @@ var actionUserNamespaceFlag = cmdline.Flag{
 	DefaultValue: false,
 	Name:         "userns",
 	ShortHand:    "u",
-	Usage:        "run container in a new user namespace, allowing Singularity to run completely unprivileged on recent kernels. This disables some features of Singularity, for example it only works with sandbox images.",
+	Usage:        "run container in a new user namespace, allowing Singularity to run completely unprivileged on recent kernels. This disables some features of Singularity, for example it only works with sandbox images, or squashfs based images when squashfuse is installed.",
 	EnvKeys:      []string{"USERNS", "UNSHARE_USERNS"},
 	ExcludedOS:   []string{cmdline.Darwin},
 }
@@ -653,15 +905,28 @@ func init() {
 	cmdManager.RegisterFlagForCmd(&actionBindFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionHomeFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionOverlayFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionOverlaySubdirFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionOverlayMountFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionScratchFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionScratchSizeFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionWorkdirFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionShellFlag, ShellCmd)
+	cmdManager.RegisterFlagForCmd(&actionLoginFlag, ShellCmd)
+	cmdManager.RegisterFlagForCmd(&actionEntrypointFlag, RunCmd)
+	cmdManager.RegisterFlagForCmd(&actionDiagnosticFileFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionHostnameFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionNetworkFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionNetworkArgsFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionSysctlFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionDNSFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionSecurityFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionUserFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionGroupAddFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionSchedulingFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionUmaskFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionOomScoreAdjFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionApplyCgroupsFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionNoSchedulerCgroupFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionVMRAMFlag, actionsCmd...)
 	cmdManager.RegisterFlagForCmd(&actionVMCPUFlag, actionsCmd...)
 	cmdManager.RegisterFlagForCmd(&actionVMIPFlag, actionsCmd...)
@@ -673,13 +938,20 @@ func init() {
 	cmdManager.RegisterFlagForCmd(&actionDisableCacheFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionTmpDirFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionCleanEnvFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionEnvPassFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionDumpConfigFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionContainFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionContainAllFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionNvidiaFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionNvDevicesOnlyFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionNvCCLIFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionWritableFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionWritableTmpfsFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionNoHomeFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionNoImageBindsFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionRelaxFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionNoInitFlag, actionsInstanceCmd...)
+	cmdManager.RegisterFlagForCmd(&actionKernelModulesFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionNoHTTPSFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionDockerLoginFlag, actionsInstanceCmd...)
 	cmdManager.RegisterFlagForCmd(&actionNoNvidiaFlag, actionsInstanceCmd...)
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	ocitypes "github.com/containers/image/types"
@@ -27,10 +28,13 @@ import (
 	"github.com/sylabs/singularity/pkg/build/types"
 	net "github.com/sylabs/singularity/pkg/client/net"
 	shub "github.com/sylabs/singularity/pkg/client/shub"
+	"github.com/sylabs/singularity/pkg/image"
 )
 
 const (
 	defaultPath = "/bin:/usr/bin:/sbin:/usr/sbin:/usr/local/bin:/usr/local/sbin"
+	// imagePathEnv lists directories where images given by name are searched
+	imagePathEnv = "SINGULARITY_IMAGE_PATH"
 )
 
 func getCacheHandle() *cache.Handle {
@@ -342,6 +346,16 @@ func replaceURIWithImage(imgCache *cache.Handle, cmd *cobra.Command, args []stri
 	args[0] = image
 }
 
+// searchImagePath returns the path of image name found in the image
+// search path directories, the colon separated list of directories set
+// with SINGULARITY_IMAGE_PATH takes precedence over dirs
+func searchImagePath(name string, dirs []string) string {
+	if env := os.Getenv(imagePathEnv); env != "" {
+		dirs = filepath.SplitList(env)
+	}
+	return image.Search(name, dirs)
+}
+
 // setVM will set the --vm option if needed by other options
 func setVM(cmd *cobra.Command) {
 	// check if --vm-ram or --vm-cpu changed from default value
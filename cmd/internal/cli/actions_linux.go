@@ -32,6 +32,7 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config/oci"
 	"github.com/sylabs/singularity/internal/pkg/security"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/bin"
 	"github.com/sylabs/singularity/internal/pkg/util/env"
 	"github.com/sylabs/singularity/internal/pkg/util/exec"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
@@ -53,6 +54,82 @@ func EnsureRootPriv(cmd *cobra.Command, args []string) {
 	}
 }
 
+// librariesSources lists the sources of libraries bound in
+// /.singularity.d/libs in their default order
+var librariesSources = []string{"containlibs", "nv"}
+
+// orderLibraries returns the libraries of sources ordered according to
+// the 'libraries bind order' directive, libraries with a file name
+// already provided by a previous source are discarded
+func orderLibraries(order []string, sources map[string][]string) []string {
+	libraries := []string{}
+	names := make(map[string]string)
+	done := make(map[string]bool)
+
+	for _, source := range append(append([]string{}, order...), librariesSources...) {
+		source = strings.TrimSpace(source)
+		if source == "" || done[source] {
+			continue
+		}
+		done[source] = true
+
+		libs, ok := sources[source]
+		if !ok {
+			sylog.Warningf("Ignoring unknown source %q in 'libraries bind order'", source)
+			continue
+		}
+		for _, lib := range libs {
+			name := filepath.Base(lib)
+			if prev, ok := names[name]; ok {
+				sylog.Verbosef("Library %s from %s ignored, %s takes precedence", lib, source, prev)
+				continue
+			}
+			names[name] = lib
+			libraries = append(libraries, lib)
+		}
+	}
+
+	sylog.Debugf("Libraries bind order: %v", libraries)
+	return libraries
+}
+
+// parseRemoteOverlay returns the URL of a remote overlay specification
+// <url>[:ro], remote overlays are read-only so a :rw suffix is rejected
+func parseRemoteOverlay(spec string) (string, error) {
+	i := strings.LastIndex(spec, ":")
+	if i > strings.LastIndex(spec, "/") {
+		switch spec[i+1:] {
+		case "ro":
+			return spec[:i], nil
+		case "rw":
+			return "", fmt.Errorf("remote overlay %s is read-only, :rw is not supported", spec[:i])
+		}
+	}
+	return spec, nil
+}
+
+// squashfuseImage returns if the root filesystem of image is a squashfs
+// partition which can be mounted with squashfuse in user namespace
+// instead of converting the image to a sandbox
+func squashfuseImage(path string) bool {
+	if _, err := bin.Find("squashfuse"); err != nil {
+		sylog.Debugf("While searching for squashfuse: %s", err)
+		return false
+	}
+
+	img, err := image.Init(path, false)
+	if err != nil {
+		return false
+	}
+	defer img.File.Close()
+
+	if len(img.Partitions) == 0 || img.Partitions[0].Type != image.SQUASHFS {
+		return false
+	}
+	sylog.Verbosef("User namespace requested, mounting image %s with squashfuse", path)
+	return true
+}
+
 func convertImage(filename string, unsquashfsPath string) (string, error) {
 	img, err := image.Init(filename, false)
 	if err != nil {
@@ -103,6 +180,160 @@ func convertImage(filename string, unsquashfsPath string) (string, error) {
 	return dir, err
 }
 
+// cudaCachePath returns a persistent location for the CUDA JIT cache when
+// the container is contained, the cache is stored in /var/tmp backed by
+// the working directory. An empty string is returned if user already set
+// CUDA_CACHE_PATH or if there is no persistent location available.
+func cudaCachePath(engineConfig *singularityConfig.EngineConfig) string {
+	if os.Getenv("CUDA_CACHE_PATH") != "" || os.Getenv("SINGULARITYENV_CUDA_CACHE_PATH") != "" {
+		return ""
+	}
+	// home and temporary directories are shared with host, the
+	// default CUDA cache location is persistent
+	if !engineConfig.GetContain() {
+		return ""
+	}
+	if engineConfig.GetWorkdir() == "" || !engineConfig.File.MountTmp {
+		sylog.Verbosef("No working directory, CUDA JIT cache won't persist across runs")
+		return ""
+	}
+	return "/var/tmp/.nv/ComputeCache"
+}
+
+// applyRuntimeOptions applies runtime options advertised by the image
+// metadata, options explicitly set by the user take precedence.
+func applyRuntimeOptions(cobraCmd *cobra.Command, engineConfig *singularityConfig.EngineConfig, path string) {
+	img, err := image.Init(path, false)
+	if err != nil {
+		sylog.Debugf("Could not read image runtime options: %s", err)
+		return
+	}
+	defer img.File.Close()
+
+	options, err := singularityConfig.GetRuntimeOptions(img)
+	if err != nil {
+		sylog.Warningf("Ignoring image runtime options: %s", err)
+		return
+	} else if options == nil {
+		return
+	}
+
+	flags := cobraCmd.Flags()
+
+	if options.CleanEnv && !IsEnvPass && !flags.Changed("cleanenv") {
+		sylog.Verbosef("Image runtime options: enabling cleanenv")
+		IsCleanEnv = true
+	}
+	if options.Contain && !flags.Changed("contain") {
+		sylog.Verbosef("Image runtime options: enabling contain")
+		IsContained = true
+	}
+	if options.NoHome && !flags.Changed("no-home") {
+		sylog.Verbosef("Image runtime options: enabling no-home")
+		NoHome = true
+	}
+	if options.WritableTmpfs && !IsWritable && !flags.Changed("writable-tmpfs") {
+		sylog.Verbosef("Image runtime options: enabling writable-tmpfs")
+		IsWritableTmpfs = true
+		engineConfig.SetImageWritableTmpfs(true)
+	}
+	if options.Network != "" && !flags.Changed("net") && !flags.Changed("network") {
+		sylog.Verbosef("Image runtime options: using %s network", options.Network)
+		NetNamespace = true
+		Network = options.Network
+	}
+	if options.Layer {
+		sylog.Debugf("Image runtime options: image expects overlay or underlay")
+		engineConfig.SetLayerHint(true)
+	}
+	if len(options.Binds) > 0 {
+		if NoImageBinds {
+			sylog.Verbosef("Image runtime options: ignoring default binds")
+		} else {
+			BindPaths = mergeImageBinds(options.Binds, BindPaths)
+		}
+	}
+}
+
+// applySecureProfile enables contain and cleanenv when the secure profile
+// is enabled in configuration and the features aren't relaxed with --relax,
+// the remaining features are applied by the engine.
+func applySecureProfile(engineConfig *singularityConfig.EngineConfig) {
+	for _, feature := range SecureRelax {
+		known := false
+		for _, f := range singularityConfig.SecureProfileFeatures {
+			known = known || f == feature
+		}
+		if !known {
+			sylog.Fatalf("Unknown secure profile feature %q, must be one of %s", feature, strings.Join(singularityConfig.SecureProfileFeatures, ","))
+		}
+	}
+	engineConfig.SetSecureRelax(SecureRelax)
+
+	if !engineConfig.File.SecureProfile {
+		return
+	}
+
+	if engineConfig.SecureProfile("contain") && !IsContained {
+		sylog.Verbosef("Secure profile: enabling contain")
+		IsContained = true
+	}
+	if engineConfig.SecureProfile("cleanenv") && !IsCleanEnv {
+		if IsEnvPass {
+			sylog.Verbosef("Secure profile: not enabling cleanenv, --env-pass requested")
+		} else {
+			sylog.Verbosef("Secure profile: enabling cleanenv")
+			IsCleanEnv = true
+		}
+	}
+}
+
+// schedulerJob returns the ID of the Slurm or PBS job the container is
+// started in, empty if not started by a batch scheduler
+func schedulerJob() string {
+	for _, key := range []string{"SLURM_JOB_ID", "PBS_JOBID"} {
+		if job := os.Getenv(key); job != "" {
+			sylog.Verbosef("Running within batch scheduler job %s", job)
+			for _, res := range []string{"SLURM_CPUS_PER_TASK", "SLURM_MEM_PER_NODE", "SLURM_MEM_PER_CPU", "PBS_NCPUS"} {
+				if value := os.Getenv(res); value != "" {
+					sylog.Verbosef("Job resource %s = %s", res, value)
+				}
+			}
+			return job
+		}
+	}
+	return ""
+}
+
+// bindDest returns the container destination of a bind specification
+func bindDest(spec string) string {
+	splitted := strings.Split(spec, ":")
+	if len(splitted) > 1 && splitted[1] != "" {
+		return splitted[1]
+	}
+	return splitted[0]
+}
+
+// mergeImageBinds returns image default binds followed by user binds,
+// image binds with a destination also bound by the user are dropped
+func mergeImageBinds(imageBinds []string, userBinds []string) []string {
+	dests := make(map[string]bool)
+	for _, b := range userBinds {
+		dests[filepath.Clean(bindDest(b))] = true
+	}
+
+	binds := make([]string, 0, len(imageBinds)+len(userBinds))
+	for _, b := range imageBinds {
+		if dests[filepath.Clean(bindDest(b))] {
+			sylog.Verbosef("Image runtime options: bind %s overridden by user", b)
+			continue
+		}
+		sylog.Verbosef("Image runtime options: binding %s", b)
+		binds = append(binds, b)
+	}
+	return append(binds, userBinds...)
+}
+
 // TODO: Let's stick this in another file so that that CLI is just CLI
 func execStarter(cobraCmd *cobra.Command, image string, args []string, name string) {
 	targetUID := 0
@@ -128,7 +359,11 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		fn()
 	}
 
-	syscall.Umask(0022)
+	// container process gets the inherited umask unless --umask is set
+	umask := syscall.Umask(0022)
+	if Umask == "" {
+		Umask = fmt.Sprintf("%04o", umask)
+	}
 
 	engineConfig := singularityConfig.NewConfig()
 
@@ -190,6 +425,7 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		engineConfig.SetImage(image)
 		engineConfig.SetInstanceJoin(true)
 	} else {
+		image = searchImagePath(image, engineConfig.File.ImageSearchPath)
 		abspath, err := filepath.Abs(image)
 		generator.AddProcessEnv("SINGULARITY_CONTAINER", abspath)
 		generator.AddProcessEnv("SINGULARITY_NAME", filepath.Base(abspath))
@@ -197,7 +433,9 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 			sylog.Fatalf("Failed to determine image absolute path for %s: %s", image, err)
 		}
 		engineConfig.SetImage(abspath)
+		applyRuntimeOptions(cobraCmd, engineConfig, abspath)
 	}
+	applySecureProfile(engineConfig)
 
 	starter := filepath.Join(buildcfg.LIBEXECDIR, "singularity/bin/starter-suid")
 	// singularity was compiled with '--without-suid' option
@@ -223,7 +461,31 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		sylog.Fatalf("%s not found, please check your installation", starter)
 	}
 
-	if !NoNvidia && (Nvidia || engineConfig.File.AlwaysUseNv) {
+	// nvidia devices are enabled with --nv, --nv-devices-only or
+	// 'always use nv = yes', libraries and binaries are bound
+	// only if --nv-devices-only is not set, --no-nv disables both
+	useNvidia := !NoNvidia && (Nvidia || NvDevicesOnly || engineConfig.File.AlwaysUseNv)
+	useNvidiaLibs := useNvidia && !NvDevicesOnly
+	nvidiaLibs := []string{}
+
+	// nvidia-container-cli replaces devices and libraries setup,
+	// fall back to the built-in setup if it's not installed
+	useNvCCLI := useNvidiaLibs && (NvCCLI || engineConfig.File.UseNvCCLI)
+	if useNvCCLI {
+		if _, err := bin.Find("nvidia-container-cli"); err != nil {
+			sylog.Warningf("nvidia-container-cli not found, falling back to built-in NVIDIA setup: %s", err)
+			useNvCCLI = false
+		}
+	}
+
+	if useNvCCLI {
+		sylog.Verbosef("Delegating NVIDIA devices and libraries setup to nvidia-container-cli")
+		if !IsWritable && !IsWritableTmpfs {
+			sylog.Warningf("nvidia-container-cli requires a writable container, consider using --writable-tmpfs")
+		}
+	} else if useNvidia && !useNvidiaLibs {
+		sylog.Verbosef("Binding nvidia devices only, nvidia libraries and binaries are not bound")
+	} else if useNvidiaLibs {
 		userPath := os.Getenv("USER_PATH")
 
 		if engineConfig.File.AlwaysUseNv {
@@ -251,7 +513,7 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 				sylog.Warningf("Could not find any NVIDIA libraries on this host!")
 				sylog.Warningf("You may need to edit %v/nvliblist.conf", buildcfg.SINGULARITY_CONFDIR)
 			} else {
-				ContainLibsPath = append(ContainLibsPath, libs...)
+				nvidiaLibs = libs
 			}
 		}
 		// bind persistenced socket if found
@@ -269,10 +531,33 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	engineConfig.SetNetwork(Network)
 	engineConfig.SetDNS(DNS)
 	engineConfig.SetNetworkArgs(NetworkArgs)
-	engineConfig.SetOverlayImage(OverlayPath)
+	overlayImages := make([]string, 0, len(OverlayPath))
+	remoteOverlays := make([]string, 0)
+	for _, o := range OverlayPath {
+		if strings.HasPrefix(o, "http://") || strings.HasPrefix(o, "https://") {
+			url, err := parseRemoteOverlay(o)
+			if err != nil {
+				sylog.Fatalf("%s", err)
+			}
+			remoteOverlays = append(remoteOverlays, url)
+			continue
+		}
+		overlayImages = append(overlayImages, o)
+	}
+	engineConfig.SetOverlayImage(overlayImages)
+	engineConfig.SetRemoteOverlay(remoteOverlays)
+	engineConfig.SetOverlaySubdir(OverlaySubdir)
+	engineConfig.SetOverlayMounts(OverlayMounts)
 	engineConfig.SetWritableImage(IsWritable)
 	engineConfig.SetNoHome(NoHome)
-	engineConfig.SetNv(Nvidia)
+	engineConfig.SetNv(useNvidia)
+	engineConfig.SetNvCCLI(useNvCCLI)
+	engineConfig.SetKernelModules(KernelModules)
+	engineConfig.SetUser(ContainerUser)
+	engineConfig.SetGroupAdd(GroupAdd)
+	engineConfig.SetScheduling(Scheduling)
+	engineConfig.SetUmask(Umask)
+	engineConfig.SetOomScoreAdj(OomScoreAdj)
 	engineConfig.SetAddCaps(AddCaps)
 	engineConfig.SetDropCaps(DropCaps)
 
@@ -287,7 +572,20 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	engineConfig.SetNoPrivs(NoPrivs)
 	engineConfig.SetSecurity(Security)
 	engineConfig.SetShell(ShellPath)
-	engineConfig.SetLibrariesPath(ContainLibsPath)
+	engineConfig.SetLoginShell(IsLoginShell)
+	engineConfig.SetEntrypoint(Entrypoint)
+
+	if DiagnosticFile != "" {
+		path, err := filepath.Abs(DiagnosticFile)
+		if err != nil {
+			sylog.Fatalf("Failed to determine absolute path for %s: %s", DiagnosticFile, err)
+		}
+		engineConfig.SetDiagnosticFile(path)
+	}
+	engineConfig.SetLibrariesPath(orderLibraries(engineConfig.File.LibrariesBindOrder, map[string][]string{
+		"containlibs": ContainLibsPath,
+		"nv":          nvidiaLibs,
+	}))
 	engineConfig.SetFakeroot(IsFakeroot)
 
 	if ShellPath != "" {
@@ -298,6 +596,10 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		engineConfig.SetCgroupsPath(CgroupsPath)
 	})
 
+	if job := schedulerJob(); job != "" && !NoSchedCgroup {
+		engineConfig.SetSchedulerJob(job)
+	}
+
 	if IsWritable && IsWritableTmpfs {
 		sylog.Warningf("Disabling --writable-tmpfs flag, mutually exclusive with --writable")
 		engineConfig.SetWritableTmpfs(false)
@@ -308,6 +610,13 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	homeFlag := cobraCmd.Flag("home")
 	engineConfig.SetCustomHome(homeFlag.Changed)
 
+	// user without home directory, a temporary home directory
+	// is created if allowed by configuration
+	if !homeFlag.Changed && HomePath == "" {
+		HomePath = filepath.Join("/home", CurrentUser.Username)
+		sylog.Verbosef("No home directory found for user %s, using %s", CurrentUser.Username, HomePath)
+	}
+
 	if !homeFlag.Changed && IsFakeroot {
 		engineConfig.SetCustomHome(true)
 		HomePath = fmt.Sprintf("%s:/root", HomePath)
@@ -350,6 +659,7 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	}
 
 	engineConfig.SetScratchDir(ScratchPath)
+	engineConfig.SetScratchSize(ScratchSize)
 	engineConfig.SetWorkdir(WorkdirPath)
 
 	homeSlice := strings.Split(HomePath, ":")
@@ -375,6 +685,9 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		IpcNamespace = true
 		engineConfig.SetInstance(true)
 		engineConfig.SetBootInstance(IsBoot)
+		engineConfig.SetReadyFile(readyFile)
+		engineConfig.SetReadyCommand(readyCommand)
+		engineConfig.SetReadyTimeout(readyTimeout)
 
 		_, err := instance.Get(name, instance.SingSubDir)
 		if err == nil {
@@ -439,6 +752,14 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		}
 	}
 
+	for _, s := range Sysctls {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			sylog.Fatalf("Bad sysctl format %s, must be key=value", s)
+		}
+		generator.AddLinuxSysctl(strings.TrimSpace(kv[0]), kv[1])
+	}
+
 	if UserNamespace {
 		generator.AddOrReplaceLinuxNamespace("user", "")
 
@@ -452,7 +773,22 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	environment := os.Environ()
 
 	// Clean environment
+	if IsCleanEnv && IsEnvPass {
+		sylog.Warningf("Disabling --env-pass flag, mutually exclusive with --cleanenv")
+	}
 	env.SetContainerEnv(&generator, environment, IsCleanEnv, engineConfig.GetHomeDest())
+	engineConfig.SetEnvOverrides(env.UserKeys(environment))
+
+	if IsCleanEnv && engineConfig.File.ConfigLocale {
+		env.SetLocaleEnv(&generator, environment)
+	}
+
+	if useNvidia && engineConfig.File.NvCudaCache {
+		if path := cudaCachePath(engineConfig); path != "" {
+			sylog.Debugf("Setting CUDA_CACHE_PATH to %s", path)
+			generator.AddProcessEnv("CUDA_CACHE_PATH", path)
+		}
+	}
 
 	// force to use getwd syscall
 	os.Unsetenv("PWD")
@@ -475,14 +811,24 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 
 	generator.AddProcessEnv("SINGULARITY_APPNAME", AppName)
 
+	if DumpConfig {
+		plugin.FlagHookCallbacks(engineConfig)
+		data, err := engineConfig.DumpJSON()
+		if err != nil {
+			sylog.Fatalf("While dumping container configuration: %s", err)
+		}
+		fmt.Println(string(data))
+		os.Exit(0)
+	}
+
 	// convert image file to sandbox if we are using user
 	// namespace or if we are currently running inside a
-	// user namespace
-	if (UserNamespace || insideUserNs) && fs.IsFile(image) {
-		unsquashfsPath := ""
-		if engineConfig.File.MksquashfsPath != "" {
-			d := filepath.Dir(engineConfig.File.MksquashfsPath)
-			unsquashfsPath = filepath.Join(d, "unsquashfs")
+	// user namespace, unless the image can be mounted with
+	// squashfuse
+	if (UserNamespace || insideUserNs) && fs.IsFile(image) && !squashfuseImage(image) {
+		unsquashfsPath, err := bin.Find("unsquashfs")
+		if err != nil {
+			sylog.Debugf("While searching for unsquashfs: %s", err)
 		}
 		sylog.Verbosef("User namespace requested, convert image %s to sandbox", image)
 		sylog.Infof("Convert SIF file to sandbox...")
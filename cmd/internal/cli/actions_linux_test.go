@@ -0,0 +1,42 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"testing"
+)
+
+func TestParseRemoteOverlay(t *testing.T) {
+	tests := []struct {
+		spec string
+		url  string
+		fail bool
+	}{
+		{"https://host/x.sqfs", "https://host/x.sqfs", false},
+		{"https://host/x.sqfs:ro", "https://host/x.sqfs", false},
+		{"https://host:8443/x.sqfs", "https://host:8443/x.sqfs", false},
+		{"https://host:8443/x.sqfs:ro", "https://host:8443/x.sqfs", false},
+		{"http://host:8080", "http://host:8080", false},
+		{"https://host/x.sqfs:rw", "", true},
+		{"https://host:8443/x.sqfs:rw", "", true},
+	}
+
+	for _, tt := range tests {
+		url, err := parseRemoteOverlay(tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("unexpected success with %q", tt.spec)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("unexpected error with %q: %s", tt.spec, err)
+			continue
+		}
+		if url != tt.url {
+			t.Errorf("got %q for %q instead of %q", url, tt.spec, tt.url)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSearchImagePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-path-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configured := filepath.Join(dir, "configured")
+	user := filepath.Join(dir, "user")
+	for _, d := range []string{configured, user} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(d, "tensorflow.sif"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer os.Unsetenv(imagePathEnv)
+
+	tests := []struct {
+		name     string
+		env      string
+		expected string
+	}{
+		{"configured directories", "", filepath.Join(configured, "tensorflow.sif")},
+		{"environment override", user, filepath.Join(user, "tensorflow.sif")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(imagePathEnv, tt.env)
+			if path := searchImagePath("tensorflow", []string{configured}); path != tt.expected {
+				t.Errorf("got %s instead of %s", path, tt.expected)
+			}
+		})
+	}
+}
@@ -41,6 +41,7 @@ var (
 	dockerLogin    bool
 	noCleanUp      bool
 	fakeroot       bool
+	buildQuiet     bool
 )
 
 // -s|--sandbox
@@ -200,6 +201,16 @@ var buildFakerootFlag = cmdline.Flag{
 	EnvKeys:      []string{"FAKEROOT"},
 }
 
+// --quiet
+var buildQuietFlag = cmdline.Flag{
+	ID:           "buildQuietFlag",
+	Value:        &buildQuiet,
+	DefaultValue: false,
+	Name:         "quiet",
+	Usage:        "only display section headers and errors of build scripts output",
+	EnvKeys:      []string{"BUILD_QUIET"},
+}
+
 func init() {
 	cmdManager.RegisterCmd(BuildCmd)
 
@@ -218,6 +229,7 @@ func init() {
 	cmdManager.RegisterFlagForCmd(&buildDisableCacheFlag, BuildCmd)
 	cmdManager.RegisterFlagForCmd(&buildUpdateFlag, BuildCmd)
 	cmdManager.RegisterFlagForCmd(&buildFakerootFlag, BuildCmd)
+	cmdManager.RegisterFlagForCmd(&buildQuietFlag, BuildCmd)
 
 	cmdManager.RegisterFlagForCmd(&actionDockerUsernameFlag, BuildCmd)
 	cmdManager.RegisterFlagForCmd(&actionDockerPasswordFlag, BuildCmd)
@@ -231,6 +231,7 @@ func run(cmd *cobra.Command, args []string) {
 					LibraryAuthToken: authToken,
 					DockerAuthConfig: authConf,
 					EncryptionKey:    encryptionKey,
+					Quiet:            buildQuiet,
 				},
 			})
 		if err != nil {
@@ -8,8 +8,50 @@ package cli
 import (
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/pkg/cmdline"
 )
 
+// instance start options
+var readyFile string
+var readyCommand string
+var readyTimeout int
+
+// --ready-file
+var instanceStartReadyFileFlag = cmdline.Flag{
+	ID:           "instanceStartReadyFileFlag",
+	Value:        &readyFile,
+	DefaultValue: "",
+	Name:         "ready-file",
+	Usage:        "wait until this file or socket exists in the container before reporting the instance as started",
+	EnvKeys:      []string{"READY_FILE"},
+}
+
+// --ready-cmd
+var instanceStartReadyCommandFlag = cmdline.Flag{
+	ID:           "instanceStartReadyCommandFlag",
+	Value:        &readyCommand,
+	DefaultValue: "",
+	Name:         "ready-cmd",
+	Usage:        "wait until this shell command succeeds in the container before reporting the instance as started",
+	EnvKeys:      []string{"READY_CMD"},
+}
+
+// --ready-timeout
+var instanceStartReadyTimeoutFlag = cmdline.Flag{
+	ID:           "instanceStartReadyTimeoutFlag",
+	Value:        &readyTimeout,
+	DefaultValue: 30,
+	Name:         "ready-timeout",
+	Usage:        "fail instance start if not ready after X seconds",
+	EnvKeys:      []string{"READY_TIMEOUT"},
+}
+
+func init() {
+	cmdManager.RegisterFlagForCmd(&instanceStartReadyFileFlag, InstanceStartCmd)
+	cmdManager.RegisterFlagForCmd(&instanceStartReadyCommandFlag, InstanceStartCmd)
+	cmdManager.RegisterFlagForCmd(&instanceStartReadyTimeoutFlag, InstanceStartCmd)
+}
+
 // InstanceStartCmd singularity instance start
 var InstanceStartCmd = &cobra.Command{
 	Args:                  cobra.MinimumNArgs(2),
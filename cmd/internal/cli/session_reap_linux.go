@@ -0,0 +1,39 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/reaper"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+func init() {
+	cmdManager.RegisterCmd(SessionReapCmd)
+}
+
+// SessionReapCmd detaches loop devices and unmounts leftover mount points
+// of a session whose container was killed without cleaning up
+var SessionReapCmd = &cobra.Command{
+	Run: func(cmd *cobra.Command, args []string) {
+		if os.Geteuid() != 0 {
+			sylog.Fatalf("session-reap requires root privileges")
+		}
+		if err := reaper.Reap(buildcfg.SESSIONDIR, args[0]); err != nil {
+			sylog.Fatalf("Could not reap session %s: %s", args[0], err)
+		}
+	},
+	DisableFlagsInUseLine: true,
+
+	Hidden:  true,
+	Args:    cobra.ExactArgs(1),
+	Use:     "session-reap <session id>",
+	Short:   "Release loop devices and mount points left by a killed container session",
+	Example: "$ sudo singularity session-reap 12345",
+}
@@ -448,7 +448,13 @@ Enterprise Performance Computing (EPC)`
 
   shub://*            A container hosted on Singularity Hub
 
-  oras://*            A container hosted on a supporting OCI registry`
+  oras://*            A container hosted on a supporting OCI registry
+
+  A container given by name only (without any directory component) which is
+  not found in the current directory is searched for in the directories set
+  with 'image search path' in singularity.conf, or in the colon separated
+  directories listed in the SINGULARITY_IMAGE_PATH environment variable when
+  set, first as is and then with a .sif suffix.`
 	ExecUse   string = `exec [exec options...] <container> <command>`
 	ExecShort string = `Run a command within a container`
 	ExecLong  string = `
@@ -12,7 +12,6 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/opencontainers/runtime-tools/generate"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/oci"
@@ -41,7 +40,6 @@ func OciCreate(containerID string, args *OciArgs) error {
 	}
 
 	engineConfig := oci.NewConfig()
-	generator := generate.Generator{Config: &engineConfig.OciConfig.Spec}
 	engineConfig.SetBundlePath(absBundle)
 	engineConfig.SetLogPath(args.LogPath)
 	engineConfig.SetLogFormat(args.LogFormat)
@@ -61,7 +59,7 @@ func OciCreate(containerID string, args *OciArgs) error {
 
 	fb.Close()
 
-	if err := json.Unmarshal(data, generator.Config); err != nil {
+	if err := json.Unmarshal(data, engineConfig.OciConfig); err != nil {
 		return fmt.Errorf("failed to parse OCI specification file %s: %s", configJSON, err)
 	}
 
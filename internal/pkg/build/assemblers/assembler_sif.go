@@ -7,12 +7,15 @@ package assemblers
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
 	"syscall"
 
 	uuid "github.com/satori/go.uuid"
@@ -20,7 +23,9 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/image/packer"
+	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engines/singularity/config"
 	"github.com/sylabs/singularity/pkg/util/crypt"
+	"golang.org/x/sys/unix"
 )
 
 // SIFAssembler doesnt store anything
@@ -34,7 +39,30 @@ type encryptionOptions struct {
 	plaintext []byte
 }
 
-func createSIF(path string, definition, ociConf []byte, squashfile string, encOpts *encryptionOptions) (err error) {
+// layerBindPoints lists host files bound by default in containers and
+// usually missing from minimal images, images lacking one of them rely
+// on overlay or underlay to create the bind point at runtime
+var layerBindPoints = []string{
+	"/etc/hosts",
+	"/etc/localtime",
+	"/etc/resolv.conf",
+	"/etc/passwd",
+	"/etc/group",
+}
+
+// runtimeOptions returns the runtime options recorded in the image
+// for its root filesystem, nil is returned if there is none to record
+func runtimeOptions(rootfs string) ([]byte, error) {
+	for _, p := range layerBindPoints {
+		if _, err := os.Lstat(filepath.Join(rootfs, p)); os.IsNotExist(err) {
+			sylog.Verbosef("%s is missing in the image, overlay or underlay is required to bind it at runtime", p)
+			return json.Marshal(&singularityConfig.RuntimeOptions{Layer: true})
+		}
+	}
+	return nil, nil
+}
+
+func createSIF(path string, definition, ociConf, runtimeOptions []byte, squashfile string, encOpts *encryptionOptions) (err error) {
 	// general info for the new SIF file creation
 	cinfo := sif.CreateInfo{
 		Pathname:   path,
@@ -70,6 +98,21 @@ func createSIF(path string, definition, ociConf []byte, squashfile string, encOp
 		cinfo.InputDescr = append(cinfo.InputDescr, ociInput)
 	}
 
+	if len(runtimeOptions) > 0 {
+		// data we need to create a runtime options descriptor
+		optsInput := sif.DescriptorInput{
+			Datatype: sif.DataGenericJSON,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     sif.DescrUnusedLink,
+			Data:     runtimeOptions,
+			Fname:    singularityConfig.RuntimeOptionsName,
+		}
+		optsInput.Size = int64(binary.Size(optsInput.Data))
+
+		// add this descriptor input element to creation descriptor slice
+		cinfo.InputDescr = append(cinfo.InputDescr, optsInput)
+	}
+
 	// data we need to create a system partition descriptor
 	parinput := sif.DescriptorInput{
 		Datatype: sif.DataPartition,
@@ -172,6 +215,16 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 		flags = append(flags, "-comp", "gzip")
 	}
 
+	// preserve extended attributes to keep file capabilities
+	if s.HasXattrs() {
+		flags = append(flags, "-xattrs")
+	} else {
+		sylog.Debugf("%s lacks extended attributes support, extended attributes won't be preserved", a.MksquashfsPath)
+		for _, f := range capabilityFiles(b.Rootfs()) {
+			sylog.Warningf("%s lacks extended attributes support, file capabilities of %s will be lost", a.MksquashfsPath, f)
+		}
+	}
+
 	if err := s.Create([]string{b.Rootfs()}, fsPath, flags); err != nil {
 		return fmt.Errorf("while creating squashfs: %v", err)
 	}
@@ -205,7 +258,12 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 
 	}
 
-	err = createSIF(path, b.Recipe.Raw, b.JSONObjects["oci-config"], fsPath, encOpts)
+	opts, err := runtimeOptions(b.Rootfs())
+	if err != nil {
+		return fmt.Errorf("while recording runtime options: %v", err)
+	}
+
+	err = createSIF(path, b.Recipe.Raw, b.JSONObjects["oci-config"], opts, fsPath, encOpts)
 	if err != nil {
 		return fmt.Errorf("while creating SIF: %v", err)
 	}
@@ -213,6 +271,26 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 	return nil
 }
 
+// capabilityFiles returns the list of regular files having file
+// capabilities set in the root filesystem, paths are relative to rootfs
+func capabilityFiles(rootfs string) []string {
+	files := make([]string, 0)
+
+	filepath.Walk(rootfs, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return nil
+		}
+		if sz, err := unix.Lgetxattr(path, "security.capability", nil); err == nil && sz > 0 {
+			f := strings.TrimPrefix(path, rootfs)
+			sylog.Debugf("Found file capabilities on %s", f)
+			files = append(files, f)
+		}
+		return nil
+	})
+
+	return files
+}
+
 // changeOwner check the command being called with sudo with the environment
 // variable SUDO_COMMAND. Pattern match that for the singularity bin
 func changeOwner() (int, int, bool) {
@@ -0,0 +1,89 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"github.com/sylabs/singularity/pkg/image"
+	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engines/singularity/config"
+)
+
+func TestRuntimeOptions(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "sif-runtime-options-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// fake partition with a zlib squashfs super block, only SIF
+	// descriptors are read back
+	partition := filepath.Join(dir, "squashfs")
+	data := make([]byte, 4096)
+	copy(data, "hsqs")
+	data[20] = 1
+	if err := ioutil.WriteFile(partition, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		files []string
+		layer bool
+	}{
+		{"missing bind points", nil, true},
+		{"all bind points", layerBindPoints, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, f := range tt.files {
+				if err := ioutil.WriteFile(filepath.Join(rootfs, f), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			opts, err := runtimeOptions(rootfs)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			path := filepath.Join(dir, "image.sif")
+			if err := createSIF(path, []byte("bootstrap: scratch\n"), nil, opts, partition, nil); err != nil {
+				t.Fatalf("unexpected error while creating SIF: %s", err)
+			}
+			defer os.Remove(path)
+
+			img, err := image.Init(path, false)
+			if err != nil {
+				t.Fatalf("unexpected error while reading SIF: %s", err)
+			}
+			defer img.File.Close()
+
+			options, err := singularityConfig.GetRuntimeOptions(img)
+			if err != nil {
+				t.Fatalf("unexpected error while reading runtime options: %s", err)
+			}
+			if tt.layer && (options == nil || !options.Layer) {
+				t.Errorf("layer runtime option not recorded: %+v", options)
+			} else if !tt.layer && options != nil {
+				t.Errorf("unexpected runtime options recorded: %+v", options)
+			}
+		})
+	}
+}
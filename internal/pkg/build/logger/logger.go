@@ -0,0 +1,82 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const timeFormat = "15:04:05"
+
+// Writer streams build command output line by line, each line is
+// prefixed with a timestamp, the build section and the output stream
+type Writer struct {
+	sync.Mutex
+	w       io.Writer
+	section string
+	stream  string
+	discard bool
+	buf     []byte
+	now     func() time.Time
+}
+
+// NewWriter returns a Writer writing lines produced by the stream
+// ("stdout" or "stderr") of the build section to w. If quiet is set,
+// lines written to stdout are discarded.
+func NewWriter(w io.Writer, section, stream string, quiet bool) *Writer {
+	return &Writer{
+		w:       w,
+		section: section,
+		stream:  stream,
+		discard: quiet && stream == "stdout",
+		now:     time.Now,
+	}
+}
+
+// Write buffers p and writes complete lines to the underlying writer.
+func (l *Writer) Write(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.discard {
+		return len(p), nil
+	}
+
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := l.writeLine(l.buf[:i]); err != nil {
+			return 0, err
+		}
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the last incomplete line if any.
+func (l *Writer) Flush() error {
+	l.Lock()
+	defer l.Unlock()
+
+	if len(l.buf) == 0 {
+		return nil
+	}
+	err := l.writeLine(l.buf)
+	l.buf = nil
+	return err
+}
+
+func (l *Writer) writeLine(line []byte) error {
+	_, err := fmt.Fprintf(l.w, "%s [%s] %s: %s\n", l.now().Format(timeFormat), l.section, l.stream, line)
+	return err
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package logger
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		quiet  bool
+		writes []string
+		output string
+	}{
+		{
+			name:   "stdout",
+			stream: "stdout",
+			writes: []string{"hello\n", "wor", "ld\nlast"},
+			output: "12:34:56 [post] stdout: hello\n12:34:56 [post] stdout: world\n12:34:56 [post] stdout: last\n",
+		},
+		{
+			name:   "quiet stdout",
+			stream: "stdout",
+			quiet:  true,
+			writes: []string{"hello\n"},
+			output: "",
+		},
+		{
+			name:   "quiet stderr",
+			stream: "stderr",
+			quiet:  true,
+			writes: []string{"error\n"},
+			output: "12:34:56 [post] stderr: error\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+
+			w := NewWriter(&b, "post", tt.stream, tt.quiet)
+			w.now = func() time.Time {
+				return time.Date(2019, 1, 1, 12, 34, 56, 0, time.UTC)
+			}
+
+			for _, s := range tt.writes {
+				n, err := w.Write([]byte(s))
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if n != len(s) {
+					t.Errorf("wrote %d bytes instead of %d", n, len(s))
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if b.String() != tt.output {
+				t.Errorf("got output %q instead of %q", b.String(), tt.output)
+			}
+		})
+	}
+}
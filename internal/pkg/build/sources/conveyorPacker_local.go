@@ -7,6 +7,8 @@ package sources
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 
 	"github.com/sylabs/singularity/internal/pkg/sylog"
@@ -80,19 +82,48 @@ func GetLocalPacker(src string, b *types.Bundle) (LocalPacker, error) {
 			b:      b,
 		}, nil
 	default:
-		return nil, fmt.Errorf("invalid image format")
+		return nil, fmt.Errorf("%s is not a supported image format", src)
 	}
 }
 
+// checkLocalSource checks that src is a readable directory or file
+func checkLocalSource(src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("while opening local image %s: %v", src, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("while checking local image %s: %v", src, err)
+	}
+	if fi.IsDir() {
+		if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+			return fmt.Errorf("local image directory %s is not readable: %v", src, err)
+		}
+	} else if !fi.Mode().IsRegular() {
+		return fmt.Errorf("local image %s is neither a directory nor an image file", src)
+	}
+	return nil
+}
+
 // Get just stores the source
 func (cp *LocalConveyorPacker) Get(b *types.Bundle) (err error) {
+	from := b.Recipe.Header["from"]
+	if from == "" {
+		return fmt.Errorf("localimage bootstrap requires a From: path to a sandbox directory or an image")
+	}
+	cp.src = filepath.Clean(from)
+	if err = checkLocalSource(cp.src); err != nil {
+		return err
+	}
+
 	// insert base metadata before unpacking fs
 	if err = makeBaseEnv(b.Rootfs()); err != nil {
 		return fmt.Errorf("while inserting base environment: %v", err)
 	}
 
-	cp.src = filepath.Clean(b.Recipe.Header["from"])
-
 	cp.LocalPacker, err = GetLocalPacker(cp.src, b)
 	return err
 }
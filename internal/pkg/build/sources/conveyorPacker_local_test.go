@@ -0,0 +1,79 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/build/sources"
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+func TestLocalConveyorPacker(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "localimage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sandbox := filepath.Join(dir, "sandbox")
+	if err := os.MkdirAll(filepath.Join(sandbox, "opt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(sandbox, "opt", "file"), []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.img")
+	if err := ioutil.WriteFile(bad, []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		from string
+		fail bool
+	}{
+		{"no path", "", true},
+		{"missing path", filepath.Join(dir, "missing"), true},
+		{"unsupported image", bad, true},
+		{"sandbox", sandbox, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := types.NewBundle(dir, "sbuild-localimage")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.Recipe.Header = map[string]string{"bootstrap": "localimage", "from": tt.from}
+
+			cp := &sources.LocalConveyorPacker{}
+			err = cp.Get(b)
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if _, err := cp.Pack(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if _, err := os.Stat(filepath.Join(b.Rootfs(), "opt", "file")); err != nil {
+				t.Errorf("sandbox content not copied in bundle: %s", err)
+			}
+		})
+	}
+}
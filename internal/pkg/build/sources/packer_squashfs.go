@@ -13,6 +13,7 @@ import (
 	"strconv"
 
 	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/bin"
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/util/loop"
 )
@@ -57,7 +58,11 @@ func (p *SquashfsPacker) unpackSquashfs(b *types.Bundle, info *loop.Info64, root
 	// copy filesystem into bundle rootfs
 	sylog.Debugf("Unsquashing %s to %s in Bundle\n", trimfile.Name(), b.Rootfs())
 	stderr.Reset()
-	cmd = exec.Command("unsquashfs", "-f", "-d", b.Rootfs(), trimfile.Name())
+	unsquashfs, err := bin.Find("unsquashfs")
+	if err != nil {
+		return fmt.Errorf("while searching for unsquashfs: %v", err)
+	}
+	cmd = exec.Command(unsquashfs, "-f", "-d", b.Rootfs(), trimfile.Name())
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("unsquashfs Failed: %v: %v", err, stderr.String())
@@ -11,6 +11,7 @@ import (
 	"os/exec"
 	"syscall"
 
+	"github.com/sylabs/singularity/internal/pkg/build/logger"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/build/types"
 )
@@ -39,10 +40,20 @@ func (s *stage) runPreScript() error {
 			return fmt.Errorf("attempted to build with scripts as non-root user or without --fakeroot")
 		}
 
+		shArgs := "-cex"
+		if s.b.Opts.Quiet {
+			shArgs = "-ce"
+		}
+
+		stdout := logger.NewWriter(os.Stdout, "pre", "stdout", s.b.Opts.Quiet)
+		defer stdout.Flush()
+		stderr := logger.NewWriter(os.Stderr, "pre", "stderr", s.b.Opts.Quiet)
+		defer stderr.Flush()
+
 		// Run %pre script here
-		pre := exec.Command("/bin/sh", "-cex", s.b.Recipe.BuildData.Pre.Script)
-		pre.Stdout = os.Stdout
-		pre.Stderr = os.Stderr
+		pre := exec.Command("/bin/sh", shArgs, s.b.Recipe.BuildData.Pre.Script)
+		pre.Stdout = stdout
+		pre.Stderr = stderr
 
 		sylog.Infof("Running pre scriptlet\n")
 		if err := pre.Start(); err != nil {
@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -12,24 +12,68 @@ import (
 	"github.com/opencontainers/runtime-tools/generate"
 )
 
+// LifecycleHooks holds the createRuntime, createContainer and
+// startContainer hooks not yet supported by runtime-spec package,
+// they are stored alongside specs.Hooks in the hooks object.
+type LifecycleHooks struct {
+	// CreateRuntime is a list of hooks to be run in runtime namespace
+	// after container creation and before pivot_root.
+	CreateRuntime []specs.Hook `json:"createRuntime,omitempty"`
+	// CreateContainer is a list of hooks to be run in container namespace
+	// after createRuntime hooks and before pivot_root.
+	CreateContainer []specs.Hook `json:"createContainer,omitempty"`
+	// StartContainer is a list of hooks to be run in container namespace
+	// before the container process is executed.
+	StartContainer []specs.Hook `json:"startContainer,omitempty"`
+}
+
+func (h *LifecycleHooks) empty() bool {
+	return len(h.CreateRuntime) == 0 && len(h.CreateContainer) == 0 && len(h.StartContainer) == 0
+}
+
 // Config is the OCI runtime configuration.
 type Config struct {
 	generate.Generator
 	specs.Spec
+	LifecycleHooks LifecycleHooks `json:"-"`
 }
 
 // MarshalJSON is for json.Marshaler
 func (c *Config) MarshalJSON() ([]byte, error) {
 	b, err := json.Marshal(&c.Spec)
+	if err != nil || c.LifecycleHooks.empty() {
+		return b, err
+	}
 
-	return b, err
+	// merge lifecycle hooks into the specification hooks object
+	spec := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return nil, err
+	}
+	spec["hooks"], err = json.Marshal(&struct {
+		*specs.Hooks
+		*LifecycleHooks
+	}{c.Spec.Hooks, &c.LifecycleHooks})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(spec)
 }
 
 // UnmarshalJSON is for json.Unmarshaler
 func (c *Config) UnmarshalJSON(b []byte) error {
+	hooks := struct {
+		LifecycleHooks LifecycleHooks `json:"hooks"`
+	}{}
+
 	if err := json.Unmarshal(b, &c.Spec); err != nil {
 		return err
 	}
+	if err := json.Unmarshal(b, &hooks); err != nil {
+		return err
+	}
+	c.LifecycleHooks = hooks.LifecycleHooks
 	c.Generator = generate.Generator{Config: &c.Spec}
 	return nil
 }
@@ -0,0 +1,70 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"encoding/json"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestConfigHooks(t *testing.T) {
+	data := []byte(`{
+		"ociVersion": "1.0.2",
+		"hooks": {
+			"prestart": [{"path": "/bin/prestart"}],
+			"createRuntime": [{"path": "/bin/createRuntime"}],
+			"createContainer": [{"path": "/bin/createContainer"}],
+			"startContainer": [{"path": "/bin/startContainer"}],
+			"poststart": [{"path": "/bin/poststart"}],
+			"poststop": [{"path": "/bin/poststop"}]
+		}
+	}`)
+
+	check := func(c *Config) {
+		if c.Hooks == nil {
+			t.Fatalf("hooks not parsed")
+		}
+		hooks := map[string][]specs.Hook{
+			"/bin/prestart":        c.Hooks.Prestart,
+			"/bin/createRuntime":   c.LifecycleHooks.CreateRuntime,
+			"/bin/createContainer": c.LifecycleHooks.CreateContainer,
+			"/bin/startContainer":  c.LifecycleHooks.StartContainer,
+			"/bin/poststart":       c.Hooks.Poststart,
+			"/bin/poststop":        c.Hooks.Poststop,
+		}
+		for path, h := range hooks {
+			if len(h) != 1 || h[0].Path != path {
+				t.Errorf("unexpected hooks for %s: %v", path, h)
+			}
+		}
+	}
+
+	c := &Config{}
+	if err := json.Unmarshal(data, c); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	check(c)
+
+	// hooks added with generator must be preserved
+	c.AddPostStopHook(specs.Hook{Path: "/bin/generated"})
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c = &Config{}
+	if err := json.Unmarshal(b, c); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(c.Hooks.Poststop) != 2 {
+		t.Fatalf("generated hook not preserved: %v", c.Hooks.Poststop)
+	}
+	c.Hooks.Poststop = c.Hooks.Poststop[:1]
+	check(c)
+}
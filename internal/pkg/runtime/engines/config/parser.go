@@ -37,7 +37,7 @@ func Parser(filepath string, f interface{}) error {
 		c.Close()
 	}
 
-	r, err := regexp.Compile(`(?m)^\s*([a-zA-Z _]+)\s*=\s*(.*)$`)
+	r, err := regexp.Compile(`(?m)^\s*([a-zA-Z][a-zA-Z0-9 ._]*)\s*=\s*(.*)$`)
 	if err != nil {
 		return fmt.Errorf("regex compilation failed")
 	}
@@ -139,3 +139,39 @@ func TestParser(t *testing.T) {
 		os.Remove(path)
 	}
 }
+
+func TestParserDirectiveName(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"dotted name", "mkfs.ext3 path = /sbin/mkfs.ext3", "/sbin/mkfs.ext3"},
+		{"indented", "  mkfs.ext3 path = /sbin", "/sbin"},
+		{"commented", "# mkfs.ext3 path = /sbin", ""},
+		{"leading dot", ".mkfs.ext3 path = /sbin", ""},
+		{"leading digit", "3mkfs.ext3 path = /sbin", ""},
+		{"other name", "mkfs.ext4 path = /sbin", ""},
+		{"invalid character", "mkfs-ext3 path = /sbin", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := genConfig([]byte(tt.content + "\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(path)
+
+			c := struct {
+				Path string `directive:"mkfs.ext3 path"`
+			}{}
+			if err := Parser(path, &c); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if c.Path != tt.expected {
+				t.Errorf("got %q instead of %q", c.Path, tt.expected)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"syscall"
 
 	"github.com/sylabs/singularity/internal/pkg/build/files"
+	"github.com/sylabs/singularity/internal/pkg/build/logger"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	imgbuildConfig "github.com/sylabs/singularity/internal/pkg/runtime/engines/imgbuild/config"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/rpc/client"
@@ -161,10 +162,10 @@ func (e *EngineOperations) CreateContainer(pid int, rpcConn net.Conn) error {
 	}
 
 	sylog.Debugf("Chroot into %s\n", buildcfg.SESSIONDIR)
-	_, err = rpcOps.Chroot(buildcfg.SESSIONDIR, "pivot")
+	_, err = rpcOps.Chroot(buildcfg.SESSIONDIR, "pivot", client.DefaultChrootRetries)
 	if err != nil {
 		sylog.Debugf("Fallback to move/chroot")
-		_, err = rpcOps.Chroot(buildcfg.SESSIONDIR, "move")
+		_, err = rpcOps.Chroot(buildcfg.SESSIONDIR, "move", client.DefaultChrootRetries)
 		if err != nil {
 			return fmt.Errorf("chroot failed: %s", err)
 		}
@@ -214,7 +215,13 @@ func (e *EngineOperations) copyFiles() error {
 // runScriptSection executes the provided script by piping the
 // script to /bin/sh command.
 func (e *EngineOperations) runScriptSection(name string, s types.Script, setEnv bool) {
+	quiet := e.EngineConfig.Opts.Quiet
+
 	args := []string{"-ex"}
+	if quiet {
+		// don't trace commands, only errors are reported
+		args = []string{"-e"}
+	}
 	// trim potential trailing comment from args and append to args list
 	args = append(args, strings.Fields(strings.Split(s.Args, "#")[0])...)
 
@@ -228,13 +235,20 @@ func (e *EngineOperations) runScriptSection(name string, s types.Script, setEnv
 	var b bytes.Buffer
 	b.WriteString(s.Script)
 
+	stdout := logger.NewWriter(os.Stdout, name, "stdout", quiet)
+	stderr := logger.NewWriter(os.Stderr, name, "stderr", quiet)
+
 	cmd := exec.Command("/bin/sh", args...)
 	cmd.Env = envs
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 	cmd.Stdin = &b
 
-	if err := cmd.Run(); err != nil {
+	err := cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+
+	if err != nil {
 		sylog.Fatalf("failed to execute %%%s proc: %v\n", name, err)
 	}
 }
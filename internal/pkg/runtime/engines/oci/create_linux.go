@@ -26,7 +26,9 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/cgroups"
 	"github.com/sylabs/singularity/internal/pkg/instance"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/oci/rpc/client"
+	singularityClient "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/rpc/client"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/exec"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
 	"github.com/sylabs/singularity/internal/pkg/util/fs/mount"
 	"github.com/sylabs/singularity/pkg/util/fs/proc"
@@ -437,12 +439,26 @@ func (e *EngineOperations) CreateContainer(pid int, rpcConn net.Conn) error {
 		}
 	}
 
+	hooks := e.EngineConfig.OciConfig.LifecycleHooks
+	for _, h := range hooks.CreateRuntime {
+		if err := exec.Hook(&h, &e.EngineConfig.State.State); err != nil {
+			return err
+		}
+	}
+	// createContainer hooks are executed by RPC server to
+	// run them in container namespaces
+	for _, h := range hooks.CreateContainer {
+		if _, err := rpcOps.Hook(&h, &e.EngineConfig.State.State); err != nil {
+			return err
+		}
+	}
+
 	method := "pivot"
 	if !c.mntNS {
 		method = "chroot"
 	}
 
-	_, err = rpcOps.Chroot(c.rootfs, method)
+	_, err = rpcOps.Chroot(c.rootfs, method, singularityClient.DefaultChrootRetries)
 	if err != nil {
 		return fmt.Errorf("chroot failed: %s", err)
 	}
@@ -800,24 +816,11 @@ func (c *container) addDevices(system *mount.System) error {
 	return nil
 }
 
+// addMaskedPathsMount masks directories with an empty read-only tmpfs
+// and files with /dev/null
 func (c *container) addMaskedPathsMount(system *mount.System) error {
 	paths := c.engine.EngineConfig.OciConfig.Linux.MaskedPaths
 
-	dir, err := instance.GetDir(c.engine.CommonConfig.ContainerID, instance.OciSubDir)
-	if err != nil {
-		return err
-	}
-	nullPath := filepath.Join(dir, "null")
-
-	if _, err := os.Stat(nullPath); os.IsNotExist(err) {
-		oldmask := syscall.Umask(0)
-		defer syscall.Umask(oldmask)
-
-		if err := os.Mkdir(nullPath, 0755); err != nil {
-			return err
-		}
-	}
-
 	for _, path := range paths {
 		relativePath := filepath.Join(c.rootfs, path)
 		rpcPath := filepath.Join(c.rpcRoot, relativePath)
@@ -827,7 +830,8 @@ func (c *container) addMaskedPathsMount(system *mount.System) error {
 			continue
 		}
 		if fi.IsDir() {
-			if err := system.Points.AddBind(mount.OtherTag, nullPath, relativePath, syscall.MS_BIND); err != nil {
+			flags := uintptr(syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+			if err := system.Points.AddFS(mount.OtherTag, relativePath, "tmpfs", flags, "mode=755"); err != nil {
 				return err
 			}
 		} else if err := system.Points.AddBind(mount.OtherTag, "/dev/null", relativePath, syscall.MS_BIND); err != nil {
@@ -192,6 +192,22 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 		if _, err := masterConn.Read(data); err != nil {
 			return fmt.Errorf("failed to receive start signal: %s", err)
 		}
+
+		if hooks := e.EngineConfig.OciConfig.LifecycleHooks.StartContainer; len(hooks) > 0 {
+			state := &specs.State{
+				Version:     specs.Version,
+				ID:          e.CommonConfig.ContainerID,
+				Status:      ociruntime.Created,
+				Pid:         os.Getpid(),
+				Bundle:      e.EngineConfig.GetBundlePath(),
+				Annotations: e.EngineConfig.OciConfig.Annotations,
+			}
+			for _, h := range hooks {
+				if err := exec.Hook(&h, state); err != nil {
+					return err
+				}
+			}
+		}
 	}
 
 	if err := security.Configure(&e.EngineConfig.OciConfig.Spec); err != nil {
@@ -5,6 +5,10 @@
 
 package rpc
 
+import (
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
 // SymlinkArgs defines the arguments to symlink.
 type SymlinkArgs struct {
 	Old string
@@ -15,3 +19,9 @@ type SymlinkArgs struct {
 type TouchArgs struct {
 	Path string
 }
+
+// HookArgs defines the arguments to hook.
+type HookArgs struct {
+	Hook  specs.Hook
+	State specs.State
+}
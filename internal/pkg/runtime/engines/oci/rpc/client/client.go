@@ -8,6 +8,7 @@ package client
 import (
 	"os"
 
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	ociargs "github.com/sylabs/singularity/internal/pkg/runtime/engines/oci/rpc"
 	args "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/rpc"
 	client "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/rpc/client"
@@ -49,3 +50,14 @@ func (t *RPC) Touch(path string) (int, error) {
 	err := t.Client.Call(t.Name+".Touch", arguments, &reply)
 	return reply, err
 }
+
+// Hook calls the hook RPC using the supplied arguments.
+func (t *RPC) Hook(hook *specs.Hook, state *specs.State) (int, error) {
+	arguments := &ociargs.HookArgs{
+		Hook:  *hook,
+		State: *state,
+	}
+	var reply int
+	err := t.Client.Call(t.Name+".Hook", arguments, &reply)
+	return reply, err
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"syscall"
 
+	"github.com/sylabs/singularity/internal/pkg/util/exec"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
 
 	ociargs "github.com/sylabs/singularity/internal/pkg/runtime/engines/oci/rpc"
@@ -41,3 +42,8 @@ func (t *Methods) Symlink(arguments *ociargs.SymlinkArgs, reply *int) (err error
 func (t *Methods) Touch(arguments *ociargs.TouchArgs, reply *int) (err error) {
 	return fs.Touch(arguments.Path)
 }
+
+// Hook executes an OCI hook with the specified arguments.
+func (t *Methods) Hook(arguments *ociargs.HookArgs, reply *int) (err error) {
+	return exec.Hook(&arguments.Hook, &arguments.State)
+}
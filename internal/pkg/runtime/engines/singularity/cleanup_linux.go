@@ -7,9 +7,11 @@ package singularity
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
@@ -54,6 +56,14 @@ func (e *EngineOperations) CleanupContainer(fatal error, status syscall.WaitStat
 		}
 	}
 
+	for _, pid := range e.EngineConfig.SquashfusePids {
+		stopSquashfuse(pid)
+	}
+
+	if e.sessionDir != nil {
+		defer cleanupSessionDir(e.sessionDir)
+	}
+
 	if e.EngineConfig.GetInstance() {
 		file, err := instance.Get(e.CommonConfig.ContainerID, instance.SingSubDir)
 		if err != nil {
@@ -62,14 +72,44 @@ func (e *EngineOperations) CleanupContainer(fatal error, status syscall.WaitStat
 		return file.Delete()
 	}
 
+	sessionPath := buildcfg.SESSIONDIR
+	if e.sessionDir != nil {
+		sessionPath = e.sessionDir.path
+	}
+
 	if e.EngineConfig.CryptDev != "" {
-		cleanupCrypt(e.EngineConfig.CryptDev)
+		cleanupCrypt(e.EngineConfig.CryptDev, sessionPath)
 	}
 
 	return nil
 }
 
-func cleanupCrypt(path string) error {
+// cleanupSessionDir removes the per-invocation session directory
+func cleanupSessionDir(dir *sessionDir) {
+	if os.Geteuid() != 0 {
+		priv.Escalate()
+		defer priv.Drop()
+	}
+
+	if err := dir.remove(); err != nil {
+		sylog.Errorf("%s", err)
+	}
+}
+
+// stopSquashfuse terminates the squashfuse process pid if still running
+func stopSquashfuse(pid int) {
+	comm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil || strings.TrimSpace(string(comm)) != "squashfuse" {
+		return
+	}
+
+	sylog.Debugf("Terminating squashfuse process %d", pid)
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		sylog.Warningf("Failed to terminate squashfuse process %d: %s", pid, err)
+	}
+}
+
+func cleanupCrypt(path string, sessionPath string) error {
 
 	// Elevate the privilege to unmount and delete the crypt device
 	runtime.LockOSThread()
@@ -83,12 +123,12 @@ func cleanupCrypt(path string) error {
 
 	defer syscall.Setresuid(uid, uid, 0)
 
-	err = syscall.Unmount(filepath.Join(buildcfg.SESSIONDIR, "final"), syscall.MNT_DETACH)
+	err = syscall.Unmount(filepath.Join(sessionPath, "final"), syscall.MNT_DETACH)
 	if err != nil {
 		return fmt.Errorf("failed while unmounting final session directory: %s", err)
 	}
 
-	err = syscall.Unmount(filepath.Join(buildcfg.SESSIONDIR, "rootfs"), syscall.MNT_DETACH)
+	err = syscall.Unmount(filepath.Join(sessionPath, "rootfs"), syscall.MNT_DETACH)
 	if err != nil {
 		return fmt.Errorf("error while unmounting rootfs session directory: %s", err)
 	}
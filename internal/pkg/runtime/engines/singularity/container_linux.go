@@ -6,10 +6,14 @@
 package singularity
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
@@ -25,17 +29,21 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/util/fs/layout/layer/overlay"
 	"github.com/sylabs/singularity/internal/pkg/util/fs/layout/layer/underlay"
 	"github.com/sylabs/singularity/internal/pkg/util/fs/mount"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/quota"
 	"github.com/sylabs/singularity/internal/pkg/util/mainthread"
 	"github.com/sylabs/singularity/internal/pkg/util/priv"
 	"github.com/sylabs/singularity/internal/pkg/util/user"
 	"github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/network"
 	singularity "github.com/sylabs/singularity/pkg/runtime/engines/singularity/config"
+	"github.com/sylabs/singularity/pkg/signing"
+	"github.com/sylabs/singularity/pkg/sypgp"
 	"github.com/sylabs/singularity/pkg/util/fs/proc"
 	"github.com/sylabs/singularity/pkg/util/loop"
 	"github.com/sylabs/singularity/pkg/util/namespaces"
 	"github.com/sylabs/singularity/pkg/util/nvidia"
 	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/sys/unix"
 )
 
 // defaultCNIConfPath is the default directory to CNI network configuration files
@@ -51,6 +59,7 @@ type container struct {
 	sessionLayerType string
 	sessionFsType    string
 	sessionSize      int
+	sessionFlags     uintptr
 	userNS           bool
 	pidNS            bool
 	utsNS            bool
@@ -59,13 +68,400 @@ type container struct {
 	mountInfoPath    string
 	skippedMount     []string
 	checkDest        []string
+	mounted          []mountedPoint
 	suidFlag         uintptr
 	devSourcePath    string
+	sessionPath      string
+	overlayCheck     func() bool
+	fsckImage        string
+	overlayRoot      string
+	rootfsFstype     string
+	tagFlags         map[mount.AuthorizedTag]uintptr
+	devices          []specs.LinuxDeviceCgroup
+	overlayMounts    []overlayMount
 }
 
 func create(engine *EngineOperations, rpcOps *client.RPC, pid int) error {
 	var err error
 
+	cwd := engine.EngineConfig.GetCwd()
+	if err := os.Chdir(cwd); err != nil {
+		return fmt.Errorf("can't change directory to %s: %s", cwd, err)
+	}
+
+	c := newContainer(engine, rpcOps, pid)
+
+	c.tagFlags, err = parseMountFlags(engine.EngineConfig.File.MountFlags)
+	if err != nil {
+		return err
+	}
+
+	if err := c.setOomScoreAdj(pid); err != nil {
+		return err
+	}
+
+	// use a per-invocation session directory when privileges allow
+	// to create it, user namespace mode uses the shared directory
+	// mounted in the container mount namespace
+	if !c.userNS {
+		if err := c.setupSessionDir(pid); err != nil {
+			return err
+		}
+	}
+
+	if engine.EngineConfig.OciConfig.Linux != nil {
+		for key := range engine.EngineConfig.OciConfig.Linux.Sysctl {
+			if err := c.checkSysctl(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	p := &mount.Points{}
+	system := &mount.System{Points: p}
+	system.Mount = func(point *mount.Point) error {
+		return c.mount(system.CurrentTag(), point)
+	}
+
+	if err := c.addMountPoints(system); err != nil {
+		return err
+	}
+
+	networkSetup, err := c.prepareNetworkSetup(system, pid)
+	if err != nil {
+		return err
+	}
+
+	sylog.Debugf("Mount all")
+	if err := system.MountAll(); err != nil {
+		return err
+	}
+	c.mountSummary()
+
+	if engine.EngineConfig.GetNvCCLI() {
+		if err := c.nvCCLI(pid); err != nil {
+			return err
+		}
+	}
+
+	// chroot from RPC server current working directory since
+	// it's already in final directory after chdirFinal call.
+	// pivot_root is preferred as it allows to detach host root
+	// filesystem, it may fail when the host root filesystem is
+	// an initramfs, move/chroot and chroot are used as fallback
+	sylog.Debugf("Chroot into %s\n", c.session.FinalPath())
+	retries := int(engine.EngineConfig.File.ChrootRetries)
+	for _, method := range []string{"pivot", "move", "chroot"} {
+		if _, err = c.rpcOps.Chroot(".", method, retries); err == nil {
+			break
+		}
+		sylog.Debugf("Chroot with method %s failed: %s", method, err)
+	}
+	if err != nil {
+		return fmt.Errorf("chroot failed: %s", err)
+	}
+
+	if networkSetup != nil {
+		if err := networkSetup(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.setSysctl(); err != nil {
+		return err
+	}
+
+	if err := c.remountProcReadonly(); err != nil {
+		return err
+	}
+
+	if os.Geteuid() == 0 && !c.userNS {
+		// when started within a batch scheduler job, the container
+		// cgroup is created within the job cgroup even without
+		// cgroups configuration to keep it under job accounting
+		jobCgroup := ""
+		if job := engine.EngineConfig.GetSchedulerJob(); job != "" {
+			parent, err := jobCgroupPath(fmt.Sprintf("/proc/%d/cgroup", pid))
+			if err == errUnifiedCgroup {
+				sylog.Warningf("Scheduler job %s uses cgroup v2, container cgroup won't be created within the job cgroup", job)
+			} else if err != nil {
+				sylog.Warningf("Failed to get cgroup of scheduler job %s: %s", job, err)
+			} else {
+				sylog.Verbosef("Creating container cgroup within scheduler job %s cgroup %s", job, parent)
+				jobCgroup = parent
+			}
+		}
+
+		path := engine.EngineConfig.GetCgroupsPath()
+		if path != "" || jobCgroup != "" {
+			cgroupPath := filepath.Join(jobCgroup, "/singularity", strconv.Itoa(pid))
+			manager := &cgroups.Manager{Pid: pid, Path: cgroupPath}
+			resources := specs.LinuxResources{}
+			if path != "" {
+				resources, err = cgroups.ReadSpecFromFile(path)
+				if err != nil {
+					return fmt.Errorf("failed to read cgroups configuration %s: %s", path, err)
+				}
+				c.addDevicesCgroup(&resources)
+			}
+			if err := manager.ApplyFromSpec(&resources); err != nil {
+				return fmt.Errorf("failed to apply cgroups resources restriction: %s", err)
+			}
+			engine.EngineConfig.Cgroups = manager
+		}
+	}
+
+	sylog.Debugf("Chdir into / to avoid errors\n")
+	err = syscall.Chdir("/")
+	if err != nil {
+		return fmt.Errorf("change directory failed: %s", err)
+	}
+
+	return nil
+}
+
+// errUnifiedCgroup is returned by jobCgroupPath when the process is
+// only attached to the cgroup v2 unified hierarchy
+var errUnifiedCgroup = errors.New("only cgroup v2 unified hierarchy found")
+
+// jobCgroupPath returns the memory cgroup path, or cpu cgroup path if
+// there is no memory controller, read from the cgroup file of a process
+// started within a batch scheduler job, limits of the job are enforced
+// by these controllers
+func jobCgroupPath(cgroupFile string) (string, error) {
+	b, err := ioutil.ReadFile(cgroupFile)
+	if err != nil {
+		return "", err
+	}
+
+	paths := make(map[string]string)
+	unified := false
+	for _, line := range strings.Split(string(b), "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			unified = true
+			continue
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			paths[controller] = fields[2]
+		}
+	}
+
+	for _, controller := range []string{"memory", "cpu"} {
+		if path, ok := paths[controller]; ok && path != "/" {
+			return path, nil
+		}
+	}
+	if unified && len(paths) == 0 {
+		return "", errUnifiedCgroup
+	}
+	return "", fmt.Errorf("no memory or cpu cgroup found in %s", cgroupFile)
+}
+
+// setupSessionDir creates and locks the session directory of the
+// container process pid
+func (c *container) setupSessionDir(pid int) error {
+	if os.Geteuid() != 0 {
+		priv.Escalate()
+		defer priv.Drop()
+	}
+
+	dir, err := newSessionDir(c.sessionPath, pid)
+	if err != nil {
+		return err
+	}
+	c.engine.sessionDir = dir
+	c.sessionPath = dir.path
+
+	return nil
+}
+
+// ipcSysctl lists kernel parameters virtualized by IPC namespace
+var ipcSysctl = map[string]bool{
+	"kernel.msgmax":          true,
+	"kernel.msgmnb":          true,
+	"kernel.msgmni":          true,
+	"kernel.sem":             true,
+	"kernel.shmall":          true,
+	"kernel.shmmax":          true,
+	"kernel.shmmni":          true,
+	"kernel.shm_rmid_forced": true,
+}
+
+// checkSysctl returns an error if the kernel parameter key is not
+// virtualized by a namespace of the container, root user is allowed
+// to set host global parameters
+func (c *container) checkSysctl(key string) error {
+	switch {
+	case strings.HasPrefix(key, "net."):
+		if !c.netNS {
+			return fmt.Errorf("sysctl %s requires a network namespace", key)
+		}
+	case ipcSysctl[key] || strings.HasPrefix(key, "fs.mqueue."):
+		if !c.ipcNS {
+			return fmt.Errorf("sysctl %s requires an IPC namespace", key)
+		}
+	case key == "kernel.hostname" || key == "kernel.domainname":
+		if !c.utsNS {
+			return fmt.Errorf("sysctl %s requires an UTS namespace", key)
+		}
+	default:
+		if os.Getuid() != 0 {
+			return fmt.Errorf("sysctl %s is not namespaced and would modify host", key)
+		}
+		sylog.Warningf("sysctl %s is not namespaced, host value will be modified", key)
+	}
+	return nil
+}
+
+// setOomScoreAdj writes the OOM score adjustment of the container process,
+// it's done before chroot while /proc still refers to the host PID namespace
+func (c *container) setOomScoreAdj(pid int) error {
+	if c.engine.EngineConfig.OciConfig.Process == nil {
+		return nil
+	}
+	adj := c.engine.EngineConfig.OciConfig.Process.OOMScoreAdj
+	if adj == nil {
+		return nil
+	}
+
+	sylog.Debugf("Setting OOM score adjustment to %d", *adj)
+	path := fmt.Sprintf("/proc/%d/oom_score_adj", pid)
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(*adj)), 0644); err != nil {
+		return fmt.Errorf("failed to set OOM score adjustment: %s", err)
+	}
+	return nil
+}
+
+// setSysctl sets kernel parameters requested in container configuration,
+// they are applied once network is set up to allow interface parameters
+func (c *container) setSysctl() error {
+	if c.engine.EngineConfig.OciConfig.Linux == nil {
+		return nil
+	}
+
+	for key, value := range c.engine.EngineConfig.OciConfig.Linux.Sysctl {
+		sylog.Debugf("Setting sysctl %s = %s", key, value)
+		if _, err := c.rpcOps.Sysctl(key, value); err != nil {
+			return fmt.Errorf("failed to set sysctl %s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// nvCCLICapabilities lists driver capabilities supported by
+// nvidia-container-cli
+var nvCCLICapabilities = []string{"compute", "compat32", "display", "graphics", "utility", "video"}
+
+// nvCCLIFlags returns nvidia-container-cli configure flags selecting
+// GPUs and driver capabilities from NVIDIA_VISIBLE_DEVICES and
+// NVIDIA_DRIVER_CAPABILITIES variables of the container environment
+func nvCCLIFlags(env []string) []string {
+	devices := "all"
+	capabilities := "compute,utility"
+
+	for _, keyval := range env {
+		kv := strings.SplitN(keyval, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			continue
+		}
+		switch kv[0] {
+		case "NVIDIA_VISIBLE_DEVICES":
+			devices = kv[1]
+		case "NVIDIA_DRIVER_CAPABILITIES":
+			capabilities = kv[1]
+		}
+	}
+
+	// device cgroup is handled by singularity
+	flags := []string{"--no-cgroups"}
+	if devices != "none" && devices != "void" {
+		flags = append(flags, "--device="+devices)
+	}
+
+	caps := strings.Split(capabilities, ",")
+	if capabilities == "all" {
+		caps = nvCCLICapabilities
+	}
+	for _, c := range caps {
+		for _, known := range nvCCLICapabilities {
+			if c == known {
+				flags = append(flags, "--"+c)
+				break
+			}
+		}
+	}
+	return flags
+}
+
+// nvCCLI delegates NVIDIA GPU devices and libraries setup of the
+// container root filesystem to nvidia-container-cli, it's called
+// once all mount points are mounted
+func (c *container) nvCCLI(pid int) error {
+	var env []string
+	if c.engine.EngineConfig.OciConfig.Process != nil {
+		env = c.engine.EngineConfig.OciConfig.Process.Env
+	}
+	flags := nvCCLIFlags(env)
+
+	sylog.Debugf("Setting up NVIDIA GPU with nvidia-container-cli")
+	if _, err := c.rpcOps.NvCCLI(flags, c.session.FinalPath(), pid, c.userNS); err != nil {
+		return fmt.Errorf("while setting up NVIDIA GPU: %s", err)
+	}
+	return nil
+}
+
+// procReadonlyPaths lists /proc entries remounted read-only according
+// to 'mount proc readonly' directive
+var procReadonlyPaths = []string{"/proc/sys", "/proc/sysrq-trigger", "/proc/irq"}
+
+// procReadonly returns whether /proc entries allowing to change kernel
+// tunables must be read-only for the user running the container
+func procReadonly(policy string, uid int) bool {
+	switch policy {
+	case "yes":
+		return true
+	case "unprivileged":
+		return uid != 0
+	}
+	return false
+}
+
+// remountProcReadonly remounts procReadonlyPaths read-only within the
+// container, this is done after chroot and once sysctls are set as
+// the mount plan would prevent them to be written
+func (c *container) remountProcReadonly() error {
+	if !c.engine.EngineConfig.File.MountProc || !procReadonly(c.engine.EngineConfig.File.MountProcReadonly, os.Getuid()) {
+		return nil
+	}
+
+	flags := uintptr(syscall.MS_BIND | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+
+	// container shares the host kernel, entries missing on the host
+	// are missing in the container /proc too
+	for _, path := range procReadonlyPaths {
+		if _, err := os.Stat(path); err != nil {
+			sylog.Debugf("Skipping read-only %s: %s", path, err)
+			continue
+		}
+		sylog.Debugf("Remounting %s read-only", path)
+		if err := c.rpcOps.Mount(path, path, "", flags|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("can't bind %s: %s", path, err)
+		}
+		if err := c.rpcOps.Mount("", path, "", flags|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			return fmt.Errorf("can't remount %s read-only: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// newContainer returns a container instance initialized from engine
+// configuration, it doesn't do any operation on the host.
+func newContainer(engine *EngineOperations, rpcOps *client.RPC, pid int) *container {
 	c := &container{
 		engine:           engine,
 		rpcOps:           rpcOps,
@@ -75,12 +471,10 @@ func create(engine *EngineOperations, rpcOps *client.RPC, pid int) error {
 		skippedMount:     make([]string, 0),
 		checkDest:        make([]string, 0),
 		suidFlag:         syscall.MS_NOSUID,
+		sessionPath:      buildcfg.SESSIONDIR,
 	}
 
-	cwd := engine.EngineConfig.GetCwd()
-	if err := os.Chdir(cwd); err != nil {
-		return fmt.Errorf("can't change directory to %s: %s", cwd, err)
-	}
+	c.overlayCheck = c.checkOverlay
 
 	if engine.EngineConfig.OciConfig.Linux != nil {
 		for _, namespace := range engine.EngineConfig.OciConfig.Linux.Namespaces {
@@ -99,11 +493,20 @@ func create(engine *EngineOperations, rpcOps *client.RPC, pid int) error {
 		}
 	}
 
+	if engine.EngineConfig.File.SessiondirNoexec {
+		c.sessionFlags = syscall.MS_NOEXEC
+	}
+
 	if os.Geteuid() != 0 {
 		c.sessionSize = int(engine.EngineConfig.File.SessiondirMaxSize)
 	} else if engine.EngineConfig.GetAllowSUID() && !c.userNS {
 		c.suidFlag = 0
 	}
+	// writable tmpfs overlay is stored in the session directory,
+	// its size is bounded for root too
+	if engine.EngineConfig.GetWritableTmpfs() {
+		c.sessionSize = int(engine.EngineConfig.File.SessiondirMaxSize)
+	}
 
 	// user namespace was not requested but we need to check
 	// if we are currently running in a user namespace and set
@@ -113,9 +516,88 @@ func create(engine *EngineOperations, rpcOps *client.RPC, pid int) error {
 		c.userNS, _ = namespaces.IsInsideUserNamespace(os.Getpid())
 	}
 
-	p := &mount.Points{}
-	system := &mount.System{Points: p, Mount: c.mount}
+	return c
+}
+
+// defaultTagFlags lists the default mount flags applied to mount points
+// of each tag, they can be overridden with 'mount flags' directive
+var defaultTagFlags = map[mount.AuthorizedTag]uintptr{
+	mount.RootfsTag:    syscall.MS_NOSUID | syscall.MS_NODEV,
+	mount.PreLayerTag:  syscall.MS_NOSUID | syscall.MS_NODEV,
+	mount.LayerTag:     syscall.MS_NOSUID | syscall.MS_NODEV,
+	mount.HostfsTag:    syscall.MS_NOSUID | syscall.MS_NODEV,
+	mount.BindsTag:     syscall.MS_NOSUID | syscall.MS_NODEV,
+	mount.KernelTag:    syscall.MS_NOSUID | syscall.MS_NODEV,
+	mount.HomeTag:      syscall.MS_NOSUID | syscall.MS_NODEV,
+	mount.TmpTag:       syscall.MS_NOSUID | syscall.MS_NODEV,
+	mount.ScratchTag:   syscall.MS_NOSUID | syscall.MS_NODEV,
+	mount.CwdTag:       syscall.MS_NOSUID | syscall.MS_NODEV,
+	mount.FilesTag:     syscall.MS_NOSUID | syscall.MS_NODEV,
+	mount.UserbindsTag: syscall.MS_NOSUID | syscall.MS_NODEV,
+}
+
+// tagFlagsNames maps flag names accepted by 'mount flags' directive
+var tagFlagsNames = map[string]uintptr{
+	"nosuid": syscall.MS_NOSUID,
+	"nodev":  syscall.MS_NODEV,
+	"noexec": syscall.MS_NOEXEC,
+}
+
+// parseMountFlags parses 'mount flags' directive entries of the form
+// tag:flag [flag...] and returns the default flags of each tag with
+// entries overriding them
+func parseMountFlags(entries []string) (map[mount.AuthorizedTag]uintptr, error) {
+	tagFlags := make(map[mount.AuthorizedTag]uintptr, len(defaultTagFlags))
+	for tag, flags := range defaultTagFlags {
+		tagFlags[tag] = flags
+	}
+
+	for _, entry := range entries {
+		if entry == "" {
+			continue
+		}
+		splitted := strings.SplitN(entry, ":", 2)
+		if len(splitted) != 2 {
+			return nil, fmt.Errorf("bad 'mount flags' entry %q, must be tag:flags", entry)
+		}
+		tag := mount.AuthorizedTag(strings.TrimSpace(splitted[0]))
+		if _, ok := defaultTagFlags[tag]; !ok {
+			return nil, fmt.Errorf("'mount flags' doesn't support %q tag", tag)
+		}
+		flags := uintptr(0)
+		for _, name := range strings.Fields(splitted[1]) {
+			flag, ok := tagFlagsNames[name]
+			if !ok {
+				return nil, fmt.Errorf("'mount flags' doesn't support %q flag for %s tag", name, tag)
+			}
+			flags |= flag
+		}
+		tagFlags[tag] = flags
+	}
+
+	return tagFlags, nil
+}
+
+// mountFlags returns the default mount flags for mount points of tag.
+// MS_NOSUID is enforced if the container is not allowed to run setuid
+// binaries, otherwise it's dropped when allowSUID is true
+func (c *container) mountFlags(tag mount.AuthorizedTag, allowSUID bool) uintptr {
+	flags, ok := c.tagFlags[tag]
+	if !ok {
+		flags = defaultTagFlags[tag]
+	}
+	if c.suidFlag != 0 {
+		flags |= syscall.MS_NOSUID
+	} else if allowSUID {
+		flags &^= syscall.MS_NOSUID
+	}
+	return flags
+}
 
+// addMountPoints builds the container mount plan by registering all mount
+// points and hooks into system, nothing is mounted until system.MountAll
+// is called.
+func (c *container) addMountPoints(system *mount.System) error {
 	if err := c.setupSessionLayout(system); err != nil {
 		return err
 	}
@@ -132,6 +614,9 @@ func create(engine *EngineOperations, rpcOps *client.RPC, pid int) error {
 	if err := system.RunAfterTag(mount.RootfsTag, c.addActionsMount); err != nil {
 		return err
 	}
+	if err := system.RunAfterTag(mount.OtherTag, c.addMtabMount); err != nil {
+		return err
+	}
 
 	if err := c.addRootfsMount(system); err != nil {
 		return err
@@ -145,9 +630,18 @@ func create(engine *EngineOperations, rpcOps *client.RPC, pid int) error {
 	if err := c.addHostMount(system); err != nil {
 		return err
 	}
+	if err := c.checkBindLimit(); err != nil {
+		return err
+	}
+	if err := c.checkBindLoops(); err != nil {
+		return err
+	}
 	if err := c.addBindsMount(system); err != nil {
 		return err
 	}
+	if err := c.addSchedulerMount(system); err != nil {
+		return err
+	}
 	if err := c.addHomeMount(system); err != nil {
 		return err
 	}
@@ -166,60 +660,26 @@ func create(engine *EngineOperations, rpcOps *client.RPC, pid int) error {
 	if err := c.addLibsMount(system); err != nil {
 		return err
 	}
+	if err := c.addKernelModulesMount(system); err != nil {
+		return err
+	}
 	if err := c.addResolvConfMount(system); err != nil {
 		return err
 	}
 	if err := c.addHostnameMount(system); err != nil {
 		return err
 	}
-	if err := c.addFuseMount(system); err != nil {
+	if err := c.addCACertificatesMount(system); err != nil {
 		return err
 	}
-
-	networkSetup, err := c.prepareNetworkSetup(system, pid)
-	if err != nil {
+	if err := c.addLocaleMount(system); err != nil {
 		return err
 	}
-
-	sylog.Debugf("Mount all")
-	if err := system.MountAll(); err != nil {
+	if err := c.addBusyboxMount(system); err != nil {
 		return err
 	}
-
-	// chroot from RPC server current working directory since
-	// it's already in final directory after chdirFinal call
-	sylog.Debugf("Chroot into %s\n", c.session.FinalPath())
-	_, err = c.rpcOps.Chroot(".", "pivot")
-	if err != nil {
-		sylog.Debugf("Fallback to move/chroot")
-		_, err = c.rpcOps.Chroot(".", "move")
-		if err != nil {
-			return fmt.Errorf("chroot failed: %s", err)
-		}
-	}
-
-	if networkSetup != nil {
-		if err := networkSetup(); err != nil {
-			return err
-		}
-	}
-
-	if os.Geteuid() == 0 && !c.userNS {
-		path := engine.EngineConfig.GetCgroupsPath()
-		if path != "" {
-			cgroupPath := filepath.Join("/singularity", strconv.Itoa(pid))
-			manager := &cgroups.Manager{Pid: pid, Path: cgroupPath}
-			if err := manager.ApplyFromFile(path); err != nil {
-				return fmt.Errorf("failed to apply cgroups resources restriction: %s", err)
-			}
-			engine.EngineConfig.Cgroups = manager
-		}
-	}
-
-	sylog.Debugf("Chdir into / to avoid errors\n")
-	err = syscall.Chdir("/")
-	if err != nil {
-		return fmt.Errorf("change directory failed: %s", err)
+	if err := c.addFuseMount(system); err != nil {
+		return err
 	}
 
 	return nil
@@ -288,11 +748,11 @@ func (c *container) checkOverlay() bool {
 // to non-existent paths within the container
 func (c *container) setupSessionLayout(system *mount.System) error {
 	writableTmpfs := c.engine.EngineConfig.GetWritableTmpfs()
-	overlayEnabled := c.checkOverlay()
+	overlayEnabled := c.overlayCheck()
 
-	sessionPath, err := filepath.EvalSymlinks(buildcfg.SESSIONDIR)
+	sessionPath, err := filepath.EvalSymlinks(c.sessionPath)
 	if err != nil {
-		return fmt.Errorf("failed to resolved session directory %s: %s", buildcfg.SESSIONDIR, err)
+		return fmt.Errorf("failed to resolved session directory %s: %s", c.sessionPath, err)
 	}
 
 	imgObject, err := c.loadImage(c.engine.EngineConfig.GetImage(), true)
@@ -324,8 +784,17 @@ func (c *container) setupSessionLayout(system *mount.System) error {
 		return c.setupOverlayLayout(system, sessionPath)
 	}
 
-	if writableTmpfs {
-		sylog.Warningf("Ignoring --writable-tmpfs as it requires overlay support")
+	if c.engine.EngineConfig.File.EnableOverlay == "yes" && c.engine.EngineConfig.File.OverlayStrict {
+		return fmt.Errorf("overlay is enabled in configuration but is not supported by kernel or not usable with user namespace ('overlay strict = yes')")
+	}
+
+	if writableTmpfs && c.engine.EngineConfig.GetImageWritableTmpfs() {
+		sylog.Warningf("Ignoring writable tmpfs image runtime option as it requires overlay support")
+	} else if writableTmpfs {
+		return fmt.Errorf("--writable-tmpfs requires overlay which is disabled in configuration or not supported by kernel")
+	}
+	if len(c.engine.EngineConfig.GetRemoteOverlay()) > 0 {
+		sylog.Warningf("Ignoring remote overlay images as they require overlay support")
 	}
 
 	if c.engine.EngineConfig.File.EnableUnderlay {
@@ -334,13 +803,30 @@ func (c *container) setupSessionLayout(system *mount.System) error {
 	}
 
 	sylog.Debugf("Not attempting to use underlay or overlay\n")
+	if c.engine.EngineConfig.GetLayerHint() {
+		sylog.Warningf("Image expects overlay or underlay to create bind points but neither is available, binds to paths missing in the image will fail")
+	}
 	return c.setupDefaultLayout(system, sessionPath)
 }
 
 // setupOverlayLayout sets up the session with overlay filesystem
 func (c *container) setupOverlayLayout(system *mount.System, sessionPath string) (err error) {
 	sylog.Debugf("Creating overlay SESSIONDIR layout\n")
-	if c.session, err = layout.NewSession(sessionPath, c.sessionFsType, c.sessionSize, system, overlay.New()); err != nil {
+	ov := overlay.New()
+	ov.SetFlags(c.mountFlags(mount.LayerTag, true))
+
+	switch c.engine.EngineConfig.File.OverlayMetacopy {
+	case "yes":
+		ov.AddOption("metacopy=on")
+	case "no":
+		// features below rely on extended attributes set in upper
+		// directory, disable them to get a full copy up of files
+		ov.AddOption("metacopy=off")
+		ov.AddOption("redirect_dir=off")
+		ov.AddOption("index=off")
+	}
+
+	if c.session, err = layout.NewSession(sessionPath, c.sessionFsType, c.sessionSize, c.sessionFlags, system, ov); err != nil {
 		return err
 	}
 
@@ -355,7 +841,7 @@ func (c *container) setupOverlayLayout(system *mount.System, sessionPath string)
 // setupUnderlayLayout sets up the session with underlay "filesystem"
 func (c *container) setupUnderlayLayout(system *mount.System, sessionPath string) (err error) {
 	sylog.Debugf("Creating underlay SESSIONDIR layout\n")
-	if c.session, err = layout.NewSession(sessionPath, c.sessionFsType, c.sessionSize, system, underlay.New()); err != nil {
+	if c.session, err = layout.NewSession(sessionPath, c.sessionFsType, c.sessionSize, c.sessionFlags, system, underlay.New()); err != nil {
 		return err
 	}
 
@@ -366,7 +852,7 @@ func (c *container) setupUnderlayLayout(system *mount.System, sessionPath string
 // setupDefaultLayout sets up the session without overlay or underlay
 func (c *container) setupDefaultLayout(system *mount.System, sessionPath string) (err error) {
 	sylog.Debugf("Creating default SESSIONDIR layout\n")
-	if c.session, err = layout.NewSession(sessionPath, c.sessionFsType, c.sessionSize, system, nil); err != nil {
+	if c.session, err = layout.NewSession(sessionPath, c.sessionFsType, c.sessionSize, c.sessionFlags, system, nil); err != nil {
 		return err
 	}
 
@@ -381,32 +867,110 @@ func (c *container) isLayerEnabled() bool {
 	return c.sessionLayerType != "none"
 }
 
-func (c *container) mount(point *mount.Point) error {
+func (c *container) mount(tag mount.AuthorizedTag, point *mount.Point) error {
 	if _, err := mount.GetOffset(point.InternalOptions); err == nil {
-		if err := c.mountImage(point); err != nil {
+		if err := c.mountImage(tag, point); err != nil {
+			if _, ok := err.(*mount.Error); ok {
+				return err
+			}
 			return fmt.Errorf("can't mount image %s: %s", point.Source, err)
 		}
+		c.addMounted(tag, point)
 	} else {
-		if err := c.mountGeneric(point); err != nil {
+		mounted, err := c.mountGeneric(tag, point)
+		if err != nil {
+			_, typed := err.(*mount.Error)
 			flags, _ := mount.ConvertOptions(point.Options)
 			if flags&syscall.MS_REMOUNT != 0 {
+				if typed {
+					return err
+				}
 				return fmt.Errorf("can't remount %s: %s", point.Destination, err)
 			}
 			if point.Type != "" {
 				if point.Source == "devpts" {
 					sylog.Verbosef("Couldn't mount devpts filesystem, continuing with PTY allocation functionality disabled")
-				} else {
+				} else if typed {
 					// mount error for other filesystems is considered fatal
+					return err
+				} else {
 					return fmt.Errorf("can't mount %s filesystem to %s: %s", point.Type, point.Destination, err)
 				}
 			}
 			sylog.Verbosef("can't mount %s: %s", point.Source, err)
 			return nil
 		}
+		if mounted {
+			c.addMounted(tag, point)
+		}
 	}
 	return nil
 }
 
+// mountedPoint describes a mount point successfully mounted
+type mountedPoint struct {
+	tag      mount.AuthorizedTag
+	source   string
+	dest     string
+	fstype   string
+	readonly bool
+}
+
+// addMounted records a successful mount, a remount only updates the
+// read-only state of the mount point previously recorded for the same
+// destination
+func (c *container) addMounted(tag mount.AuthorizedTag, point *mount.Point) {
+	flags, _ := mount.ConvertOptions(point.Options)
+	readonly := flags&syscall.MS_RDONLY != 0
+
+	if mount.HasPropagationFlag(flags) {
+		return
+	} else if mount.HasRemountFlag(flags) {
+		for i := len(c.mounted) - 1; i >= 0; i-- {
+			if c.mounted[i].dest == point.Destination {
+				c.mounted[i].readonly = readonly
+				return
+			}
+		}
+		return
+	}
+
+	fstype := point.Type
+	if flags&syscall.MS_BIND != 0 {
+		fstype = "bind"
+	}
+
+	c.mounted = append(c.mounted, mountedPoint{
+		tag:      tag,
+		source:   point.Source,
+		dest:     point.Destination,
+		fstype:   fstype,
+		readonly: readonly,
+	})
+}
+
+// mountSummary displays mount points successfully mounted grouped by tag
+func (c *container) mountSummary() {
+	sylog.Verbosef("Mount summary:")
+	for _, tag := range mount.GetTagList() {
+		header := false
+		for _, m := range c.mounted {
+			if m.tag != tag {
+				continue
+			}
+			if !header {
+				sylog.Verbosef("  %s:", tag)
+				header = true
+			}
+			mode := "rw"
+			if m.readonly {
+				mode = "ro"
+			}
+			sylog.Verbosef("    %s -> %s (%s, %s)", m.source, m.dest, m.fstype, mode)
+		}
+	}
+}
+
 // setPropagationMount will apply propagation flag set by
 // configuration directive, when applied master process
 // won't see mount done by RPC server anymore. Typically
@@ -470,8 +1034,9 @@ func (c *container) checkMounted(dest string) string {
 	return ""
 }
 
-// mount any generic mount (not loop dev)
-func (c *container) mountGeneric(mnt *mount.Point) (err error) {
+// mount any generic mount (not loop dev), mount failures are returned
+// as *mount.Error
+func (c *container) mountGeneric(tag mount.AuthorizedTag, mnt *mount.Point) (mounted bool, err error) {
 	flags, opts := mount.ConvertOptions(mnt.Options)
 	optsString := strings.Join(opts, ",")
 	sessionPath := c.session.Path()
@@ -484,7 +1049,7 @@ func (c *container) mountGeneric(mnt *mount.Point) (err error) {
 		if _, err := os.Stat(source); os.IsNotExist(err) {
 			c.skippedMount = append(c.skippedMount, mnt.Destination)
 			sylog.Debugf("Skipping mount, host source %s doesn't exist", source)
-			return nil
+			return false, nil
 		}
 	}
 
@@ -498,7 +1063,7 @@ func (c *container) mountGeneric(mnt *mount.Point) (err error) {
 	if remount || propagation {
 		for _, skipped := range c.skippedMount {
 			if skipped == mnt.Destination {
-				return nil
+				return false, nil
 			}
 		}
 		sylog.Debugf("Remounting %s\n", dest)
@@ -509,7 +1074,7 @@ func (c *container) mountGeneric(mnt *mount.Point) (err error) {
 				if mounted != "" {
 					c.skippedMount = append(c.skippedMount, mnt.Destination)
 					sylog.Debugf("Skipping mount %s, %s already mounted", dest, mounted)
-					return nil
+					return false, nil
 				}
 				break
 			}
@@ -531,6 +1096,11 @@ func (c *container) mountGeneric(mnt *mount.Point) (err error) {
 		}
 	}
 	err = c.rpcOps.Mount(source, dest, mnt.Type, flags, optsString)
+	if err == nil && remount && flags&syscall.MS_RDONLY != 0 {
+		if err := c.checkReadonly(dest); err != nil {
+			return false, err
+		}
+	}
 	// when using user namespace we always try to apply mount flags with
 	// remount, then if we get a permission denied error, we continue
 	// execution by ignoring the error and warn user if the bind mount
@@ -541,21 +1111,127 @@ func (c *container) mountGeneric(mnt *mount.Point) (err error) {
 		} else {
 			sylog.Verbosef("Could not remount %s: %s", dest, err)
 		}
+		return false, nil
+	} else if os.IsNotExist(err) && !strings.HasPrefix(mnt.Destination, sessionPath) {
+		c.skippedMount = append(c.skippedMount, mnt.Destination)
+		sylog.Debugf("Skipping mount, %s doesn't exist in container", dest)
+		return false, nil
+	} else if err != nil {
+		return false, c.mountError(tag, mnt.Source, dest, flags, err)
+	}
+	return true, nil
+}
+
+// mountError returns a *mount.Error describing a failed mount of
+// source to dest
+func (c *container) mountError(tag mount.AuthorizedTag, source, dest string, flags uintptr, err error) error {
+	syscallName := "mount"
+	if mount.HasRemountFlag(flags) {
+		syscallName = "remount"
+	}
+	return &mount.Error{
+		Tag:         tag,
+		Source:      source,
+		Destination: dest,
+		Syscall:     syscallName,
+		Err:         err,
+	}
+}
+
+// checkReadonly verifies that dest was effectively remounted read-only
+// according to 'readonly bind check' directive
+func (c *container) checkReadonly(dest string) error {
+	check := c.engine.EngineConfig.File.ReadonlyBindCheck
+	if check == "no" {
 		return nil
-	} else if os.IsNotExist(err) {
-		if !strings.HasPrefix(mnt.Destination, sessionPath) {
-			c.skippedMount = append(c.skippedMount, mnt.Destination)
-			sylog.Debugf("Skipping mount, %s doesn't exist in container", dest)
+	}
+
+	options, err := proc.MountOptions(c.mountInfoPath, dest)
+	if err != nil {
+		sylog.Debugf("Could not verify %s is read-only: %s", dest, err)
+		return nil
+	}
+	for _, opt := range options {
+		if opt == "ro" {
 			return nil
 		}
-		return fmt.Errorf("destination %s doesn't exist", dest)
 	}
-	return err
+
+	if check == "error" {
+		return fmt.Errorf("%s is writable while requested read-only", dest)
+	}
+	sylog.Warningf("%s is writable while requested read-only", dest)
+	return nil
+}
+
+// freeSpace returns the space in MB available to unprivileged users on
+// the file system holding path
+func freeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize) / (1024 * 1024), nil
+}
+
+// checkFreeSpace verifies that the file system holding the working
+// directory workdir has at least the space required by 'workdir min
+// free space' directive
+func (c *container) checkFreeSpace(workdir string) error {
+	min := uint64(c.engine.EngineConfig.File.WorkdirMinFreeSpace)
+	if min == 0 {
+		return nil
+	}
+
+	free, err := freeSpace(workdir)
+	if err != nil {
+		sylog.Debugf("Could not determine free space of %s: %s", workdir, err)
+		return nil
+	}
+	if free >= min {
+		return nil
+	}
+
+	if c.engine.EngineConfig.File.WorkdirFreeSpaceCheck == "error" {
+		return fmt.Errorf("working directory %s has %d MB available, %d MB required", workdir, free, min)
+	}
+	sylog.Warningf("Working directory %s has %d MB available, %d MB required", workdir, free, min)
+	return nil
+}
+
+// imageName returns the path of the opened image referenced by source,
+// or source if it doesn't reference an opened image
+func (c *container) imageName(source string) string {
+	for _, img := range c.engine.EngineConfig.GetImageList() {
+		if img.Source == source || fmt.Sprintf("/proc/self/fd/%d", img.Fd) == source {
+			return img.Path
+		}
+	}
+	return source
+}
+
+// directIOBlockSize returns the logical block size of the device holding
+// image if the offset of the image partition is aligned on it, as required
+// by loop devices to use direct I/O, or zero if the partition is misaligned.
+// The partition offset can't be realigned as the image file system must
+// start at the first sector of the loop device
+func directIOBlockSize(image string, offset uint64) (uint64, error) {
+	size, err := loop.BlockSize(image)
+	if err != nil {
+		return 0, fmt.Errorf("failed to determine block size for %s: %s", image, err)
+	}
+	if offset%size != 0 {
+		sylog.Warningf("Disabling direct I/O for %s: partition offset %d is not aligned on %d bytes", image, offset, size)
+		return 0, nil
+	}
+	return size, nil
 }
 
 // mount image via loop
-func (c *container) mountImage(mnt *mount.Point) error {
+func (c *container) mountImage(tag mount.AuthorizedTag, mnt *mount.Point) (err error) {
 	maxDevices := int(c.engine.EngineConfig.File.MaxLoopDevices)
+	retries := int(c.engine.EngineConfig.File.LoopAttachRetries)
 	flags, opts := mount.ConvertOptions(mnt.Options)
 	optsString := strings.Join(opts, ",")
 
@@ -569,6 +1245,10 @@ func (c *container) mountImage(mnt *mount.Point) error {
 		return err
 	}
 
+	if c.userNS && mnt.Type == "squashfs" {
+		return c.mountSquashfuse(mnt, offset)
+	}
+
 	attachFlag := os.O_RDWR
 	loopFlags := uint32(loop.FlagsAutoClear)
 
@@ -577,6 +1257,23 @@ func (c *container) mountImage(mnt *mount.Point) error {
 		attachFlag = os.O_RDONLY
 	}
 
+	// loop devices use 512 bytes logical blocks by default, direct I/O
+	// on a device with larger blocks requires to realign the loop block
+	// size, only done for squashfs as ext3 images may use 1k blocks
+	blockSize := uint32(0)
+	if c.engine.EngineConfig.File.LoopDirectIO {
+		size, err := directIOBlockSize(mnt.Source, offset)
+		if err != nil {
+			return err
+		}
+		if size > 0 {
+			loopFlags |= loop.FlagsDirectIO
+			if size > 512 && mnt.Type == "squashfs" {
+				blockSize = uint32(size)
+			}
+		}
+	}
+
 	info := &loop.Info64{
 		Offset:    offset,
 		SizeLimit: sizelimit,
@@ -584,17 +1281,53 @@ func (c *container) mountImage(mnt *mount.Point) error {
 	}
 
 	shared := c.engine.EngineConfig.File.SharedLoopDevices
-	number, err := c.rpcOps.LoopDevice(mnt.Source, attachFlag, *info, maxDevices, shared)
+	pool := c.engine.EngineConfig.File.LoopDevicePool
+	number, err := c.rpcOps.LoopDevice(mnt.Source, attachFlag, *info, maxDevices, retries, blockSize, shared, pool)
 	if err != nil {
-		return fmt.Errorf("failed to find loop device: %s", err)
+		return fmt.Errorf("failed to find loop device for %s: %s", c.imageName(mnt.Source), err)
 	}
 
 	path := fmt.Sprintf("/dev/loop%d", number)
+	if len(pool) > 0 {
+		path, err = loop.PoolDevicePath(pool, number)
+		if err != nil {
+			return fmt.Errorf("failed to find loop device: %s", err)
+		}
+	}
+
+	// don't leave the image attached if it can't be mounted, shared
+	// devices may be in use by other containers
+	defer func() {
+		if err == nil || shared {
+			return
+		}
+		sylog.Debugf("Detaching loop device %s from %s", path, mnt.Source)
+		if _, err := c.rpcOps.LoopDetach(path); err != nil {
+			sylog.Warningf("Could not detach loop device %s: %s", path, err)
+		}
+	}()
+
+	if c.engine.sessionDir != nil {
+		if err := c.engine.sessionDir.recordLoop(path, mnt.Source); err != nil {
+			sylog.Warningf("%s", err)
+		}
+	}
 
 	sylog.Debugf("Mounting loop device %s to %s of type %s\n", path, mnt.Destination, mnt.Type)
 
 	mountType := mnt.Type
 
+	if (mountType == "ext3" || mountType == "ext4") && mnt.Source == c.fsckImage {
+		sylog.Debugf("Checking ext3 file system of %s", mnt.Source)
+		status, err := c.rpcOps.Fsck(path)
+		if err != nil {
+			return fmt.Errorf("while checking %s file system: %s", mnt.Source, err)
+		}
+		if status != 0 {
+			sylog.Infof("Errors found in %s file system were corrected", mnt.Source)
+		}
+	}
+
 	if mountType == "encryptfs" {
 		key, err := mount.GetKey(mnt.InternalOptions)
 		if err != nil {
@@ -623,7 +1356,21 @@ func (c *container) mountImage(mnt *mount.Point) error {
 		// Currently we only support encrypted squashfs file system
 		mountType = "squashfs"
 	}
-	err = c.rpcOps.Mount(path, mnt.Destination, mountType, flags, optsString)
+	if mountType == "squashfs" && c.engine.EngineConfig.File.SquashfsErrorsContinue && mnt.Destination == c.session.RootFsPath() {
+		options := strings.Join(append(opts, "errors=continue"), ",")
+		err = c.rpcOps.Mount(path, mnt.Destination, mountType, flags, options)
+		if err == syscall.EINVAL {
+			// option unknown by kernel or bad superblock, the
+			// mount without it reports the latter
+			sylog.Verbosef("Could not mount squashfs root filesystem with errors=continue, mounting without")
+			err = c.rpcOps.Mount(path, mnt.Destination, mountType, flags, optsString)
+		}
+	} else {
+		err = c.rpcOps.Mount(path, mnt.Destination, mountType, flags, optsString)
+	}
+	if err != nil && c.rootfsFstype != "" && mnt.Destination == c.session.RootFsPath() {
+		sylog.Errorf("Root filesystem type %s was forced by 'rootfs fstype' directive", c.rootfsFstype)
+	}
 	switch err {
 	case syscall.EINVAL:
 		if mountType == "squashfs" {
@@ -638,7 +1385,7 @@ func (c *container) mountImage(mnt *mount.Point) error {
 		return fmt.Errorf("%s filesystem seems not enabled and/or supported by your kernel", mountType)
 	default:
 		if err != nil {
-			return fmt.Errorf("failed to mount %s filesystem: %s", mountType, err)
+			return c.mountError(tag, mnt.Source, mnt.Destination, flags, err)
 		}
 	}
 
@@ -680,11 +1427,87 @@ func (c *container) loadImage(path string, rootfs bool) (*image.Image, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("no image found with path %s", path)
+	return nil, fmt.Errorf("no image found with path %s", path)
+}
+
+// mountSquashfuse mounts the squashfs image partition at offset with
+// squashfuse, as squashfs can't be mounted from a loop device in a user
+// namespace, the squashfuse process is terminated on container cleanup
+func (c *container) mountSquashfuse(mnt *mount.Point, offset uint64) error {
+	sylog.Debugf("Mounting squashfs image %s to %s with squashfuse", mnt.Source, mnt.Destination)
+	pid, err := c.rpcOps.Squashfuse(mnt.Source, offset, mnt.Destination)
+	if err != nil {
+		return err
+	}
+
+	// with a PID namespace the process is not visible from the
+	// master process and is killed along with the namespace
+	if !c.pidNS {
+		c.engine.EngineConfig.SquashfusePids = append(c.engine.EngineConfig.SquashfusePids, pid)
+	}
+	return nil
+}
+
+// verifyImageSignature checks that img is a SIF image signed by a key of
+// the keyring set with 'signature keyring' directive when 'require signed
+// containers' directive is enabled
+func (c *container) verifyImageSignature(img *image.Image) error {
+	if !c.engine.EngineConfig.File.RequireSignedContainers {
+		return nil
+	}
+
+	if img.Type != image.SIF {
+		return fmt.Errorf("image %s is not a SIF image, only signed containers are allowed", img.Path)
+	}
+
+	path := c.engine.EngineConfig.File.SignatureKeyring
+	if path == "" {
+		return fmt.Errorf("signed containers are required but no signature keyring is configured")
+	}
+	keyring, err := sypgp.LoadKeysFromFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to load signature keyring %s: %s", path, err)
+	}
+
+	// don't use image file descriptor directly as closing it would
+	// prevent the image to be mounted
+	f, err := os.Open(img.Source)
+	if err != nil {
+		return fmt.Errorf("failed to open image %s: %s", img.Path, err)
+	}
+	defer f.Close()
+
+	signer, err := signing.VerifyFp(f, keyring)
+	if err != nil {
+		return fmt.Errorf("signature verification of image %s failed: %s", img.Path, err)
+	}
+	sylog.Verbosef("Image %s signed by %s", img.Path, signer)
+	return nil
+}
+
+// extFstype returns the file system type to mount the ext partition
+// part of img with, ext4 if the partition uses ext4 features
+func extFstype(img *image.Image, part image.Section) string {
+	f, err := os.Open(img.Source)
+	if err != nil {
+		sylog.Debugf("Could not open %s to check ext4 features: %s", img.Source, err)
+		return "ext3"
+	}
+	defer f.Close()
+
+	ext4, err := image.IsExt4(f, part.Offset)
+	if err != nil {
+		sylog.Debugf("Could not check ext4 features of %s: %s", img.Path, err)
+		return "ext3"
+	}
+	if ext4 {
+		return "ext4"
+	}
+	return "ext3"
 }
 
 func (c *container) addRootfsMount(system *mount.System) error {
-	flags := uintptr(c.suidFlag | syscall.MS_NODEV)
+	flags := c.mountFlags(mount.RootfsTag, true)
 	rootfs := c.engine.EngineConfig.GetImage()
 
 	imageObject, err := c.loadImage(rootfs, true)
@@ -692,12 +1515,21 @@ func (c *container) addRootfsMount(system *mount.System) error {
 		return err
 	}
 
+	if err := checkImageArch(imageObject); err != nil {
+		return err
+	}
+
+	if err := c.verifyImageSignature(imageObject); err != nil {
+		return err
+	}
+
 	if !imageObject.Writable {
 		sylog.Debugf("Mount rootfs in read-only mode")
 		flags |= syscall.MS_RDONLY
 	} else {
 		sylog.Debugf("Mount rootfs in read-write mode")
 	}
+	flags |= c.atimeFlags(imageObject.Writable)
 
 	mountType := ""
 	offset := imageObject.Partitions[0].Offset
@@ -710,7 +1542,7 @@ func (c *container) addRootfsMount(system *mount.System) error {
 	case image.SQUASHFS:
 		mountType = "squashfs"
 	case image.EXT3:
-		mountType = "ext3"
+		mountType = extFstype(imageObject, imageObject.Partitions[0])
 	case image.ENCRYPTSQUASHFS:
 		mountType = "encryptfs"
 		key = c.engine.EngineConfig.GetEncryptionKey()
@@ -724,6 +1556,22 @@ func (c *container) addRootfsMount(system *mount.System) error {
 			system.Points.AddRemount(mount.RootfsTag, c.session.RootFsPath(), flags)
 		}
 		return nil
+	default:
+		fstype, ok := image.PartitionFstype(imageObject.Partitions[0].Type)
+		if !ok {
+			return fmt.Errorf("unsupported image partition type %#x", imageObject.Partitions[0].Type)
+		}
+		mount.AuthorizeImage(fstype)
+		mountType = fstype
+	}
+
+	if fstype := c.engine.EngineConfig.File.RootfsFstype; fstype != "" && mountType != "encryptfs" {
+		if err := checkRootfsFstype(fstype); err != nil {
+			return err
+		}
+		sylog.Verbosef("Forcing %s file system type for %s image (detected %s)", fstype, rootfs, mountType)
+		mountType = fstype
+		c.rootfsFstype = fstype
 	}
 
 	sylog.Debugf("Mounting block [%v] image: %v\n", mountType, rootfs)
@@ -740,6 +1588,17 @@ func (c *container) addRootfsMount(system *mount.System) error {
 		return err
 	}
 
+	if c.engine.EngineConfig.File.RootfsPrefetch && imageObject.Partitions[0].Type == image.SQUASHFS {
+		part := imageObject.Partitions[0]
+		err := system.RunAfterTag(mount.RootfsTag, func(*mount.System) error {
+			prefetchImage(imageObject.Source, part.Offset, part.Size)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	if imageObject.Writable {
 		return system.Points.AddPropagation(mount.DevTag, c.session.RootFsPath(), syscall.MS_UNBINDABLE)
 	}
@@ -747,12 +1606,112 @@ func (c *container) addRootfsMount(system *mount.System) error {
 	return nil
 }
 
+// binfmtMiscPath is the location of binfmt_misc interpreter entries
+var binfmtMiscPath = "/proc/sys/fs/binfmt_misc"
+
+// qemuArch maps GOARCH-style architectures to QEMU user emulator names
+var qemuArch = map[string]string{
+	"386":      "i386",
+	"amd64":    "x86_64",
+	"arm":      "arm",
+	"arm64":    "aarch64",
+	"ppc64":    "ppc64",
+	"ppc64le":  "ppc64le",
+	"mips":     "mips",
+	"mipsle":   "mipsel",
+	"mips64":   "mips64",
+	"mips64le": "mips64el",
+	"s390x":    "s390x",
+}
+
+// hasEmulation returns whether a QEMU user emulator is registered
+// and enabled in binfmt_misc for arch
+func hasEmulation(arch string) bool {
+	name, ok := qemuArch[arch]
+	if !ok {
+		return false
+	}
+	b, err := ioutil.ReadFile(filepath.Join(binfmtMiscPath, "qemu-"+name))
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(string(b), "enabled")
+}
+
+// checkImageArch returns an error if img was built for an architecture
+// different from the host one and no emulation is available for it
+func checkImageArch(img *image.Image) error {
+	if img.Architecture == "" || img.Architecture == runtime.GOARCH {
+		return nil
+	}
+	if hasEmulation(img.Architecture) {
+		sylog.Infof("Image built for %s architecture, host is %s: running with binfmt_misc emulation", img.Architecture, runtime.GOARCH)
+		return nil
+	}
+	return fmt.Errorf("image built for %s architecture, host is %s: no binfmt_misc emulation found for %s", img.Architecture, runtime.GOARCH, img.Architecture)
+}
+
+// prefetchImage asks the kernel to read ahead size bytes of the image
+// partition located at offset into the page cache, the readahead is
+// asynchronous and failures are not fatal
+func prefetchImage(path string, offset uint64, size uint64) {
+	f, err := os.Open(path)
+	if err != nil {
+		sylog.Debugf("Could not open %s for prefetch: %s", path, err)
+		return
+	}
+	defer f.Close()
+
+	sylog.Debugf("Prefetching %d bytes of %s at offset %d", size, path, offset)
+	if err := unix.Fadvise(int(f.Fd()), int64(offset), int64(size), unix.FADV_WILLNEED); err != nil {
+		sylog.Debugf("Could not prefetch %s: %s", path, err)
+	}
+}
+
+// rootfsFstypes lists file system types allowed by 'rootfs fstype'
+var rootfsFstypes = map[string]bool{
+	"ext2":     true,
+	"ext3":     true,
+	"ext4":     true,
+	"squashfs": true,
+}
+
+// checkRootfsFstype returns an error if the file system type forced by
+// 'rootfs fstype' directive is not allowed or not supported by kernel
+func checkRootfsFstype(fstype string) error {
+	if !rootfsFstypes[fstype] {
+		return fmt.Errorf("'rootfs fstype' %s is not supported, must be one of ext2, ext3, ext4 or squashfs", fstype)
+	}
+	has, err := proc.HasFilesystem(fstype)
+	if err != nil {
+		return fmt.Errorf("while checking %s file system support: %s", fstype, err)
+	}
+	if !has {
+		return fmt.Errorf("'rootfs fstype' %s is not supported by kernel", fstype)
+	}
+	return nil
+}
+
 func (c *container) overlayUpperWork(system *mount.System) error {
 	ov := c.session.Layer.(*overlay.Overlay)
 
-	u := ov.GetUpperDir()
-	w := ov.GetWorkDir()
+	c.rpcOps.SetFsID(0, 0)
+	defer c.rpcOps.SetFsID(os.Getuid(), os.Getgid())
+
+	if err := c.makeUpperWork(ov.GetUpperDir(), ov.GetWorkDir()); err != nil {
+		return err
+	}
+	for _, m := range c.overlayMounts {
+		if err := c.makeUpperWork(m.upper, m.work); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// makeUpperWork creates overlay upper and work directories u and w
+// located in the writable overlay image
+func (c *container) makeUpperWork(u, w string) error {
 	if fs.IsLink(u) {
 		return fmt.Errorf("symlink detected, upper overlay %s must be a directory", u)
 	}
@@ -760,8 +1719,25 @@ func (c *container) overlayUpperWork(system *mount.System) error {
 		return fmt.Errorf("symlink detected, work overlay %s must be a directory", w)
 	}
 
-	c.rpcOps.SetFsID(0, 0)
-	defer c.rpcOps.SetFsID(os.Getuid(), os.Getgid())
+	// create the overlay image subdirectory holding upper and work
+	// directories, symlinks are rejected to not escape image root
+	if c.overlayRoot != "" {
+		path := c.overlayRoot
+		for _, d := range strings.Split(filepath.Dir(strings.TrimPrefix(u, c.overlayRoot)), "/") {
+			if d == "" {
+				continue
+			}
+			path = filepath.Join(path, d)
+			if fs.IsLink(path) {
+				return fmt.Errorf("symlink detected, overlay subdirectory %s must be a directory", path)
+			}
+			if !fs.IsDir(path) {
+				if _, err := c.rpcOps.Mkdir(path, 0755); err != nil {
+					return fmt.Errorf("failed to create %s directory: %s", path, err)
+				}
+			}
+		}
+	}
 
 	if !fs.IsDir(u) {
 		if _, err := c.rpcOps.Mkdir(u, 0755); err != nil {
@@ -777,6 +1753,304 @@ func (c *container) overlayUpperWork(system *mount.System) error {
 	return nil
 }
 
+// workdirOverlayUpperWork returns writable tmpfs overlay upper and work
+// directories stored in a per-container directory of the working directory
+// when requested by 'writable tmpfs backing' directive, or empty strings if
+// they must be stored in the session directory
+func (c *container) workdirOverlayUpperWork() (string, string, error) {
+	if c.engine.EngineConfig.File.WritableTmpfsBacking != "workdir" {
+		return "", "", nil
+	}
+
+	workdir := c.engine.EngineConfig.GetWorkdir()
+	if workdir == "" || c.engine.EngineConfig.File.ScratchBacking == "tmpfs" || c.engine.sessionDir == nil {
+		sylog.Debugf("No working directory, storing writable tmpfs overlay in memory")
+		return "", "", nil
+	}
+
+	if err := c.checkFreeSpace(workdir); err != nil {
+		return "", "", err
+	}
+
+	root := filepath.Join(filepath.Clean(workdir), "overlay")
+	if err := fs.MkdirAll(root, 0750); err != nil {
+		return "", "", fmt.Errorf("could not create overlay working directory %s: %s", root, err)
+	}
+	dir, err := ioutil.TempDir(root, filepath.Base(c.engine.sessionDir.path)+"-")
+	if err != nil {
+		return "", "", fmt.Errorf("could not create overlay working directory in %s: %s", root, err)
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve overlay working directory: %s", err)
+	}
+	c.engine.sessionDir.setOverlay(dir)
+
+	sylog.Debugf("Storing writable tmpfs overlay in %s", dir)
+	return filepath.Join(dir, "upper"), filepath.Join(dir, "work"), nil
+}
+
+// addRemoteOverlayMount adds squashfs images served over HTTP(S) as
+// read-only overlay lower directories, images are exposed as local files
+// by the FUSE driver set with 'remote overlay driver' directive
+func (c *container) addRemoteOverlayMount(system *mount.System, ov *overlay.Overlay) error {
+	urls := c.engine.EngineConfig.GetRemoteOverlay()
+	if len(urls) == 0 {
+		return nil
+	}
+
+	driver := c.engine.EngineConfig.File.RemoteOverlayDriver
+	if driver == "" {
+		return fmt.Errorf("remote overlay images are not supported, no 'remote overlay driver' configured")
+	}
+	if !filepath.IsAbs(driver) {
+		return fmt.Errorf("remote overlay driver %s must be an absolute path", driver)
+	}
+	cacheDir := c.engine.EngineConfig.File.RemoteOverlayCacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(buildcfg.LOCALSTATEDIR, "singularity", "remote-overlay")
+	}
+
+	type remote struct {
+		url    string
+		fuse   string
+		dest   string
+		source string
+	}
+	remotes := make([]remote, 0, len(urls))
+
+	for i, u := range urls {
+		name := path.Base(u)
+		if name == "." || name == "/" || strings.HasSuffix(u, "/") {
+			return fmt.Errorf("remote overlay URL %s doesn't point to an image file", u)
+		}
+
+		fuseDir := fmt.Sprintf("/overlay-remote/%d", i)
+		if err := c.session.AddDir(fuseDir); err != nil {
+			return fmt.Errorf("failed to create session directory for remote overlay: %s", err)
+		}
+		imageDir := fmt.Sprintf("/overlay-images/remote-%d", i)
+		if err := c.session.AddDir(imageDir); err != nil {
+			return fmt.Errorf("failed to create session directory for remote overlay: %s", err)
+		}
+		r := remote{url: u}
+		r.fuse, _ = c.session.GetPath(fuseDir)
+		r.dest, _ = c.session.GetPath(imageDir)
+		r.source = filepath.Join(r.fuse, name)
+		remotes = append(remotes, r)
+
+		ov.AddLowerDir(r.dest)
+
+		if err := system.Points.AddPropagation(mount.DevTag, r.dest, syscall.MS_UNBINDABLE); err != nil {
+			return err
+		}
+	}
+
+	// images are available once the session directory is mounted
+	return system.RunBeforeTag(mount.PreLayerTag, func(system *mount.System) error {
+		flags := c.mountFlags(mount.PreLayerTag, true) | syscall.MS_RDONLY
+
+		for _, r := range remotes {
+			sylog.Debugf("Mounting remote overlay %s with %s", r.url, driver)
+			if _, err := c.rpcOps.RemoteMount(driver, r.url, r.fuse, cacheDir); err != nil {
+				return fmt.Errorf("while mounting remote overlay %s: %s", r.url, err)
+			}
+
+			img, err := image.Init(r.source, false)
+			if err != nil {
+				return fmt.Errorf("while loading remote overlay %s: %s", r.url, err)
+			}
+			img.File.Close()
+
+			if img.Type != image.SQUASHFS || len(img.Partitions) == 0 {
+				return fmt.Errorf("remote overlay %s is not a squashfs image", r.url)
+			}
+			part := img.Partitions[0]
+
+			err = system.Points.AddImage(mount.PreLayerTag, r.source, r.dest, "squashfs", flags, part.Offset, part.Size, nil)
+			if err != nil {
+				return fmt.Errorf("while adding remote squashfs image: %s", err)
+			}
+		}
+		return nil
+	})
+}
+
+// overlaySubdir validates and returns the cleaned overlay image
+// subdirectory, it must be a relative path not escaping image root
+func overlaySubdir(subdir string) (string, error) {
+	if subdir == "" {
+		return "", nil
+	}
+	clean := filepath.Clean(subdir)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("overlay subdirectory %s must be a path relative to overlay image root", subdir)
+	}
+	if clean == "." {
+		return "", nil
+	}
+	return clean, nil
+}
+
+// overlayMount describes a writable overlay mounted at dest in the
+// container with upper and work directories of the writable overlay image
+type overlayMount struct {
+	subdir string
+	dest   string
+	upper  string
+	work   string
+}
+
+// parseOverlayMounts parses <subdir>:<dest> overlay mount specifications,
+// subdirectories must not overlap with each other nor with the root
+// overlay subdirectory holding the container upper and work directories
+func parseOverlayMounts(root string, entries []string) ([]overlayMount, error) {
+	mounts := make([]overlayMount, 0, len(entries))
+
+	overlap := func(a, b string) bool {
+		return a == "" || b == "" || a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+	}
+
+	for _, spec := range entries {
+		splitted := strings.SplitN(spec, ":", 2)
+		if len(splitted) != 2 {
+			return nil, fmt.Errorf("overlay mount %s must have the format <subdir>:<dest>", spec)
+		}
+		subdir, err := overlaySubdir(splitted[0])
+		if err != nil {
+			return nil, err
+		}
+		if subdir == "" {
+			return nil, fmt.Errorf("overlay mount %s must use a subdirectory of the overlay image", spec)
+		}
+		dest := filepath.Clean(splitted[1])
+		if !filepath.IsAbs(dest) || dest == "/" {
+			return nil, fmt.Errorf("overlay mount destination %s must be an absolute path other than /", splitted[1])
+		}
+		for _, d := range []string{"upper", "work"} {
+			if overlap(subdir, filepath.Join(root, d)) {
+				return nil, fmt.Errorf("overlay mount subdirectory %s overlaps with overlay %s directory", subdir, d)
+			}
+		}
+		for _, m := range mounts {
+			if overlap(subdir, m.subdir) {
+				return nil, fmt.Errorf("overlay mount subdirectories %s and %s overlap", m.subdir, subdir)
+			}
+			if dest == m.dest {
+				return nil, fmt.Errorf("overlay mount destination %s used twice", dest)
+			}
+		}
+		mounts = append(mounts, overlayMount{subdir: subdir, dest: dest})
+	}
+
+	return mounts, nil
+}
+
+// addOverlayMounts adds writable overlays requested with --overlay-mount
+// on top of container directories once the container root filesystem
+// is assembled
+func (c *container) addOverlayMounts(system *mount.System) error {
+	flags := c.mountFlags(mount.LayerTag, true)
+
+	for _, m := range c.overlayMounts {
+		lower := filepath.Join(c.session.FinalPath(), m.dest)
+		if !fs.IsDir(lower) {
+			return fmt.Errorf("overlay mount destination %s doesn't exist in container", m.dest)
+		}
+		sylog.Debugf("Adding writable overlay %s with upper directory %s", m.dest, m.upper)
+		if err := system.Points.AddOverlay(mount.BindsTag, m.dest, flags, lower, m.upper, m.work); err != nil {
+			return fmt.Errorf("unable to add overlay %s to mount list: %s", m.dest, err)
+		}
+	}
+	return nil
+}
+
+// lockOverlayImage takes an exclusive lock on a writable overlay image
+// held until the container exits, when the image is already locked by
+// another container 'overlay lock policy' directive tells whether to abort,
+// to wait or to use the image read-only in which case false is returned
+func (c *container) lockOverlayImage(img *image.Image) (bool, error) {
+	fd := int(img.Fd)
+
+	err := syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != syscall.EWOULDBLOCK {
+		if err != nil {
+			return false, fmt.Errorf("while locking image: %s", err)
+		}
+		return true, nil
+	}
+
+	switch c.engine.EngineConfig.File.OverlayLockPolicy {
+	case "readonly":
+		sylog.Warningf("Overlay image %s is in use by another container, using it read-only", img.Path)
+		return false, nil
+	case "wait":
+		sylog.Infof("Overlay image %s is in use by another container, waiting for it", img.Path)
+		if err := syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+			return false, fmt.Errorf("while locking image: %s", err)
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("image is in use by another container")
+}
+
+// overlayLayerKey identifies an overlay image partition by the device and
+// inode of the image file and the partition offset
+func overlayLayerKey(img *image.Image, part *image.Section) (string, bool) {
+	var st syscall.Stat_t
+
+	if err := syscall.Stat(img.Path, &st); err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%d:%d:%d", st.Dev, st.Ino, part.Offset), true
+}
+
+// squashfsOverlayLayers returns the index of the last occurrence of each
+// squashfs overlay image partition. As later images are stacked above, a
+// squashfs layer passed several times is only mounted at its topmost
+// position, lower occurrences being entirely hidden by it
+func squashfsOverlayLayers(images []image.Image) map[string]int {
+	topmost := make(map[string]int)
+
+	for i := range images {
+		part, err := overlayPartition(&images[i])
+		if err != nil || part == nil || part.Type != image.SQUASHFS {
+			continue
+		}
+		if key, ok := overlayLayerKey(&images[i], part); ok {
+			topmost[key] = i
+		}
+	}
+	return topmost
+}
+
+// overlayPartition returns the partition holding the overlay filesystem
+// of an overlay image, for SIF images the first ext3 or squashfs partition
+// which is not the root filesystem is returned. A nil partition is returned
+// for sandbox images.
+func overlayPartition(img *image.Image) (*image.Section, error) {
+	switch img.Type {
+	case image.EXT3, image.SQUASHFS:
+		if len(img.Partitions) == 0 {
+			return nil, fmt.Errorf("no partition found")
+		}
+		return &img.Partitions[0], nil
+	case image.SIF:
+		for i, p := range img.Partitions {
+			if p.Name == image.RootFs {
+				continue
+			}
+			if p.Type == image.EXT3 || p.Type == image.SQUASHFS {
+				return &img.Partitions[i], nil
+			}
+		}
+		return nil, fmt.Errorf("no overlay partition found in SIF image")
+	case image.SANDBOX:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown image format")
+}
+
 func (c *container) addOverlayMount(system *mount.System) error {
 	nb := 0
 	ov := c.session.Layer.(*overlay.Overlay)
@@ -785,15 +2059,21 @@ func (c *container) addOverlayMount(system *mount.System) error {
 	if c.engine.EngineConfig.GetWritableTmpfs() {
 		sylog.Debugf("Setup writable tmpfs overlay")
 
-		if err := c.session.AddDir("/tmpfs/upper"); err != nil {
-			return err
-		}
-		if err := c.session.AddDir("/tmpfs/work"); err != nil {
+		upper, work, err := c.workdirOverlayUpperWork()
+		if err != nil {
 			return err
 		}
+		if upper == "" {
+			if err := c.session.AddDir("/tmpfs/upper"); err != nil {
+				return err
+			}
+			if err := c.session.AddDir("/tmpfs/work"); err != nil {
+				return err
+			}
 
-		upper, _ := c.session.GetPath("/tmpfs/upper")
-		work, _ := c.session.GetPath("/tmpfs/work")
+			upper, _ = c.session.GetPath("/tmpfs/upper")
+			work, _ = c.session.GetPath("/tmpfs/work")
+		}
 
 		if err := ov.SetUpperDir(upper); err != nil {
 			return fmt.Errorf("failed to add overlay upper: %s", err)
@@ -804,7 +2084,9 @@ func (c *container) addOverlayMount(system *mount.System) error {
 
 		tmpfsPath := filepath.Dir(upper)
 
-		flags := uintptr(c.suidFlag | syscall.MS_NODEV)
+		// keep session noexec flag on upper directory, execution
+		// is governed by the overlay mount flags
+		flags := c.mountFlags(mount.PreLayerTag, true) | c.sessionFlags
 
 		if err := system.Points.AddBind(mount.PreLayerTag, tmpfsPath, tmpfsPath, flags); err != nil {
 			return fmt.Errorf("failed to add %s temporary filesystem: %s", tmpfsPath, err)
@@ -817,12 +2099,55 @@ func (c *container) addOverlayMount(system *mount.System) error {
 		hasUpper = true
 	}
 
-	for _, img := range c.engine.EngineConfig.GetOverlayImage() {
+	imageList := c.engine.EngineConfig.GetImageList()
+	overlayImages := c.engine.EngineConfig.GetOverlayImage()
+
+	// image list contains the root filesystem followed by
+	// overlay images in the same order than overlay list
+	if len(imageList) != len(overlayImages)+1 {
+		return fmt.Errorf("overlay images list doesn't match loaded images")
+	}
+
+	topmost := squashfsOverlayLayers(imageList[1:])
+
+	for i, img := range overlayImages {
 		splitted := strings.SplitN(img, ":", 2)
 
-		imageObject, err := c.loadImage(splitted[0], false)
-		if err != nil {
+		imageObject := imageList[i+1]
+		if p, err := image.ResolvePath(splitted[0]); err != nil {
 			return fmt.Errorf("failed to open overlay image %s: %s", splitted[0], err)
+		} else if p != imageObject.Path {
+			return fmt.Errorf("failed to open overlay image %s: loaded image path %s mismatch", splitted[0], imageObject.Path)
+		}
+
+		part, err := overlayPartition(&imageObject)
+		if err != nil {
+			return fmt.Errorf("failed to use overlay image %s: %s", splitted[0], err)
+		}
+
+		if part != nil && part.Type == image.SQUASHFS {
+			if key, ok := overlayLayerKey(&imageObject, part); ok && topmost[key] != i {
+				sylog.Debugf("Skipping overlay image %s, same squashfs layer is used above", splitted[0])
+				continue
+			}
+		}
+
+		// only the first writable ext3 image or sandbox is used
+		// as upper directory, the next ones are used read-only
+		writable := imageObject.Writable
+		if part != nil && part.Type == image.SQUASHFS {
+			writable = false
+		} else if writable && hasUpper {
+			sylog.Verbosef("Overlay upper directory already set, %s will be used read-only", splitted[0])
+			writable = false
+		}
+
+		if writable {
+			locked, err := c.lockOverlayImage(&imageObject)
+			if err != nil {
+				return fmt.Errorf("failed to use overlay image %s: %s", splitted[0], err)
+			}
+			writable = locked
 		}
 
 		sessionDest := fmt.Sprintf("/overlay-images/%d", nb)
@@ -832,51 +2157,61 @@ func (c *container) addOverlayMount(system *mount.System) error {
 		dst, _ := c.session.GetPath(sessionDest)
 		nb++
 
-		src := imageObject.Source
-		offset := imageObject.Partitions[0].Offset
-		size := imageObject.Partitions[0].Size
-
-		switch imageObject.Type {
-		case image.EXT3:
-			flags := uintptr(c.suidFlag | syscall.MS_NODEV)
-
-			if !imageObject.Writable {
-				flags |= syscall.MS_RDONLY
-				ov.AddLowerDir(filepath.Join(dst, "upper"))
-			}
-
-			err = system.Points.AddImage(mount.PreLayerTag, src, dst, "ext3", flags, offset, size, nil)
-			if err != nil {
-				return fmt.Errorf("while adding ext3 image: %s", err)
-			}
-		case image.SQUASHFS:
-			flags := uintptr(c.suidFlag | syscall.MS_NODEV | syscall.MS_RDONLY)
-			err = system.Points.AddImage(mount.PreLayerTag, src, dst, "squashfs", flags, offset, size, nil)
-			if err != nil {
-				return err
-			}
-			ov.AddLowerDir(dst)
-		case image.SANDBOX:
+		if part == nil {
 			if os.Geteuid() != 0 {
 				return fmt.Errorf("only root user can use sandbox as overlay")
 			}
 
-			flags := uintptr(c.suidFlag | syscall.MS_NODEV)
+			flags := c.mountFlags(mount.PreLayerTag, true)
+			if !writable {
+				flags |= syscall.MS_RDONLY
+			}
+			flags |= c.atimeFlags(writable)
 			err = system.Points.AddBind(mount.PreLayerTag, imageObject.Path, dst, flags)
 			if err != nil {
 				return fmt.Errorf("while adding sandbox image: %s", err)
 			}
 			system.Points.AddRemount(mount.PreLayerTag, dst, flags)
 
-			if !imageObject.Writable {
+			if !writable {
 				if fs.IsDir(filepath.Join(imageObject.Path, "upper")) {
 					ov.AddLowerDir(filepath.Join(dst, "upper"))
 				} else {
 					ov.AddLowerDir(dst)
 				}
 			}
-		default:
-			return fmt.Errorf("unknown image format")
+		} else {
+			flags := c.mountFlags(mount.PreLayerTag, true)
+			if !writable {
+				flags |= syscall.MS_RDONLY
+			}
+			flags |= c.atimeFlags(writable)
+
+			switch part.Type {
+			case image.EXT3:
+				options := ""
+				if writable {
+					var syncFlags uintptr
+					syncFlags, options = overlaySyncOptions(c.engine.EngineConfig.File.OverlaySync)
+					flags |= syncFlags
+				}
+				fstype := extFstype(&imageObject, *part)
+				err = system.Points.AddImageWithOptions(mount.PreLayerTag, imageObject.Source, dst, fstype, flags, part.Offset, part.Size, nil, options)
+				if err != nil {
+					return fmt.Errorf("while adding ext3 image: %s", err)
+				}
+				if !writable {
+					ov.AddLowerDir(filepath.Join(dst, "upper"))
+				} else if c.engine.EngineConfig.File.OverlayFsck {
+					c.fsckImage = imageObject.Source
+				}
+			case image.SQUASHFS:
+				err = system.Points.AddImage(mount.PreLayerTag, imageObject.Source, dst, "squashfs", flags, part.Offset, part.Size, nil)
+				if err != nil {
+					return fmt.Errorf("while adding squashfs image: %s", err)
+				}
+				ov.AddLowerDir(dst)
+			}
 		}
 
 		err = system.Points.AddPropagation(mount.DevTag, dst, syscall.MS_UNBINDABLE)
@@ -884,9 +2219,24 @@ func (c *container) addOverlayMount(system *mount.System) error {
 			return err
 		}
 
-		if imageObject.Writable && !hasUpper {
-			upper := filepath.Join(dst, "upper")
-			work := filepath.Join(dst, "work")
+		if writable {
+			subdir, err := overlaySubdir(c.engine.EngineConfig.GetOverlaySubdir())
+			if err != nil {
+				return err
+			}
+			c.overlayRoot = dst
+
+			c.overlayMounts, err = parseOverlayMounts(subdir, c.engine.EngineConfig.GetOverlayMounts())
+			if err != nil {
+				return err
+			}
+			for i := range c.overlayMounts {
+				c.overlayMounts[i].upper = filepath.Join(dst, c.overlayMounts[i].subdir, "upper")
+				c.overlayMounts[i].work = filepath.Join(dst, c.overlayMounts[i].subdir, "work")
+			}
+
+			upper := filepath.Join(dst, subdir, "upper")
+			work := filepath.Join(dst, subdir, "work")
 
 			if err := ov.SetUpperDir(upper); err != nil {
 				return fmt.Errorf("failed to add overlay upper: %s", err)
@@ -899,24 +2249,84 @@ func (c *container) addOverlayMount(system *mount.System) error {
 		}
 	}
 
+	if err := c.addRemoteOverlayMount(system, ov); err != nil {
+		return err
+	}
+
+	ov.SetFlags(c.mountFlags(mount.LayerTag, true) | c.atimeFlags(hasUpper))
+
 	if hasUpper {
 		if err := system.RunAfterTag(mount.PreLayerTag, c.overlayUpperWork); err != nil {
 			return err
 		}
+		if err := system.RunAfterTag(mount.LayerTag, c.addOverlayMounts); err != nil {
+			return err
+		}
+	} else if len(c.engine.EngineConfig.GetOverlayMounts()) > 0 {
+		return fmt.Errorf("overlay mounts require a writable overlay image")
 	}
 
 	return system.Points.AddPropagation(mount.DevTag, c.session.FinalPath(), syscall.MS_UNBINDABLE)
 }
 
+// atimeFlags returns MS_NOATIME and MS_NODIRATIME flags for read-only
+// mounts where access time updates are useless, and for writable mounts
+// when 'rootfs noatime' directive is enabled
+func (c *container) atimeFlags(writable bool) uintptr {
+	if writable && !c.engine.EngineConfig.File.RootfsNoatime {
+		return 0
+	}
+	return syscall.MS_NOATIME | syscall.MS_NODIRATIME
+}
+
+// overlaySyncOptions returns mount flags and ext3 options applied to
+// writable overlay images according to 'overlay sync' directive
+func overlaySyncOptions(policy string) (uintptr, string) {
+	switch policy {
+	case "journal":
+		return 0, "data=journal"
+	case "writeback":
+		return 0, "data=writeback"
+	case "sync":
+		return syscall.MS_SYNCHRONOUS | syscall.MS_DIRSYNC, ""
+	}
+	return 0, ""
+}
+
+// addSysWritableMount binds /sys paths allowed by 'sys writable path'
+// directive read-write on top of read-only /sys
+func (c *container) addSysWritableMount(system *mount.System) error {
+	flags := uintptr(syscall.MS_BIND | c.mountFlags(mount.KernelTag, false) | syscall.MS_REC)
+
+	for _, path := range c.engine.EngineConfig.File.SysWritablePath {
+		path = filepath.Clean(path)
+		if !strings.HasPrefix(path, "/sys/") {
+			sylog.Warningf("Ignoring 'sys writable path' %s: not located under /sys", path)
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			sylog.Debugf("Skipping writable %s: %s", path, err)
+			continue
+		}
+
+		sylog.Debugf("Adding writable %s to mount list\n", path)
+		if err := system.Points.AddBind(mount.KernelTag, path, path, flags); err != nil {
+			return fmt.Errorf("unable to add %s to mount list: %s", path, err)
+		}
+	}
+
+	return nil
+}
+
 func (c *container) addKernelMount(system *mount.System) error {
 	var err error
-	bindFlags := uintptr(syscall.MS_BIND | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_REC)
+	bindFlags := uintptr(syscall.MS_BIND | c.mountFlags(mount.KernelTag, false) | syscall.MS_REC)
 
 	sylog.Debugf("Checking configuration file for 'mount proc'")
 	if c.engine.EngineConfig.File.MountProc {
 		sylog.Debugf("Adding proc to mount list\n")
 		if c.pidNS {
-			err = system.Points.AddFS(mount.KernelTag, "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV, "")
+			err = system.Points.AddFS(mount.KernelTag, "/proc", "proc", c.mountFlags(mount.KernelTag, false), "")
 		} else {
 			err = system.Points.AddBind(mount.KernelTag, "/proc", "/proc", bindFlags)
 			if err == nil {
@@ -935,24 +2345,83 @@ func (c *container) addKernelMount(system *mount.System) error {
 
 	sylog.Debugf("Checking configuration file for 'mount sys'")
 	if c.engine.EngineConfig.File.MountSys {
+		readonly := c.engine.EngineConfig.File.MountSysReadonly
+		sysFlags := c.mountFlags(mount.KernelTag, false)
+		if readonly {
+			sysFlags |= syscall.MS_RDONLY
+		}
+
 		sylog.Debugf("Adding sysfs to mount list\n")
 		if !c.userNS {
-			err = system.Points.AddFS(mount.KernelTag, "/sys", "sysfs", syscall.MS_NOSUID|syscall.MS_NODEV, "")
+			err = system.Points.AddFS(mount.KernelTag, "/sys", "sysfs", sysFlags, "")
 		} else {
 			err = system.Points.AddBind(mount.KernelTag, "/sys", "/sys", bindFlags)
-			if err == nil {
-				if !c.userNS {
-					system.Points.AddRemount(mount.KernelTag, "/sys", bindFlags)
-				}
+			if err == nil && readonly {
+				system.Points.AddRemount(mount.KernelTag, "/sys", bindFlags|syscall.MS_RDONLY)
 			}
 		}
 		if err != nil {
 			return fmt.Errorf("unable to add sys to mount list: %s", err)
 		}
 		sylog.Verbosef("Default mount: /sys:/sys")
+
+		if readonly {
+			if err := c.addSysWritableMount(system); err != nil {
+				return err
+			}
+		}
 	} else {
 		sylog.Verbosef("Skipping /sys mount")
+		if err := c.addSysClassNetMount(system); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sysClassNet is the sysfs directory listing network interfaces
+const sysClassNet = "/sys/class/net"
+
+// addSysClassNetMount binds host /sys/class/net and the interface
+// directories its entries point to read-only when /sys isn't mounted
+// and 'mount sys class net' is enabled
+func (c *container) addSysClassNetMount(system *mount.System) error {
+	if !c.engine.EngineConfig.File.MountSysClassNet {
+		return nil
+	}
+	if c.netNS {
+		sylog.Debugf("Not binding %s: network namespace requested", sysClassNet)
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(sysClassNet)
+	if err != nil {
+		sylog.Warningf("Could not bind %s: %s", sysClassNet, err)
+		return nil
 	}
+
+	flags := uintptr(syscall.MS_BIND | syscall.MS_RDONLY | c.mountFlags(mount.KernelTag, false) | syscall.MS_REC)
+	paths := []string{sysClassNet}
+
+	// entries are symlinks to interface directories under /sys/devices
+	for _, e := range entries {
+		path, err := filepath.EvalSymlinks(filepath.Join(sysClassNet, e.Name()))
+		if err != nil || !strings.HasPrefix(path, "/sys/") {
+			sylog.Debugf("Skipping network interface %s", e.Name())
+			continue
+		}
+		paths = append(paths, path)
+	}
+
+	for _, path := range paths {
+		sylog.Debugf("Adding %s to mount list\n", path)
+		if err := system.Points.AddBind(mount.KernelTag, path, path, flags); err != nil {
+			return fmt.Errorf("unable to add %s to mount list: %s", path, err)
+		}
+		system.Points.AddRemount(mount.KernelTag, path, flags)
+	}
+	sylog.Verbosef("Default mount: %s:%s", sysClassNet, sysClassNet)
+
 	return nil
 }
 
@@ -990,6 +2459,7 @@ func (c *container) addSessionDevAt(srcpath string, atpath string, system *mount
 	dst, _ := c.session.GetPath(atpath)
 
 	sylog.Debugf("Mounting device %s at %s", srcpath, dst)
+	c.allowDevices(srcpath)
 
 	if err := system.Points.AddBind(mount.DevTag, srcpath, dst, syscall.MS_BIND); err != nil {
 		return fmt.Errorf("failed to add %s mount: %s", srcpath, err)
@@ -997,6 +2467,47 @@ func (c *container) addSessionDevAt(srcpath string, atpath string, system *mount
 	return nil
 }
 
+// devProcSymlinks lists /dev symlinks pointing into /proc
+var devProcSymlinks = []string{"/dev/fd", "/dev/stdin", "/dev/stdout", "/dev/stderr"}
+
+// useDevProcSymlinks returns whether /dev symlinks pointing into /proc
+// are created according to policy set by 'dev proc symlinks' directive,
+// with 'auto' they are created only if /proc is mounted in container
+// by configuration or by a user bind
+func useDevProcSymlinks(policy string, mountProc bool, binds []string) bool {
+	switch policy {
+	case "yes":
+		return true
+	case "no":
+		return false
+	}
+	if mountProc {
+		return true
+	}
+	for _, b := range binds {
+		if spec, err := parseBindSpec(b); err == nil && filepath.Clean(spec.dst) == "/proc" {
+			return true
+		}
+	}
+	return false
+}
+
+// addDevProcSymlinks adds /dev/fd, /dev/stdin, /dev/stdout and /dev/stderr
+// symlinks to session /dev unless they would be dangling
+func (c *container) addDevProcSymlinks(system *mount.System) error {
+	cfg := c.engine.EngineConfig
+	if !useDevProcSymlinks(cfg.File.DevProcSymlinks, cfg.File.MountProc, cfg.GetBindPath()) {
+		sylog.Verbosef("Not creating %s symlinks, /proc is not available in container", strings.Join(devProcSymlinks, ", "))
+		return nil
+	}
+	for _, path := range devProcSymlinks {
+		if err := c.addSessionDev(path, system); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *container) addSessionDev(devpath string, system *mount.System) error {
 	return c.addSessionDevAt(devpath, devpath, system)
 }
@@ -1012,6 +2523,212 @@ func (c *container) addSessionDevMount(system *mount.System) error {
 	return nil
 }
 
+// devLogPaths lists the host syslog socket locations, /dev/log is
+// usually a symlink to the systemd journal socket located under /run
+var devLogPaths = []string{"/dev/log", "/run/systemd/journal/dev-log"}
+
+// addDevLogMount binds the host syslog socket at /dev/log in the
+// staged /dev so syslog messages reach the host logging daemon
+func (c *container) addDevLogMount(system *mount.System) error {
+	if !c.engine.EngineConfig.File.MountDevLog {
+		sylog.Debugf("Not binding host syslog socket per configuration")
+		return nil
+	}
+
+	for _, path := range devLogPaths {
+		source, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			continue
+		}
+		fi, err := os.Stat(source)
+		if err != nil || fi.Mode()&os.ModeSocket == 0 {
+			continue
+		}
+		sylog.Debugf("Binding host syslog socket %s at /dev/log", source)
+		return c.addSessionDevAt(source, "/dev/log", system)
+	}
+
+	sylog.Verbosef("No host syslog socket found, skipping /dev/log")
+	return nil
+}
+
+// ociDeviceMode returns the file type bits corresponding to an OCI
+// device type
+func ociDeviceMode(devType string) (uint32, error) {
+	switch devType {
+	case "c", "u":
+		return syscall.S_IFCHR, nil
+	case "b":
+		return syscall.S_IFBLK, nil
+	case "p":
+		return syscall.S_IFIFO, nil
+	}
+	return 0, fmt.Errorf("unknown device type %q", devType)
+}
+
+// addOciDevices adds devices listed in the OCI configuration to the
+// staged /dev, device nodes are created in session directory except
+// with user namespace where host devices are bound if they match
+func (c *container) addOciDevices(system *mount.System) error {
+	if c.engine.EngineConfig.OciConfig.Linux == nil {
+		return nil
+	}
+
+	for _, d := range c.engine.EngineConfig.OciConfig.Linux.Devices {
+		path := filepath.Clean(d.Path)
+		if !strings.HasPrefix(path, "/dev/") {
+			return fmt.Errorf("device %s is not located under /dev", d.Path)
+		}
+		mode, err := ociDeviceMode(d.Type)
+		if err != nil {
+			return fmt.Errorf("device %s: %s", path, err)
+		}
+		if _, err := c.session.GetPath(path); err == nil {
+			sylog.Debugf("Device %s already present in staged /dev", path)
+			continue
+		}
+
+		dev := unix.Mkdev(uint32(d.Major), uint32(d.Minor))
+
+		if c.userNS {
+			// device nodes can't be created within a user
+			// namespace, bind the host device if it matches
+			st := new(syscall.Stat_t)
+			if err := syscall.Stat(path, st); err != nil || st.Rdev != dev || st.Mode&syscall.S_IFMT != mode {
+				sylog.Warningf("Skipping device %s: can't be created with user namespace and no matching host device", path)
+				continue
+			}
+			if err := c.addSessionDev(path, system); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := c.session.AddDir(filepath.Dir(path)); err != nil {
+			return fmt.Errorf("failed to add %s session directory: %s", filepath.Dir(path), err)
+		}
+
+		perm := uint32(0666)
+		if d.FileMode != nil {
+			perm = uint32(d.FileMode.Perm())
+		}
+		uid, gid := 0, 0
+		if d.UID != nil {
+			uid = int(*d.UID)
+		}
+		if d.GID != nil {
+			gid = int(*d.GID)
+		}
+
+		c.allowDeviceNode(mode, int64(d.Major), int64(d.Minor))
+
+		d := d
+		err = system.RunAfterTag(mount.SessionTag, func(*mount.System) error {
+			parent, _ := c.session.GetPath(filepath.Dir(path))
+			node := filepath.Join(parent, filepath.Base(path))
+			sylog.Debugf("Creating device %s (%s %d:%d)", node, d.Type, d.Major, d.Minor)
+			if _, err := c.rpcOps.Mknod(node, mode|perm, int(dev), uid, gid); err != nil {
+				return fmt.Errorf("failed to create device %s: %s", path, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// allowDevice adds the host device node path to the devices cgroup
+// allow list, other file types are ignored
+func (c *container) allowDevice(path string) {
+	st := new(syscall.Stat_t)
+	if err := syscall.Stat(path, st); err != nil {
+		return
+	}
+	c.allowDeviceNode(st.Mode&syscall.S_IFMT, int64(unix.Major(st.Rdev)), int64(unix.Minor(st.Rdev)))
+}
+
+// allowDevices adds the host device node path to the devices cgroup
+// allow list, if path is a directory like /dev/dri all device nodes
+// found underneath are allowed
+func (c *container) allowDevices(path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if !fi.IsDir() {
+		c.allowDevice(path)
+		return
+	}
+	filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode()&os.ModeDevice != 0 {
+			c.allowDevice(p)
+		}
+		return nil
+	})
+}
+
+// allowDeviceNode adds a device node to the devices cgroup allow list,
+// a negative minor number allows all minor numbers
+func (c *container) allowDeviceNode(mode uint32, major int64, minor int64) {
+	var devType string
+
+	switch mode {
+	case syscall.S_IFCHR:
+		devType = "c"
+	case syscall.S_IFBLK:
+		devType = "b"
+	default:
+		return
+	}
+
+	rule := specs.LinuxDeviceCgroup{
+		Allow:  true,
+		Type:   devType,
+		Major:  &major,
+		Access: "rwm",
+	}
+	if minor >= 0 {
+		rule.Minor = &minor
+	}
+	c.devices = append(c.devices, rule)
+}
+
+// addDevicesCgroup restricts device access to the device nodes bound
+// in the staged /dev, all devices are denied except those explicitly
+// allowed, rules from cgroups configuration are applied afterward.
+// Nothing is restricted when the host /dev is mounted or bound by user
+func (c *container) addDevicesCgroup(resources *specs.LinuxResources) {
+	if c.devices == nil || c.devSourcePath == "/dev" {
+		return
+	}
+
+	devices := []specs.LinuxDeviceCgroup{{Allow: false, Access: "rwm"}}
+	devices = append(devices, c.devices...)
+	resources.Devices = append(devices, resources.Devices...)
+
+	if c.engine.EngineConfig.OciConfig.Linux == nil {
+		c.engine.EngineConfig.OciConfig.Linux = &specs.Linux{}
+	}
+	c.engine.EngineConfig.OciConfig.Linux.Resources = resources
+}
+
+// devShmOwnerOptions returns the ownership options of the staged /dev/shm
+// temporary filesystem. With user namespace it would be owned by the
+// mounting user ID which isn't the container root with fakeroot, so it
+// is explicitly owned by the container root or by the user
+func (c *container) devShmOwnerOptions() string {
+	if !c.userNS {
+		return ""
+	}
+	if c.engine.EngineConfig.GetFakeroot() {
+		return ",uid=0,gid=0"
+	}
+	return fmt.Sprintf(",uid=%d,gid=%d", os.Getuid(), os.Getgid())
+}
+
 func (c *container) addDevMount(system *mount.System) error {
 	sylog.Debugf("Checking configuration file for 'mount dev'")
 
@@ -1026,7 +2743,7 @@ func (c *container) addDevMount(system *mount.System) error {
 		}
 		devshmPath, _ := c.session.GetPath("/dev/shm")
 		flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV)
-		err := system.Points.AddFS(mount.DevTag, devshmPath, c.engine.EngineConfig.File.MemoryFSType, flags, "mode=1777")
+		err := system.Points.AddFS(mount.DevTag, devshmPath, c.engine.EngineConfig.File.MemoryFSType, flags, "mode=1777"+c.devShmOwnerOptions())
 		if err != nil {
 			return fmt.Errorf("failed to add /dev/shm temporary filesystem: %s", err)
 		}
@@ -1075,6 +2792,9 @@ func (c *container) addDevMount(system *mount.System) error {
 			if err := c.session.AddSymlink("/dev/ptmx", "/dev/pts/ptmx"); err != nil {
 				return fmt.Errorf("failed to create /dev/ptmx symlink: %s", err)
 			}
+			// allow PTY master multiplexer and slaves
+			c.allowDeviceNode(syscall.S_IFCHR, 5, 2)
+			c.allowDeviceNode(syscall.S_IFCHR, 136, -1)
 
 		}
 		// add /dev/console mount pointing to original tty if there is one
@@ -1120,6 +2840,9 @@ func (c *container) addDevMount(system *mount.System) error {
 			}
 			break
 		}
+		if c.userNS {
+			sylog.Debugf("Host device nodes bound in staged /dev are owned by an unmapped user with user namespace")
+		}
 		if err := c.addSessionDev("/dev/tty", system); err != nil {
 			return err
 		}
@@ -1141,22 +2864,25 @@ func (c *container) addDevMount(system *mount.System) error {
 				return fmt.Errorf("failed to get nvidia devices: %v", err)
 			}
 			for _, dev := range devs {
+				// devices are set up by nvidia-container-cli
+				if c.engine.EngineConfig.GetNvCCLI() {
+					c.allowDevice(dev)
+					continue
+				}
 				if err := c.addSessionDev(dev, system); err != nil {
 					return err
 				}
 			}
 		}
 
-		if err := c.addSessionDev("/dev/fd", system); err != nil {
-			return err
-		}
-		if err := c.addSessionDev("/dev/stdin", system); err != nil {
+		if err := c.addOciDevices(system); err != nil {
 			return err
 		}
-		if err := c.addSessionDev("/dev/stdout", system); err != nil {
+		if err := c.addDevLogMount(system); err != nil {
 			return err
 		}
-		if err := c.addSessionDev("/dev/stderr", system); err != nil {
+
+		if err := c.addDevProcSymlinks(system); err != nil {
 			return err
 		}
 
@@ -1166,6 +2892,9 @@ func (c *container) addDevMount(system *mount.System) error {
 			return err
 		}
 	} else if c.engine.EngineConfig.File.MountDev == "yes" {
+		if c.engine.EngineConfig.OciConfig.Linux != nil && len(c.engine.EngineConfig.OciConfig.Linux.Devices) > 0 {
+			sylog.Verbosef("Host /dev is mounted, ignoring additional devices")
+		}
 		sylog.Debugf("Adding dev to mount list\n")
 		err := system.Points.AddBind(mount.DevTag, "/dev", "/dev", syscall.MS_BIND|syscall.MS_REC)
 		if err != nil {
@@ -1188,7 +2917,7 @@ func (c *container) addHostMount(system *mount.System) error {
 	if err != nil {
 		return err
 	}
-	flags := uintptr(syscall.MS_BIND | c.suidFlag | syscall.MS_NODEV | syscall.MS_REC)
+	flags := uintptr(syscall.MS_BIND | c.mountFlags(mount.HostfsTag, true) | syscall.MS_REC)
 	for _, child := range info["/"] {
 		if strings.HasPrefix(child, "/proc") {
 			sylog.Debugf("Skipping /proc based file system")
@@ -1209,17 +2938,173 @@ func (c *container) addHostMount(system *mount.System) error {
 			sylog.Debugf("Skipping /var based file system")
 			continue
 		}
-		sylog.Debugf("Adding %s to mount list\n", child)
-		if err := system.Points.AddBind(mount.HostfsTag, child, child, flags); err != nil {
-			return fmt.Errorf("unable to add %s to mount list: %s", child, err)
+		paths := []string{child}
+		if child == "/opt" || strings.HasPrefix(child, "/opt/") {
+			if !c.engine.EngineConfig.File.MountHostfsOpt {
+				sylog.Debugf("Skipping /opt based file system per configuration")
+				continue
+			}
+			paths = c.hostOptPaths(child)
+		}
+		for _, path := range paths {
+			sylog.Debugf("Adding %s to mount list\n", path)
+			if err := system.Points.AddBind(mount.HostfsTag, path, path, flags); err == mount.ErrMountExists {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("unable to add %s to mount list: %s", path, err)
+			}
+			system.Points.AddRemount(mount.HostfsTag, path, flags)
+		}
+	}
+	return nil
+}
+
+// hostOptPaths returns paths to bind for a host file system mounted
+// under /opt, when 'hostfs opt path' is set only the listed subpaths
+// located in the file system are returned
+func (c *container) hostOptPaths(child string) []string {
+	allowed := c.engine.EngineConfig.File.HostfsOptPath
+	if len(allowed) == 0 {
+		return []string{child}
+	}
+
+	paths := make([]string, 0)
+	for _, path := range allowed {
+		path = filepath.Clean(path)
+		if !strings.HasPrefix(path, "/opt/") {
+			sylog.Warningf("Ignoring 'hostfs opt path' %s: not located under /opt", path)
+			continue
+		}
+		if path == child || strings.HasPrefix(child, path+"/") {
+			// the file system is located in an allowed subpath
+			return []string{child}
+		} else if strings.HasPrefix(path, child+"/") {
+			if _, err := os.Stat(path); err != nil {
+				sylog.Debugf("Skipping %s: %s", path, err)
+				continue
+			}
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		sylog.Debugf("Skipping %s: not in 'hostfs opt path' list", child)
+	}
+	return paths
+}
+
+// addSchedulerMount binds scheduler paths defined by administrator
+// with 'scheduler bind path' directive
+func (c *container) addSchedulerMount(system *mount.System) error {
+	if !c.engine.EngineConfig.File.SchedulerIntegration {
+		sylog.Debugf("Not mounting scheduler paths per configuration")
+		return nil
+	}
+
+	defaultFlags := uintptr(syscall.MS_BIND | c.mountFlags(mount.BindsTag, true) | syscall.MS_REC)
+
+	for _, bindpath := range c.engine.EngineConfig.File.SchedulerBindPath {
+		flags := defaultFlags
+		spec, err := parseSystemBindSpec(bindpath)
+		if err != nil {
+			return fmt.Errorf("bad 'scheduler bind path' directive: %s", err)
+		}
+		src := spec.src
+		dst := spec.dst
+
+		for _, opt := range spec.options {
+			switch opt {
+			case "ro":
+				flags |= syscall.MS_RDONLY
+			case "rw":
+			default:
+				sylog.Warningf("Ignoring invalid mount option %s for 'scheduler bind path' %s", opt, src)
+			}
+		}
+
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			sylog.Debugf("Skipping scheduler path %s: not found on host", src)
+			continue
+		}
+
+		sylog.Verbosef("Found 'scheduler bind path' = %s, %s", src, dst)
+		if err := system.Points.AddBind(mount.BindsTag, src, dst, flags); err == mount.ErrMountExists {
+			sylog.Warningf("destination %s already in mount list: %s", dst, err)
+		} else if err != nil {
+			return fmt.Errorf("unable to add %s to mount list: %s", src, err)
+		} else {
+			system.Points.AddRemount(mount.BindsTag, dst, flags)
+		}
+	}
+
+	return nil
+}
+
+// checkBindLimit returns an error if the number of requested host
+// and user bind points exceeds the 'max bind points' directive
+func (c *container) checkBindLimit() error {
+	limit := c.engine.EngineConfig.File.MaxBindPoints
+	if limit == 0 {
+		return nil
+	}
+
+	count := len(c.engine.EngineConfig.GetBindPath())
+	if !c.engine.EngineConfig.GetContain() {
+		count += len(c.engine.EngineConfig.File.BindPath)
+	}
+	sylog.Debugf("%d bind points requested, limit is %d", count, limit)
+
+	if uint(count) > limit {
+		return fmt.Errorf("%d bind points requested, exceeding the limit of %d set by system administrator", count, limit)
+	}
+	return nil
+}
+
+// checkBindLoops reports host and user bind points whose source equals
+// or contains the destination of another bind point, an error is returned
+// instead of a warning with the 'strict bind check' directive
+func (c *container) checkBindLoops() error {
+	type bind struct {
+		src string
+		dst string
+	}
+
+	binds := make([]bind, 0)
+	paths := make([]string, 0)
+	if !c.engine.EngineConfig.GetContain() {
+		paths = append(paths, c.engine.EngineConfig.File.BindPath...)
+	}
+	paths = append(paths, c.engine.EngineConfig.GetBindPath()...)
+
+	for _, b := range paths {
+		splitted := strings.Split(b, ":")
+		src, err := filepath.Abs(splitted[0])
+		if err != nil {
+			continue
+		}
+		dst := src
+		if len(splitted) > 1 && splitted[1] != "" {
+			dst = filepath.Clean(splitted[1])
+		}
+		binds = append(binds, bind{src: src, dst: dst})
+	}
+
+	for i, b := range binds {
+		for j, o := range binds {
+			if i == j || (b.src != o.dst && !strings.HasPrefix(o.dst, b.src+"/")) {
+				continue
+			}
+			msg := fmt.Sprintf("bind source %s contains bind destination %s, files may appear doubled or missing", b.src, o.dst)
+			if c.engine.EngineConfig.File.StrictBindCheck {
+				return fmt.Errorf("%s", msg)
+			}
+			sylog.Warningf("%s", msg)
 		}
-		system.Points.AddRemount(mount.HostfsTag, child, flags)
 	}
 	return nil
 }
 
 func (c *container) addBindsMount(system *mount.System) error {
-	flags := uintptr(syscall.MS_BIND | c.suidFlag | syscall.MS_NODEV | syscall.MS_REC)
+	defaultFlags := uintptr(syscall.MS_BIND | c.mountFlags(mount.BindsTag, true) | syscall.MS_REC)
 
 	if c.engine.EngineConfig.GetContain() {
 		sylog.Debugf("Skipping bind mounts as contain was requested")
@@ -1227,20 +3112,30 @@ func (c *container) addBindsMount(system *mount.System) error {
 	}
 
 	for _, bindpath := range c.engine.EngineConfig.File.BindPath {
-		splitted := strings.Split(bindpath, ":")
-		src := splitted[0]
-		dst := ""
-		if len(splitted) > 1 {
-			dst = splitted[1]
-		} else {
-			dst = src
+		flags := defaultFlags
+		spec, err := parseSystemBindSpec(bindpath)
+		if err != nil {
+			return fmt.Errorf("bad 'bind path' directive: %s", err)
+		}
+		src := spec.src
+		dst := spec.dst
+
+		for _, opt := range spec.options {
+			switch opt {
+			case "ro":
+				flags |= syscall.MS_RDONLY
+			case "rw":
+			default:
+				sylog.Warningf("Ignoring invalid mount option %s for 'bind path' %s", opt, src)
+			}
 		}
 
 		sylog.Verbosef("Found 'bind path' = %s, %s", src, dst)
-		err := system.Points.AddBind(mount.BindsTag, src, dst, flags)
+		err = system.Points.AddBind(mount.BindsTag, src, dst, flags)
 		if err != nil {
 			return fmt.Errorf("unable to add %s to mount list: %s", src, err)
 		}
+		system.Points.AddRemount(mount.BindsTag, dst, flags)
 	}
 
 	return nil
@@ -1262,18 +3157,19 @@ func (c *container) getHomePaths() (source string, dest string, err error) {
 	return source, dest, err
 }
 
-// addHomeStagingDir adds and mounts home directory in session staging directory
-func (c *container) addHomeStagingDir(system *mount.System, source string, dest string) (string, error) {
-	flags := uintptr(syscall.MS_BIND | c.suidFlag | syscall.MS_NODEV | syscall.MS_REC)
+// addHomeStagingDir adds and mounts home directory in session staging directory,
+// if bind is false the staging directory is used as home directory
+func (c *container) addHomeStagingDir(system *mount.System, source string, dest string, bind bool) (string, error) {
+	flags := uintptr(syscall.MS_BIND | c.mountFlags(mount.HomeTag, true) | syscall.MS_REC)
 	homeStage := ""
 
 	if err := c.session.AddDir(dest); err != nil {
-		return "", fmt.Errorf("failed to add %s as session directory: %s", source, err)
+		return "", fmt.Errorf("failed to add %s as session directory: %s", dest, err)
 	}
 
 	homeStage, _ = c.session.GetPath(dest)
 
-	if !c.engine.EngineConfig.GetContain() || c.engine.EngineConfig.GetCustomHome() {
+	if bind {
 		sylog.Debugf("Staging home directory (%v) at %v\n", source, homeStage)
 
 		if err := system.Points.AddBind(mount.HomeTag, source, homeStage, flags); err != nil {
@@ -1291,7 +3187,7 @@ func (c *container) addHomeStagingDir(system *mount.System, source string, dest
 
 // addHomeLayer adds the home mount when using either overlay or underlay
 func (c *container) addHomeLayer(system *mount.System, source, dest string) error {
-	flags := uintptr(syscall.MS_BIND | c.suidFlag | syscall.MS_NODEV | syscall.MS_REC)
+	flags := uintptr(syscall.MS_BIND | c.mountFlags(mount.HomeTag, true) | syscall.MS_REC)
 
 	if err := system.Points.AddBind(mount.HomeTag, source, dest, flags); err != nil {
 		return fmt.Errorf("unable to add home to mount list: %s", err)
@@ -1303,7 +3199,7 @@ func (c *container) addHomeLayer(system *mount.System, source, dest string) erro
 // addHomeNoLayer is responsible for staging the home directory and adding the base
 // directory of the staged home into the container when overlay/underlay are unavailable
 func (c *container) addHomeNoLayer(system *mount.System, source, dest string) error {
-	flags := uintptr(syscall.MS_BIND | c.suidFlag | syscall.MS_NODEV | syscall.MS_REC)
+	flags := uintptr(syscall.MS_BIND | c.mountFlags(mount.HomeTag, true) | syscall.MS_REC)
 
 	homeBase := fs.RootDir(dest)
 	if homeBase == "." {
@@ -1337,12 +3233,32 @@ func (c *container) addHomeMount(system *mount.System) error {
 		return fmt.Errorf("not mounting user requested home: user bind control is disallowed")
 	}
 
+	temporary := c.engine.EngineConfig.File.TemporaryHome
 	source, dest, err := c.getHomePaths()
-	if err != nil {
+	if err != nil && !temporary {
 		return fmt.Errorf("unable to get home source/destination: %v", err)
+	} else if err != nil || dest == "" {
+		// the user may have no passwd entry or no home
+		// directory, use the home directory set for HOME
+		if c.engine.EngineConfig.GetHomeDest() == "" {
+			return fmt.Errorf("unable to determine home directory destination")
+		}
+		sylog.Verbosef("No home directory found for user, using %s", c.engine.EngineConfig.GetHomeDest())
+		source = ""
+		dest = filepath.Clean(c.engine.EngineConfig.GetHomeDest())
+	}
+
+	// home is created in the session directory with --contain
+	bind := !c.engine.EngineConfig.GetContain() || c.engine.EngineConfig.GetCustomHome()
+	if bind && !fs.IsDir(source) {
+		if !temporary {
+			return fmt.Errorf("home directory %q doesn't exist", source)
+		}
+		sylog.Verbosef("Home directory %q doesn't exist, using a temporary home directory", source)
+		bind = false
 	}
 
-	stagingDir, err := c.addHomeStagingDir(system, source, dest)
+	stagingDir, err := c.addHomeStagingDir(system, source, dest, bind)
 	if err != nil {
 		return err
 	}
@@ -1358,7 +3274,7 @@ func (c *container) addUserbindsMount(system *mount.System) error {
 	devicesMounted := 0
 	devPrefix := "/dev"
 	userBindControl := c.engine.EngineConfig.File.UserBindControl
-	defaultFlags := uintptr(syscall.MS_BIND | c.suidFlag | syscall.MS_NODEV | syscall.MS_REC)
+	defaultFlags := uintptr(syscall.MS_BIND | c.mountFlags(mount.UserbindsTag, true) | syscall.MS_REC)
 
 	if len(c.engine.EngineConfig.GetBindPath()) == 0 {
 		return nil
@@ -1366,22 +3282,30 @@ func (c *container) addUserbindsMount(system *mount.System) error {
 
 	for _, b := range c.engine.EngineConfig.GetBindPath() {
 		flags := defaultFlags
-		splitted := strings.Split(b, ":")
+		spec, err := parseBindSpec(b)
+		if err != nil {
+			return err
+		}
 
-		src, err := filepath.Abs(splitted[0])
+		src, err := filepath.Abs(spec.src)
 		if err != nil {
-			sylog.Warningf("Can't determine absolute path of %s bind point", splitted[0])
+			sylog.Warningf("Can't determine absolute path of %s bind point", spec.src)
 			continue
 		}
 		dst := src
-		if len(splitted) > 1 {
-			dst = splitted[1]
+		if spec.dst != spec.src {
+			dst = spec.dst
 		}
-		if len(splitted) > 2 {
-			if splitted[2] == "ro" {
+		optional := false
+		for _, opt := range spec.options {
+			switch opt {
+			case "ro":
 				flags |= syscall.MS_RDONLY
-			} else if splitted[2] != "rw" {
-				sylog.Warningf("Not mounting requested %s bind point, invalid mount option %s", src, splitted[2])
+			case "rw":
+			case "optional":
+				optional = true
+			default:
+				sylog.Warningf("Not mounting requested %s bind point, invalid mount option %s", src, opt)
 			}
 		}
 
@@ -1413,6 +3337,19 @@ func (c *container) addUserbindsMount(system *mount.System) error {
 			continue
 		}
 
+		if err := c.checkBindDest(dst); err != nil {
+			return err
+		}
+
+		if optional {
+			if _, err := os.Stat(src); os.IsNotExist(err) {
+				if err := c.addOptionalBind(system, dst, flags); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
 		sylog.Debugf("Adding %s to mount list\n", src)
 
 		if err := system.Points.AddBind(mount.UserbindsTag, src, dst, flags); err == mount.ErrMountExists {
@@ -1422,6 +3359,16 @@ func (c *container) addUserbindsMount(system *mount.System) error {
 		} else {
 			c.session.OverrideDir(dst, src)
 			system.Points.AddRemount(mount.UserbindsTag, dst, flags)
+			// a device node bound from outside of /dev
+			if c.devices != nil {
+				c.allowDevice(src)
+			}
+		}
+	}
+
+	if len(c.engine.EngineConfig.File.UserBindDenyDest) > 0 {
+		if err := system.RunAfterTag(mount.RootfsTag, c.checkResolvedBindDest); err != nil {
+			return err
 		}
 	}
 
@@ -1433,6 +3380,87 @@ func (c *container) addUserbindsMount(system *mount.System) error {
 	return nil
 }
 
+// deniedBindDest returns the forbidden path covering dst among those
+// listed with 'user bind deny dest' directive, or an empty string
+func (c *container) deniedBindDest(dst string) string {
+	dst = filepath.Clean(dst)
+	for _, p := range c.engine.EngineConfig.File.UserBindDenyDest {
+		if p == "" {
+			continue
+		}
+		p = filepath.Clean(p)
+		if dst == p || strings.HasPrefix(dst, p+"/") || p == "/" {
+			return p
+		}
+	}
+	return ""
+}
+
+// checkBindDest rejects user bind destinations located under a path
+// forbidden by 'user bind deny dest' directive, the destination is
+// checked again once resolved in the container root filesystem to
+// catch destinations reaching a forbidden path through symlinks
+func (c *container) checkBindDest(dst string) error {
+	if len(c.engine.EngineConfig.File.UserBindDenyDest) == 0 {
+		return nil
+	}
+	if p := c.deniedBindDest(dst); p != "" {
+		return fmt.Errorf("bind destination %s is not allowed: %s is forbidden by system administrator", dst, p)
+	}
+
+	return nil
+}
+
+// checkResolvedBindDest checks user bind destinations once resolved
+// in the container root filesystem
+func (c *container) checkResolvedBindDest(system *mount.System) error {
+	rootfs := c.session.RootFsPath()
+	for _, point := range system.Points.GetByTag(mount.UserbindsTag) {
+		resolved := fs.EvalRelative(point.Destination, rootfs)
+		if p := c.deniedBindDest(resolved); p != "" {
+			return fmt.Errorf("bind destination %s resolves to %s: %s is forbidden by system administrator", point.Destination, resolved, p)
+		}
+	}
+	return nil
+}
+
+// addOptionalBind handles an optional user bind path with a missing host
+// source, if destination doesn't exist in container an empty directory from
+// session is bound instead, this requires overlay or underlay to create the
+// destination.
+func (c *container) addOptionalBind(system *mount.System, dst string, flags uintptr) error {
+	if !c.isLayerEnabled() {
+		sylog.Verbosef("Skipping optional bind point %s: host source doesn't exist", dst)
+		return nil
+	}
+
+	sessionDir := filepath.Join("/optional", dst)
+	if err := c.session.AddDir(sessionDir); err != nil {
+		return err
+	}
+	src, _ := c.session.GetPath(sessionDir)
+
+	sylog.Debugf("Adding empty directory %s for optional bind point %s to mount list\n", src, dst)
+
+	if err := system.Points.AddBind(mount.UserbindsTag, src, dst, flags); err == mount.ErrMountExists {
+		sylog.Warningf("destination %s already in mount list: %s", dst, err)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to add %s to mount list: %s", dst, err)
+	}
+	system.Points.AddRemount(mount.UserbindsTag, dst, flags)
+
+	// destination present in container doesn't need to be created
+	return system.RunAfterTag(mount.RootfsTag, func(system *mount.System) error {
+		rootfs := c.session.RootFsPath()
+		if _, err := os.Stat(filepath.Join(rootfs, fs.EvalRelative(dst, rootfs))); err == nil {
+			sylog.Debugf("Optional bind point %s exists in container, removing it from mount list", dst)
+			system.Points.RemoveByDest(dst)
+		}
+		return nil
+	})
+}
+
 func (c *container) addTmpMount(system *mount.System) error {
 	const (
 		tmpPath    = "/tmp"
@@ -1463,6 +3491,10 @@ func (c *container) addTmpMount(system *mount.System) error {
 				sylog.Warningf("Can't determine absolute path of workdir %s", workdir)
 			}
 
+			if err := c.checkFreeSpace(workdir); err != nil {
+				return err
+			}
+
 			tmpSource = filepath.Join(workdir, tmpSource)
 			vartmpSource = filepath.Join(workdir, vartmpSource)
 
@@ -1491,12 +3523,26 @@ func (c *container) addTmpMount(system *mount.System) error {
 			}
 			tmpSource, _ = c.session.GetPath(tmpSource)
 			vartmpSource, _ = c.session.GetPath(vartmpSource)
+
+			// with an explicit scratch size, use dedicated size
+			// limited tmpfs so a full /tmp returns ENOSPC
+			if c.engine.EngineConfig.GetScratchSize() != "" {
+				if size := c.scratchTmpfsSize(); size != "" {
+					options := fmt.Sprintf("mode=1777,size=%s", size)
+					for _, dir := range []string{tmpSource, vartmpSource} {
+						sylog.Debugf("Adding %s tmpfs for %s", size, dir)
+						if err := system.Points.AddFS(mount.TmpTag, dir, "tmpfs", c.mountFlags(mount.TmpTag, false), options); err != nil {
+							return fmt.Errorf("could not add tmpfs for %s: %s", dir, err)
+						}
+					}
+				}
+			}
 		}
 
 		c.session.OverrideDir(tmpPath, tmpSource)
 		c.session.OverrideDir(varTmpPath, vartmpSource)
 	}
-	flags := uintptr(syscall.MS_BIND | c.suidFlag | syscall.MS_NODEV | syscall.MS_REC)
+	flags := uintptr(syscall.MS_BIND | c.mountFlags(mount.TmpTag, true) | syscall.MS_REC)
 
 	if err := system.Points.AddBind(mount.TmpTag, tmpSource, tmpPath, flags); err == nil {
 		system.Points.AddRemount(mount.TmpTag, tmpPath, flags)
@@ -1529,17 +3575,34 @@ func (c *container) addScratchMount(system *mount.System) error {
 	}
 
 	workdir := c.engine.EngineConfig.GetWorkdir()
-	hasWorkdir := workdir != ""
+	hasWorkdir := workdir != "" && c.engine.EngineConfig.File.ScratchBacking != "tmpfs"
 
 	if hasWorkdir {
 		workdir = filepath.Clean(workdir)
+		if err := c.checkFreeSpace(workdir); err != nil {
+			return err
+		}
 		sourceDir := filepath.Join(workdir, scratchSessionDir)
 		if err := fs.MkdirAll(sourceDir, 0750); err != nil {
 			return fmt.Errorf("could not create scratch working directory %s: %s", sourceDir, err)
 		}
 	}
 
-	for _, dir := range scratchDir {
+	defaultSize := ""
+	if !hasWorkdir {
+		defaultSize = c.scratchTmpfsSize()
+	} else if size := c.engine.EngineConfig.GetScratchSize(); size != "" {
+		sylog.Warningf("Ignoring scratch size %s: scratch directories are backed by working directory", size)
+	}
+
+	for _, spec := range scratchDir {
+		dir, size, err := parseScratchSpec(spec)
+		if err != nil {
+			return err
+		}
+		if size == "" {
+			size = defaultSize
+		}
 		src := filepath.Join(scratchSessionDir, dir)
 		if err := c.session.AddDir(src); err != nil {
 			return fmt.Errorf("could not create scratch working directory %s: %s", src, err)
@@ -1550,10 +3613,24 @@ func (c *container) addScratchMount(system *mount.System) error {
 			if err := fs.MkdirAll(fullSourceDir, 0750); err != nil {
 				return fmt.Errorf("could not create scratch working directory %s: %s", fullSourceDir, err)
 			}
+			if size != "" {
+				if err := c.setScratchQuota(fullSourceDir, size); err != nil {
+					return err
+				}
+			}
+		} else if size != "" {
+			// use a dedicated tmpfs so a full scratch directory
+			// returns ENOSPC instead of exhausting session directory
+			options := fmt.Sprintf("mode=0750,uid=%d,gid=%d,size=%s", os.Getuid(), os.Getgid(), size)
+			sylog.Debugf("Adding %s tmpfs for scratch directory %s", size, dir)
+			err := system.Points.AddFS(mount.ScratchTag, fullSourceDir, "tmpfs", c.mountFlags(mount.ScratchTag, false), options)
+			if err != nil {
+				return fmt.Errorf("could not add tmpfs for scratch directory %s: %s", dir, err)
+			}
 		}
 		c.session.OverrideDir(dir, fullSourceDir)
 
-		flags := uintptr(syscall.MS_BIND | c.suidFlag | syscall.MS_NODEV | syscall.MS_REC)
+		flags := uintptr(syscall.MS_BIND | c.mountFlags(mount.ScratchTag, true) | syscall.MS_REC)
 		if err := system.Points.AddBind(mount.ScratchTag, fullSourceDir, dir, flags); err != nil {
 			return fmt.Errorf("could not bind scratch directory %s into container: %s", fullSourceDir, err)
 		}
@@ -1562,6 +3639,107 @@ func (c *container) addScratchMount(system *mount.System) error {
 	return nil
 }
 
+// setScratchQuota limits the size of a scratch directory backed by
+// working directory with a project quota if enabled by configuration
+func (c *container) setScratchQuota(dir string, size string) error {
+	if !c.engine.EngineConfig.File.ScratchProjectQuota {
+		sylog.Warningf("Ignoring size %s for scratch directory %s: backed by working directory", size, dir)
+		return nil
+	}
+
+	bytes, err := sizeToBytes(size)
+	if err != nil {
+		sylog.Warningf("Ignoring size %s for scratch directory %s: %s", size, dir, err)
+		return nil
+	}
+
+	sylog.Debugf("Setting %s project quota on scratch directory %s", size, dir)
+	if _, err := c.rpcOps.SetProjectQuota(dir, bytes); err != nil {
+		if err.Error() == quota.ErrNotSupported.Error() {
+			sylog.Warningf("Ignoring size %s for scratch directory %s: %s", size, dir, err)
+			return nil
+		}
+		return fmt.Errorf("could not set project quota on scratch directory %s: %s", dir, err)
+	}
+	return nil
+}
+
+// scratchTmpfsSize returns the size of tmpfs backing scratch directories
+// requested without size, it defaults to 'sessiondir max size' when the
+// scratch size is not set, invalid or zero. An empty string means no limit
+func (c *container) scratchTmpfsSize() string {
+	defaultSize := ""
+	if max := c.engine.EngineConfig.File.SessiondirMaxSize; max > 0 {
+		defaultSize = fmt.Sprintf("%dm", max)
+	}
+
+	size := c.engine.EngineConfig.GetScratchSize()
+	if size == "" {
+		return defaultSize
+	}
+	if err := checkTmpfsSize(size); err != nil {
+		sylog.Warningf("Ignoring scratch size: %s, using 'sessiondir max size' instead", err)
+		return defaultSize
+	}
+	if n, _ := strconv.ParseUint(strings.TrimRight(size, "kKmMgG%"), 10, 64); n == 0 {
+		sylog.Warningf("Ignoring zero scratch size, using 'sessiondir max size' instead")
+		return defaultSize
+	}
+	return size
+}
+
+// sizeToBytes converts a size with an optional k, m or g unit to bytes
+func sizeToBytes(size string) (uint64, error) {
+	shift := uint(0)
+	switch size[len(size)-1] {
+	case 'k', 'K':
+		shift = 10
+	case 'm', 'M':
+		shift = 20
+	case 'g', 'G':
+		shift = 30
+	case '%':
+		return 0, fmt.Errorf("percentage size not supported")
+	}
+	if shift > 0 {
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.ParseUint(size, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+	return n << shift, nil
+}
+
+// parseScratchSpec splits a scratch directory specification of the
+// form <dir>[:<size>] and checks that size is a valid tmpfs size
+func parseScratchSpec(spec string) (string, string, error) {
+	splitted := strings.SplitN(spec, ":", 2)
+	dir := filepath.Clean(splitted[0])
+	if len(splitted) == 1 {
+		return dir, "", nil
+	}
+
+	size := splitted[1]
+	if err := checkTmpfsSize(size); err != nil {
+		return "", "", fmt.Errorf("%s for scratch directory %s", err, dir)
+	}
+	return dir, size, nil
+}
+
+// checkTmpfsSize checks that size is a valid tmpfs size, a number
+// optionally followed by a k, m, g or % unit
+func checkTmpfsSize(size string) error {
+	num := strings.TrimRight(size, "kKmMgG%")
+	if len(size)-len(num) > 1 {
+		return fmt.Errorf("invalid size %q", size)
+	}
+	if _, err := strconv.ParseUint(num, 10, 64); err != nil {
+		return fmt.Errorf("invalid size %q", size)
+	}
+	return nil
+}
+
 func (c *container) addCwdMount(system *mount.System) error {
 	cwd := ""
 
@@ -1589,16 +3767,17 @@ func (c *container) addCwdMount(system *mount.System) error {
 	if err != nil {
 		return fmt.Errorf("could not obtain current directory path: %s", err)
 	}
-	switch current {
-	case "/", "/etc", "/bin", "/mnt", "/usr", "/var", "/opt", "/sbin", "/lib", "/lib64":
-		sylog.Verbosef("Not mounting CWD within operating system directory: %s", current)
-		return nil
+	for _, p := range c.engine.EngineConfig.File.CwdSkipPath {
+		if p != "" && filepath.Clean(p) == current {
+			sylog.Verbosef("Not mounting CWD within operating system directory: %s", current)
+			return nil
+		}
 	}
 	if strings.HasPrefix(current, "/sys") || strings.HasPrefix(current, "/proc") || strings.HasPrefix(current, "/dev") {
 		sylog.Verbosef("Not mounting CWD within virtual directory: %s", current)
 		return nil
 	}
-	flags := uintptr(syscall.MS_BIND | c.suidFlag | syscall.MS_NODEV | syscall.MS_REC)
+	flags := uintptr(syscall.MS_BIND | c.mountFlags(mount.CwdTag, true) | syscall.MS_REC)
 	if err := system.Points.AddBind(mount.CwdTag, current, cwd, flags); err == nil {
 		system.Points.AddRemount(mount.CwdTag, cwd, flags)
 		c.checkDest = append(c.checkDest, cwd)
@@ -1616,7 +3795,7 @@ func (c *container) addLibsMount(system *mount.System) error {
 		return nil
 	}
 
-	flags := uintptr(syscall.MS_BIND | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_RDONLY | syscall.MS_REC)
+	flags := uintptr(syscall.MS_BIND | c.mountFlags(mount.FilesTag, false) | syscall.MS_RDONLY | syscall.MS_REC)
 
 	containerDir := "/.singularity.d/libs"
 	sessionDir := "/libs"
@@ -1627,12 +3806,18 @@ func (c *container) addLibsMount(system *mount.System) error {
 
 	libraries := c.engine.EngineConfig.GetLibrariesPath()
 
+	// libraries are ordered by precedence, the first library
+	// with a given file name is bound
 	for _, lib := range libraries {
-		sylog.Debugf("Add library %s to mount list", lib)
-
 		file := filepath.Base(lib)
 		sessionFile := filepath.Join(sessionDir, file)
 
+		if _, err := c.session.GetPath(sessionFile); err == nil {
+			sylog.Verbosef("Skipping library %s, a library with the same name is already bound", lib)
+			continue
+		}
+		sylog.Debugf("Add library %s to mount list", lib)
+
 		if err := c.session.AddFile(sessionFile, []byte{}); err != nil {
 			return err
 		}
@@ -1661,6 +3846,51 @@ func (c *container) addLibsMount(system *mount.System) error {
 	return nil
 }
 
+// addKernelModulesMount binds host kernel modules directory matching
+// the running kernel and kernel sources directory into container
+func (c *container) addKernelModulesMount(system *mount.System) error {
+	if !c.engine.EngineConfig.GetKernelModules() {
+		return nil
+	}
+
+	sylog.Debugf("Checking for 'user bind control' in configuration file")
+	if !c.engine.EngineConfig.File.UserBindControl {
+		sylog.Warningf("Ignoring kernel modules bind request: user bind control disabled by system administrator")
+		return nil
+	}
+
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return fmt.Errorf("failed to determine kernel release: %s", err)
+	}
+
+	modulesDir := filepath.Join("/lib/modules", strings.TrimSpace(string(release)))
+	if !fs.IsDir(modulesDir) {
+		return fmt.Errorf("kernel modules directory %s not found on host", modulesDir)
+	}
+
+	flags := uintptr(syscall.MS_BIND | c.mountFlags(mount.BindsTag, true) | syscall.MS_RDONLY | syscall.MS_REC)
+
+	for _, path := range []string{modulesDir, "/usr/src"} {
+		if !fs.IsDir(path) {
+			sylog.Warningf("Skipping %s bind mount: not found on host", path)
+			continue
+		}
+
+		sylog.Debugf("Adding %s to mount list\n", path)
+
+		if err := system.Points.AddBind(mount.BindsTag, path, path, flags); err == mount.ErrMountExists {
+			sylog.Warningf("destination %s already in mount list: %s", path, err)
+		} else if err != nil {
+			return fmt.Errorf("unable to add %s to mount list: %s", path, err)
+		} else {
+			system.Points.AddRemount(mount.BindsTag, path, flags)
+		}
+	}
+
+	return nil
+}
+
 func (c *container) addIdentityMount(system *mount.System) error {
 	if (os.Geteuid() == 0 && c.engine.EngineConfig.GetTargetUID() == 0) ||
 		c.engine.EngineConfig.GetFakeroot() {
@@ -1728,6 +3958,65 @@ func (c *container) addIdentityMount(system *mount.System) error {
 	return nil
 }
 
+// addMtabMount replaces container /etc/mtab file by a file listing
+// the container mount points, it's called once all mount points
+// except the final ones are mounted
+func (c *container) addMtabMount(system *mount.System) error {
+	const mtab = "/etc/mtab"
+
+	if !c.engine.EngineConfig.File.ConfigMtab {
+		sylog.Debugf("Skipping %s generation per configuration", mtab)
+		return nil
+	}
+
+	fi, err := os.Lstat(filepath.Join(c.session.FinalPath(), mtab))
+	if err != nil || !fi.Mode().IsRegular() {
+		sylog.Debugf("Skipping %s generation: not a regular file", mtab)
+		return nil
+	}
+
+	mounts, err := ioutil.ReadFile(filepath.Join(filepath.Dir(c.mountInfoPath), "mounts"))
+	if err != nil {
+		return fmt.Errorf("while reading container mount points: %s", err)
+	}
+
+	defer c.session.Update()
+
+	if err := c.session.AddFile(mtab, mtabContent(mounts, c.session.FinalPath())); err != nil {
+		return fmt.Errorf("failed to add %s session file: %s", mtab, err)
+	}
+	sessionFile, _ := c.session.GetPath(mtab)
+
+	sylog.Debugf("Adding %s to mount list\n", mtab)
+	if err := system.Points.AddBind(mount.FinalTag, sessionFile, mtab, syscall.MS_BIND); err != nil {
+		return fmt.Errorf("unable to add %s to mount list: %s", mtab, err)
+	}
+	return nil
+}
+
+// mtabContent returns the entries of mounts located under root
+// directory with mount point paths relative to root
+func mtabContent(mounts []byte, root string) []byte {
+	var content bytes.Buffer
+
+	for _, line := range strings.Split(string(mounts), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[1] == root {
+			fields[1] = "/"
+		} else if strings.HasPrefix(fields[1], root+"/") {
+			fields[1] = strings.TrimPrefix(fields[1], root)
+		} else {
+			continue
+		}
+		content.WriteString(strings.Join(fields, " ") + "\n")
+	}
+
+	return content.Bytes()
+}
+
 func (c *container) addResolvConfMount(system *mount.System) error {
 	resolvConf := "/etc/resolv.conf"
 
@@ -1771,43 +4060,220 @@ func (c *container) addResolvConfMount(system *mount.System) error {
 	return nil
 }
 
-func (c *container) addHostnameMount(system *mount.System) error {
-	hostnameFile := "/etc/hostname"
+// caCertificatesPaths lists host CA certificates locations across
+// distributions
+var caCertificatesPaths = []string{
+	"/etc/ssl/certs",
+	"/etc/pki/tls",
+	"/etc/pki/ca-trust",
+	"/etc/ca-certificates",
+}
 
-	if c.utsNS {
-		hostname := c.engine.EngineConfig.GetHostname()
-		if hostname != "" {
-			sylog.Debugf("Set container hostname %s", hostname)
+// addCACertificatesMount binds host CA certificates directories
+// read-only when 'config ca certificates' is enabled
+func (c *container) addCACertificatesMount(system *mount.System) error {
+	if !c.engine.EngineConfig.File.ConfigCACertificates {
+		sylog.Debugf("Skipping bind of the host's CA certificates")
+		return nil
+	}
 
-			content, err := files.Hostname(hostname)
-			if err != nil {
-				return fmt.Errorf("unable to add %s to hostname file: %s", hostname, err)
-			}
-			if err := c.session.AddFile(hostnameFile, content); err != nil {
-				return fmt.Errorf("failed to add hostname session file: %s", err)
-			}
-			sessionFile, _ := c.session.GetPath(hostnameFile)
+	flags := uintptr(syscall.MS_BIND | syscall.MS_RDONLY | c.mountFlags(mount.FilesTag, false) | syscall.MS_REC)
+	found := false
 
-			sylog.Debugf("Adding %s to mount list\n", hostnameFile)
-			err = system.Points.AddBind(mount.FilesTag, sessionFile, hostnameFile, syscall.MS_BIND)
-			if err != nil {
-				return fmt.Errorf("unable to add %s to mount list: %s", hostnameFile, err)
+	for _, path := range caCertificatesPaths {
+		if !fs.IsDir(path) {
+			continue
+		}
+		found = true
+
+		sylog.Debugf("Adding %s to mount list\n", path)
+		if err := system.Points.AddBind(mount.FilesTag, path, path, flags); err == mount.ErrMountExists {
+			sylog.Debugf("Skipping %s, already in mount list", path)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("unable to add %s to mount list: %s", path, err)
+		}
+		system.Points.AddRemount(mount.FilesTag, path, flags)
+		sylog.Verbosef("Default mount: %s:%s", path, path)
+	}
+
+	if !found {
+		sylog.Warningf("No CA certificates found on host, skipping CA certificates bind")
+	}
+	return nil
+}
+
+// localePaths lists host locale data bound by 'config locale'
+var localePaths = []string{
+	"/usr/lib/locale/locale-archive",
+	"/usr/share/locale",
+}
+
+// addLocaleMount binds host locale data read-only when 'config locale'
+// is enabled, paths provided by the container image are left untouched
+func (c *container) addLocaleMount(system *mount.System) error {
+	if !c.engine.EngineConfig.File.ConfigLocale {
+		sylog.Debugf("Skipping bind of the host's locale data")
+		return nil
+	}
+
+	flags := uintptr(syscall.MS_BIND | syscall.MS_RDONLY | c.mountFlags(mount.FilesTag, false) | syscall.MS_REC)
+	bound := make([]string, 0, len(localePaths))
+
+	for _, path := range localePaths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		sylog.Debugf("Adding %s to mount list\n", path)
+		if err := system.Points.AddBind(mount.FilesTag, path, path, flags); err == mount.ErrMountExists {
+			sylog.Debugf("Skipping %s, already in mount list", path)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("unable to add %s to mount list: %s", path, err)
+		}
+		system.Points.AddRemount(mount.FilesTag, path, flags)
+		bound = append(bound, path)
+	}
+
+	if len(bound) == 0 {
+		sylog.Debugf("No locale data found on host, skipping locale bind")
+		return nil
+	}
+
+	return system.RunAfterTag(mount.RootfsTag, func(system *mount.System) error {
+		for _, path := range bound {
+			if _, err := os.Lstat(filepath.Join(c.session.RootFsPath(), path)); err == nil {
+				sylog.Debugf("%s provided by container, removing it from mount list", path)
+				system.Points.RemoveByDest(path)
+				continue
 			}
-			sylog.Verbosef("Default mount: /etc/hostname:/etc/hostname")
-			if _, err := c.rpcOps.SetHostname(hostname); err != nil {
-				return fmt.Errorf("failed to set container hostname: %s", err)
+			sylog.Verbosef("Default mount: %s:%s", path, path)
+		}
+		return nil
+	})
+}
+
+// addBusyboxMount binds the static busybox set by 'busybox path'
+// directive as /bin/sh in a staged /bin directory when the shell
+// command is used with a container image providing no shell
+func (c *container) addBusyboxMount(system *mount.System) error {
+	busybox := c.engine.EngineConfig.File.BusyboxPath
+	process := c.engine.EngineConfig.OciConfig.Process
+
+	if busybox == "" || process == nil || len(process.Args) == 0 || process.Args[0] != "/.singularity.d/actions/shell" {
+		return nil
+	}
+
+	shells := []string{defaultShell}
+	if shell := c.engine.EngineConfig.GetShell(); shell != "" {
+		shells = append([]string{shell}, shells...)
+	}
+
+	sessionDir := "/busybox"
+	sessionFile := filepath.Join(sessionDir, "sh")
+
+	if err := c.session.AddDir(sessionDir); err != nil {
+		return fmt.Errorf("failed to add %s session directory: %s", sessionDir, err)
+	}
+	if err := c.session.AddFile(sessionFile, nil); err != nil {
+		return fmt.Errorf("failed to add %s session file: %s", sessionFile, err)
+	}
+	sessionDirPath, _ := c.session.GetPath(sessionDir)
+	sessionFilePath, _ := c.session.GetPath(sessionFile)
+
+	return system.RunAfterTag(mount.RootfsTag, func(system *mount.System) error {
+		rootfs := c.session.RootFsPath()
+		for _, shell := range shells {
+			if fs.IsFile(filepath.Join(rootfs, fs.EvalRelative(shell, rootfs))) {
+				sylog.Debugf("Shell %s found in container", shell)
+				return nil
 			}
 		}
-	} else {
+
+		if !fs.IsFile(busybox) {
+			return fmt.Errorf("no shell found in container and busybox %s not found on host", busybox)
+		}
+		sylog.Verbosef("No shell found in container, using %s as /bin/sh", busybox)
+
+		flags := uintptr(syscall.MS_BIND | syscall.MS_RDONLY | c.mountFlags(mount.FilesTag, false))
+		if err := system.Points.AddBind(mount.FilesTag, busybox, sessionFilePath, flags); err != nil {
+			return fmt.Errorf("unable to add %s to mount list: %s", busybox, err)
+		}
+		system.Points.AddRemount(mount.FilesTag, sessionFilePath, flags)
+
+		if err := system.Points.AddBind(mount.FilesTag, sessionDirPath, "/bin", flags); err != nil {
+			return fmt.Errorf("unable to add %s to mount list: %s", sessionDirPath, err)
+		}
+		return system.Points.AddRemount(mount.FilesTag, "/bin", flags)
+	})
+}
+
+func (c *container) addHostnameMount(system *mount.System) error {
+	hostnameFile := "/etc/hostname"
+
+	if !c.utsNS {
 		sylog.Debugf("Skipping hostname mount, not virtualizing UTS namespace on user request")
+		return nil
 	}
-	return nil
+
+	hostname := c.engine.EngineConfig.GetHostname()
+	override := hostname != ""
+
+	if override {
+		sylog.Debugf("Set container hostname %s", hostname)
+		if _, err := c.rpcOps.SetHostname(hostname); err != nil {
+			return fmt.Errorf("failed to set container hostname: %s", err)
+		}
+	} else {
+		var err error
+		// UTS namespace inherits the current hostname
+		hostname, err = os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get container hostname: %s", err)
+		}
+	}
+
+	sylog.Debugf("Checking for 'config hostname' in configuration file")
+	if !c.engine.EngineConfig.File.ConfigHostname {
+		sylog.Verbosef("Skipping %s generation (per config)", hostnameFile)
+		return nil
+	}
+
+	content, err := files.Hostname(hostname)
+	if err != nil {
+		return fmt.Errorf("unable to add %s to hostname file: %s", hostname, err)
+	}
+	if err := c.session.AddFile(hostnameFile, content); err != nil {
+		return fmt.Errorf("failed to add hostname session file: %s", err)
+	}
+	sessionFile, _ := c.session.GetPath(hostnameFile)
+
+	sylog.Debugf("Adding %s to mount list\n", hostnameFile)
+	err = system.Points.AddBind(mount.FilesTag, sessionFile, hostnameFile, syscall.MS_BIND)
+	if err != nil {
+		return fmt.Errorf("unable to add %s to mount list: %s", hostnameFile, err)
+	}
+	sylog.Verbosef("Default mount: /etc/hostname:/etc/hostname")
+
+	if override {
+		return nil
+	}
+
+	// keep the image hostname file when no hostname was requested
+	return system.RunAfterTag(mount.RootfsTag, func(system *mount.System) error {
+		if _, err := os.Lstat(filepath.Join(c.session.RootFsPath(), hostnameFile)); err == nil {
+			sylog.Debugf("%s provided by container, removing it from mount list", hostnameFile)
+			system.Points.RemoveByDest(hostnameFile)
+		}
+		return nil
+	})
 }
 
 func (c *container) addActionsMount(system *mount.System) error {
 	hostDir := filepath.Join(buildcfg.SYSCONFDIR, "/singularity/actions")
 	containerDir := "/.singularity.d/actions"
-	flags := uintptr(syscall.MS_BIND | syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV)
+	flags := uintptr(syscall.MS_BIND | syscall.MS_RDONLY | c.mountFlags(mount.BindsTag, false))
 
 	actionsDir := filepath.Join(c.session.RootFsPath(), containerDir)
 	if !fs.IsDir(actionsDir) {
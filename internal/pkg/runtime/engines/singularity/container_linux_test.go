@@ -0,0 +1,2681 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/layout"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/layout/layer/overlay"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/mount"
+	"github.com/sylabs/singularity/pkg/image"
+	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engines/singularity/config"
+	"github.com/sylabs/singularity/pkg/util/fs/proc"
+	"golang.org/x/crypto/openpgp"
+)
+
+// overlayEntry describes an overlay image passed with --overlay
+type overlayEntry struct {
+	imgType    int
+	partitions []image.Section
+	writable   bool
+}
+
+// expectedMount describes the expected mount of an overlay entry, an empty
+// fstype means a bind mount of a sandbox directory
+type expectedMount struct {
+	fstype   string
+	offset   uint64
+	readonly bool
+}
+
+var (
+	ext3Part   = image.Section{Offset: 0, Size: 1024, Type: image.EXT3}
+	squashPart = image.Section{Offset: 0, Size: 1024, Type: image.SQUASHFS}
+	rootfsPart = image.Section{Offset: 512, Size: 1024, Type: image.SQUASHFS, Name: image.RootFs}
+	sifExt3    = image.Section{Offset: 2048, Size: 1024, Type: image.EXT3, Name: "overlay"}
+	sifSquash  = image.Section{Offset: 4096, Size: 1024, Type: image.SQUASHFS, Name: "data"}
+)
+
+func newOverlayContainer(t *testing.T, dir string, entries []overlayEntry) (*container, *mount.System) {
+	engineConfig := singularityConfig.NewConfig()
+
+	images := []image.Image{{Path: filepath.Join(dir, "rootfs"), Type: image.SQUASHFS}}
+	overlays := make([]string, 0, len(entries))
+
+	if err := os.Mkdir(filepath.Join(dir, "overlay"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, e := range entries {
+		path := filepath.Join(dir, "overlay", strconv.Itoa(i))
+		if e.imgType == image.SANDBOX {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, image.Image{
+			Path:       path,
+			Source:     "/proc/self/fd/" + strconv.Itoa(i+3),
+			Type:       e.imgType,
+			Writable:   e.writable,
+			Partitions: e.partitions,
+		})
+		if e.writable {
+			overlays = append(overlays, path)
+		} else {
+			overlays = append(overlays, path+":ro")
+		}
+	}
+
+	engineConfig.SetImageList(images)
+	engineConfig.SetOverlayImage(overlays)
+
+	c := newTestContainer(t, dir, engineConfig, true)
+
+	system := &mount.System{Points: &mount.Points{}}
+
+	session, err := layout.NewSession(c.sessionPath, c.sessionFsType, 0, 0, system, overlay.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.session = session
+
+	return c, system
+}
+
+// newTestContainer returns a container instance suitable to build a mount
+// plan with a fake mount system, dir is used as session directory parent
+func newTestContainer(t *testing.T, dir string, engineConfig *singularityConfig.EngineConfig, overlayEnabled bool) *container {
+	sessionPath, err := ioutil.TempDir(dir, "session-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newContainer(&EngineOperations{EngineConfig: engineConfig}, nil, os.Getpid())
+	c.sessionFsType = "tmpfs"
+	c.sessionPath = sessionPath
+	c.overlayCheck = func() bool {
+		return overlayEnabled
+	}
+	return c
+}
+
+// newTestSession returns a fake mount system with the container session
+// layout set up on top of it
+func newTestSession(t *testing.T, c *container) *mount.System {
+	system := &mount.System{Points: &mount.Points{}}
+
+	session, err := layout.NewSession(c.sessionPath, c.sessionFsType, 0, 0, system, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.session = session
+
+	return system
+}
+
+func TestAddOverlayMount(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	tests := []struct {
+		name    string
+		entries []overlayEntry
+		mounts  []expectedMount
+		upper   int
+		wantErr bool
+	}{
+		{
+			name:    "writable ext3",
+			entries: []overlayEntry{{image.EXT3, []image.Section{ext3Part}, true}},
+			mounts:  []expectedMount{{"ext3", 0, false}},
+			upper:   0,
+		},
+		{
+			name:    "read-only ext3",
+			entries: []overlayEntry{{image.EXT3, []image.Section{ext3Part}, false}},
+			mounts:  []expectedMount{{"ext3", 0, true}},
+			upper:   -1,
+		},
+		{
+			name:    "squashfs always read-only",
+			entries: []overlayEntry{{image.SQUASHFS, []image.Section{squashPart}, true}},
+			mounts:  []expectedMount{{"squashfs", 0, true}},
+			upper:   -1,
+		},
+		{
+			name:    "writable sandbox",
+			entries: []overlayEntry{{image.SANDBOX, nil, true}},
+			mounts:  []expectedMount{{"", 0, false}},
+			upper:   0,
+		},
+		{
+			name:    "SIF with ext3 overlay partition",
+			entries: []overlayEntry{{image.SIF, []image.Section{rootfsPart, sifExt3}, true}},
+			mounts:  []expectedMount{{"ext3", 2048, false}},
+			upper:   0,
+		},
+		{
+			name:    "SIF with squashfs data partition",
+			entries: []overlayEntry{{image.SIF, []image.Section{rootfsPart, sifSquash}, true}},
+			mounts:  []expectedMount{{"squashfs", 4096, true}},
+			upper:   -1,
+		},
+		{
+			name:    "SIF without overlay partition",
+			entries: []overlayEntry{{image.SIF, []image.Section{rootfsPart}, true}},
+			wantErr: true,
+		},
+		{
+			name: "squashfs, SIF and ext3",
+			entries: []overlayEntry{
+				{image.SQUASHFS, []image.Section{squashPart}, false},
+				{image.SIF, []image.Section{rootfsPart, sifExt3}, true},
+				{image.EXT3, []image.Section{ext3Part}, true},
+			},
+			mounts: []expectedMount{
+				{"squashfs", 0, true},
+				{"ext3", 2048, false},
+				{"ext3", 0, true},
+			},
+			upper: 1,
+		},
+		{
+			name: "read-only ext3 before writable SIF",
+			entries: []overlayEntry{
+				{image.EXT3, []image.Section{ext3Part}, false},
+				{image.SIF, []image.Section{rootfsPart, sifExt3}, true},
+			},
+			mounts: []expectedMount{
+				{"ext3", 0, true},
+				{"ext3", 2048, false},
+			},
+			upper: 1,
+		},
+		{
+			name: "sandbox, ext3 and SIF squashfs",
+			entries: []overlayEntry{
+				{image.SANDBOX, nil, true},
+				{image.EXT3, []image.Section{ext3Part}, true},
+				{image.SIF, []image.Section{rootfsPart, sifSquash}, false},
+			},
+			mounts: []expectedMount{
+				{"", 0, false},
+				{"ext3", 0, true},
+				{"squashfs", 4096, true},
+			},
+			upper: 0,
+		},
+		{
+			name: "all read-only",
+			entries: []overlayEntry{
+				{image.SIF, []image.Section{rootfsPart, sifSquash}, false},
+				{image.SANDBOX, nil, false},
+				{image.EXT3, []image.Section{ext3Part}, false},
+				{image.SQUASHFS, []image.Section{squashPart}, false},
+			},
+			mounts: []expectedMount{
+				{"squashfs", 4096, true},
+				{"", 0, true},
+				{"ext3", 0, true},
+				{"squashfs", 0, true},
+			},
+			upper: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "overlay-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c, system := newOverlayContainer(t, dir, tt.entries)
+
+			err = c.addOverlayMount(system)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			points := system.Points.GetByTag(mount.PreLayerTag)
+
+			for i, m := range tt.mounts {
+				dst, _ := c.session.GetPath(filepath.Join("/overlay-images", strconv.Itoa(i)))
+
+				var point *mount.Point
+				for j, p := range points {
+					if p.Destination == dst && !mount.HasRemountFlag(flagsOf(p)) {
+						point = &points[j]
+						break
+					}
+				}
+				if point == nil {
+					t.Fatalf("no mount point found for overlay entry %d", i)
+				}
+				if point.Type != m.fstype {
+					t.Errorf("overlay entry %d: got fstype %q instead of %q", i, point.Type, m.fstype)
+				}
+				if m.fstype != "" {
+					offset, _ := mount.GetOffset(point.InternalOptions)
+					if offset != m.offset {
+						t.Errorf("overlay entry %d: got offset %d instead of %d", i, offset, m.offset)
+					}
+				}
+				if ro := isReadOnly(points, dst); ro != m.readonly {
+					t.Errorf("overlay entry %d: got read-only %v instead of %v", i, ro, m.readonly)
+				}
+			}
+
+			upper := c.session.Layer.(*overlay.Overlay).GetUpperDir()
+			if tt.upper < 0 {
+				if upper != "" {
+					t.Errorf("unexpected upper directory %s", upper)
+				}
+			} else {
+				dst, _ := c.session.GetPath(filepath.Join("/overlay-images", strconv.Itoa(tt.upper)))
+				if upper != filepath.Join(dst, "upper") {
+					t.Errorf("got upper directory %s instead of %s", upper, filepath.Join(dst, "upper"))
+				}
+			}
+		})
+	}
+}
+
+// flagsOf returns mount flags of a mount point
+func flagsOf(p mount.Point) uintptr {
+	flags, _ := mount.ConvertOptions(p.Options)
+	return flags
+}
+
+// isReadOnly returns if the last mount point registered for
+// destination dst is mounted read-only
+func isReadOnly(points []mount.Point, dst string) bool {
+	ro := false
+	for _, p := range points {
+		if p.Destination != dst {
+			continue
+		}
+		ro = false
+		for _, o := range p.Options {
+			if strings.TrimSpace(o) == "ro" {
+				ro = true
+			}
+		}
+	}
+	return ro
+}
+
+func TestSessionNoexec(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "session-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, _ := newOverlayContainer(t, dir, nil)
+	c.engine.EngineConfig.SetWritableTmpfs(true)
+	c.sessionFlags = syscall.MS_NOEXEC
+
+	system := &mount.System{Points: &mount.Points{}}
+	if err := c.setupOverlayLayout(system, c.session.Path()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, tag := range []mount.AuthorizedTag{mount.SessionTag, mount.PreLayerTag} {
+		for _, p := range system.Points.GetByTag(tag) {
+			if flagsOf(p)&syscall.MS_NOEXEC == 0 {
+				t.Errorf("%s mount point %s is not mounted noexec", tag, p.Destination)
+			}
+		}
+	}
+}
+
+func TestAddMountPoints(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "mountplan-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	for _, d := range []string{"proc", "sys", "dev", "tmp", "var/tmp", "etc"} {
+		if err := os.MkdirAll(filepath.Join(rootfs, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	engineConfig := singularityConfig.NewConfig()
+	if err := config.Parser("", engineConfig.File); err != nil {
+		t.Fatal(err)
+	}
+	engineConfig.SetImage(rootfs)
+	engineConfig.SetImageList([]image.Image{
+		{
+			Path:       rootfs,
+			Type:       image.SANDBOX,
+			Partitions: []image.Section{{Type: image.SANDBOX, Name: image.RootFs}},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		overlay bool
+		layer   string
+	}{
+		{"overlay", true, "overlay"},
+		{"underlay", false, "underlay"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContainer(t, dir, engineConfig, tt.overlay)
+			system := &mount.System{Points: &mount.Points{}}
+
+			if err := c.addMountPoints(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if c.sessionLayerType != tt.layer {
+				t.Errorf("got %s session layer instead of %s", c.sessionLayerType, tt.layer)
+			}
+
+			if len(system.Points.GetByTag(mount.SessionTag)) != 1 {
+				t.Errorf("no session mount point found")
+			}
+			points := system.Points.GetByTag(mount.RootfsTag)
+			if len(points) == 0 || points[0].Source != rootfs {
+				t.Errorf("no root filesystem mount point found for %s", rootfs)
+			}
+
+			for _, dest := range []string{"/proc", "/sys", "/tmp", "/var/tmp"} {
+				if len(system.Points.GetByDest(dest)) == 0 {
+					t.Errorf("no mount point found for %s", dest)
+				}
+			}
+		})
+	}
+}
+
+func TestAddOptionalBind(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "optional-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	missing := filepath.Join(dir, "missing")
+
+	engineConfig := singularityConfig.NewConfig()
+	engineConfig.File.UserBindControl = true
+	engineConfig.SetBindPath([]string{
+		dir + ":/present:optional",
+		missing + ":/data:ro:optional",
+	})
+
+	tests := []struct {
+		name      string
+		layer     string
+		withPoint bool
+	}{
+		{"overlay", "overlay", true},
+		{"underlay", "underlay", true},
+		{"no layer", "none", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContainer(t, dir, engineConfig, false)
+			system := newTestSession(t, c)
+			c.sessionLayerType = tt.layer
+
+			if err := c.addUserbindsMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if points := system.Points.GetByDest("/present"); len(points) == 0 || points[0].Source != dir {
+				t.Errorf("optional bind with existing source not found in mount list")
+			}
+
+			points := system.Points.GetByDest("/data")
+			if !tt.withPoint {
+				if len(points) != 0 {
+					t.Errorf("unexpected mount point for /data")
+				}
+				return
+			}
+			if len(points) == 0 {
+				t.Fatalf("no mount point found for /data")
+			}
+			if !strings.HasPrefix(points[0].Source, c.session.Path()) {
+				t.Errorf("optional bind source %s is not a session directory", points[0].Source)
+			}
+			if !isReadOnly(points, "/data") {
+				t.Errorf("optional bind /data is not read-only")
+			}
+		})
+	}
+}
+
+func TestAddKernelModulesMount(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "modules-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modulesDir := filepath.Join("/lib/modules", strings.TrimSpace(string(release)))
+
+	engineConfig := singularityConfig.NewConfig()
+	engineConfig.File.UserBindControl = true
+	engineConfig.SetKernelModules(true)
+
+	c := newTestContainer(t, dir, engineConfig, false)
+	system := &mount.System{Points: &mount.Points{}}
+
+	err = c.addKernelModulesMount(system)
+	if _, statErr := os.Stat(modulesDir); os.IsNotExist(statErr) {
+		if err == nil {
+			t.Fatalf("unexpected success with missing %s", modulesDir)
+		}
+		return
+	} else if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !isReadOnly(system.Points.GetByDest(modulesDir), modulesDir) {
+		t.Errorf("%s is not bound read-only", modulesDir)
+	}
+}
+
+func TestImageName(t *testing.T) {
+	engineConfig := singularityConfig.NewConfig()
+	engineConfig.SetImageList([]image.Image{
+		{Path: "/images/rootfs.sif", Fd: 5},
+		{Path: "/images/overlay.img", Source: "/proc/self/fd/8"},
+	})
+	c := newContainer(&EngineOperations{EngineConfig: engineConfig}, nil, os.Getpid())
+
+	tests := []struct {
+		source string
+		name   string
+	}{
+		{"/proc/self/fd/5", "/images/rootfs.sif"},
+		{"/proc/self/fd/8", "/images/overlay.img"},
+		{"/proc/self/fd/9", "/proc/self/fd/9"},
+	}
+	for _, tt := range tests {
+		if name := c.imageName(tt.source); name != tt.name {
+			t.Errorf("got %q for %s instead of %q", name, tt.source, tt.name)
+		}
+	}
+}
+
+func TestParseScratchSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		dir  string
+		size string
+		fail bool
+	}{
+		{"/scratch", "/scratch", "", false},
+		{"/scratch/", "/scratch", "", false},
+		{"/scratch:10G", "/scratch", "10G", false},
+		{"/scratch:512m", "/scratch", "512m", false},
+		{"/scratch:50%", "/scratch", "50%", false},
+		{"/scratch:1024", "/scratch", "1024", false},
+		{"/scratch:", "", "", true},
+		{"/scratch:10GG", "", "", true},
+		{"/scratch:G", "", "", true},
+		{"/scratch:-1G", "", "", true},
+	}
+
+	for _, tt := range tests {
+		dir, size, err := parseScratchSpec(tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("unexpected success with %q", tt.spec)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("unexpected error with %q: %s", tt.spec, err)
+			continue
+		}
+		if dir != tt.dir || size != tt.size {
+			t.Errorf("got %q/%q for %q instead of %q/%q", dir, size, tt.spec, tt.dir, tt.size)
+		}
+	}
+}
+
+func TestAddScratchMount(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "scratch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name        string
+		backing     string
+		workdir     bool
+		tmpfs       bool
+		scratchSize string
+		dataSize    string
+	}{
+		{"session", "workdir", false, true, "", "16m"},
+		{"session with scratch size", "workdir", false, true, "1G", "1G"},
+		{"session with invalid scratch size", "workdir", false, true, "1GG", "16m"},
+		{"session with zero scratch size", "workdir", false, true, "0", "16m"},
+		{"workdir", "workdir", true, false, "", ""},
+		{"workdir with scratch size", "workdir", true, false, "1G", ""},
+		{"workdir with tmpfs backing", "tmpfs", true, true, "", "16m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.UserBindControl = true
+			engineConfig.File.ScratchBacking = tt.backing
+			engineConfig.File.SessiondirMaxSize = 16
+			engineConfig.SetScratchDir([]string{"/scratch:10G,/data"})
+			engineConfig.SetScratchSize(tt.scratchSize)
+			if tt.workdir {
+				engineConfig.SetWorkdir(filepath.Join(dir, tt.name, "workdir"))
+			}
+
+			c := newTestContainer(t, dir, engineConfig, false)
+			system := newTestSession(t, c)
+
+			if err := c.addScratchMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var tmpfs []mount.Point
+			for _, p := range system.Points.GetByTag(mount.ScratchTag) {
+				if p.Type == "tmpfs" {
+					tmpfs = append(tmpfs, p)
+				}
+			}
+			if !tt.tmpfs {
+				if len(tmpfs) != 0 {
+					t.Errorf("unexpected tmpfs scratch mount point")
+				}
+				return
+			}
+			if len(tmpfs) != 2 {
+				t.Fatalf("got %d tmpfs scratch mount points instead of 2", len(tmpfs))
+			}
+			for i, size := range []string{"10G", tt.dataSize} {
+				found := false
+				for _, opt := range tmpfs[i].Options {
+					if opt == "size="+size {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("size=%s option missing from %v", size, tmpfs[i].Options)
+				}
+			}
+			if points := system.Points.GetByDest("/scratch"); len(points) == 0 || points[0].Source != tmpfs[0].Destination {
+				t.Errorf("scratch tmpfs is not bound to /scratch")
+			}
+			if points := system.Points.GetByDest("/data"); len(points) == 0 || points[0].Source != tmpfs[1].Destination {
+				t.Errorf("scratch tmpfs is not bound to /data")
+			}
+		})
+	}
+}
+
+func TestAddTmpMountSize(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "tmp-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, size := range []string{"", "1G"} {
+		engineConfig := singularityConfig.NewConfig()
+		engineConfig.File.MountTmp = true
+		engineConfig.File.SessiondirMaxSize = 16
+		engineConfig.SetContain(true)
+		engineConfig.SetScratchSize(size)
+
+		c := newTestContainer(t, dir, engineConfig, false)
+		system := newTestSession(t, c)
+
+		if err := c.addTmpMount(system); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var tmpfs []mount.Point
+		for _, p := range system.Points.GetByTag(mount.TmpTag) {
+			if p.Type == "tmpfs" {
+				tmpfs = append(tmpfs, p)
+			}
+		}
+		if size == "" {
+			if len(tmpfs) != 0 {
+				t.Errorf("unexpected tmpfs mount point without scratch size")
+			}
+			continue
+		}
+		if len(tmpfs) != 2 {
+			t.Fatalf("got %d tmpfs mount points instead of 2", len(tmpfs))
+		}
+		for _, p := range tmpfs {
+			found := false
+			for _, opt := range p.Options {
+				if opt == "size="+size {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("size=%s option missing from %v", size, p.Options)
+			}
+		}
+	}
+}
+
+func TestCheckBindLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   uint
+		contain bool
+		fail    bool
+	}{
+		{"no limit", 0, false, false},
+		{"under limit", 4, false, false},
+		{"at limit", 3, false, false},
+		{"over limit", 2, false, true},
+		{"over limit without host binds", 2, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.BindPath = []string{"/etc/localtime"}
+			engineConfig.File.MaxBindPoints = tt.limit
+			engineConfig.SetBindPath([]string{"/opt", "/srv"})
+			engineConfig.SetContain(tt.contain)
+
+			c := &container{engine: &EngineOperations{EngineConfig: engineConfig}}
+
+			err := c.checkBindLimit()
+			if tt.fail && err == nil {
+				t.Errorf("unexpected success")
+			} else if !tt.fail && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestCheckBindLoops(t *testing.T) {
+	tests := []struct {
+		name    string
+		binds   []string
+		contain bool
+		strict  bool
+		fail    bool
+	}{
+		{"no loop", []string{"/opt", "/srv:/data"}, false, true, false},
+		{"same bind", []string{"/opt:/opt/data"}, false, true, false},
+		{"source equals destination", []string{"/opt:/data", "/srv:/opt"}, false, true, true},
+		{"source contains destination", []string{"/opt:/data", "/srv:/opt/srv"}, false, true, true},
+		{"source contains host bind destination", []string{"/etc:/data"}, false, true, true},
+		{"contained", []string{"/etc:/data"}, true, true, false},
+		{"prefix only", []string{"/opt:/data", "/srv:/optional"}, false, true, false},
+		{"not strict", []string{"/opt:/data", "/srv:/opt"}, false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.BindPath = []string{"/etc/localtime"}
+			engineConfig.File.StrictBindCheck = tt.strict
+			engineConfig.SetBindPath(tt.binds)
+			engineConfig.SetContain(tt.contain)
+
+			c := &container{engine: &EngineOperations{EngineConfig: engineConfig}}
+
+			err := c.checkBindLoops()
+			if tt.fail && err == nil {
+				t.Errorf("unexpected success")
+			} else if !tt.fail && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestAddBindsMount(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "binds-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	engineConfig := singularityConfig.NewConfig()
+	engineConfig.File.BindPath = []string{
+		dir + ":/ro:ro",
+		dir + ":/rw:rw",
+		dir + ":/empty:",
+		dir + ":/unknown:foo",
+		dir,
+	}
+
+	c := newTestContainer(t, dir, engineConfig, false)
+	system := &mount.System{Points: &mount.Points{}}
+
+	if err := c.addBindsMount(system); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, dst := range []string{"/ro", "/rw", "/empty", "/unknown", dir} {
+		readonly := dst == "/ro"
+		points := system.Points.GetByDest(dst)
+		if len(points) != 2 {
+			t.Errorf("expected a bind and a remount for %s, got %d mount points", dst, len(points))
+			continue
+		}
+		for _, p := range points {
+			isReadonly := false
+			for _, opt := range p.Options {
+				if opt == "ro" {
+					isReadonly = true
+				}
+			}
+			if isReadonly != readonly {
+				t.Errorf("unexpected read-only state %v for %s", isReadonly, dst)
+			}
+		}
+	}
+}
+
+func TestAddSchedulerMount(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "scheduler-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, enabled := range []bool{false, true} {
+		engineConfig := singularityConfig.NewConfig()
+		engineConfig.File.SchedulerIntegration = enabled
+		engineConfig.File.SchedulerBindPath = []string{
+			dir + ":/etc/slurm",
+			dir + ":/var/spool/slurm:ro",
+			dir + "::",
+			filepath.Join(dir, "missing") + ":/var/run/munge",
+		}
+
+		c := newTestContainer(t, dir, engineConfig, false)
+		system := &mount.System{Points: &mount.Points{}}
+
+		if err := c.addSchedulerMount(system); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		points := system.Points.GetByDest("/etc/slurm")
+		if enabled && (len(points) == 0 || points[0].Source != dir) {
+			t.Errorf("scheduler path not found in mount list")
+		} else if !enabled && len(points) != 0 {
+			t.Errorf("unexpected scheduler path in mount list with integration disabled")
+		}
+		if enabled {
+			points := system.Points.GetByDest("/var/spool/slurm")
+			if len(points) == 0 || points[0].Source != dir {
+				t.Errorf("scheduler path with mount option not found in mount list")
+			} else if !isReadOnly(points, "/var/spool/slurm") {
+				t.Errorf("scheduler path /var/spool/slurm is not read-only")
+			}
+			if len(system.Points.GetByDest(dir)) == 0 {
+				t.Errorf("scheduler path with empty destination not found in mount list")
+			}
+		}
+		if len(system.Points.GetByDest("/var/run/munge")) != 0 {
+			t.Errorf("missing scheduler path added to mount list")
+		}
+	}
+}
+
+func TestOverlayFsck(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	tests := []struct {
+		name    string
+		fsck    bool
+		entries []overlayEntry
+		checked string
+	}{
+		{"disabled", false, []overlayEntry{{image.EXT3, []image.Section{ext3Part}, true}}, ""},
+		{"writable ext3", true, []overlayEntry{{image.EXT3, []image.Section{ext3Part}, true}}, "/proc/self/fd/3"},
+		{"read-only ext3", true, []overlayEntry{{image.EXT3, []image.Section{ext3Part}, false}}, ""},
+		{"second writable ext3", true, []overlayEntry{
+			{image.EXT3, []image.Section{ext3Part}, true},
+			{image.EXT3, []image.Section{ext3Part}, true},
+		}, "/proc/self/fd/3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "fsck-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c, system := newOverlayContainer(t, dir, tt.entries)
+			c.engine.EngineConfig.File.OverlayFsck = tt.fsck
+
+			if err := c.addOverlayMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if c.fsckImage != tt.checked {
+				t.Errorf("got %q as checked image instead of %q", c.fsckImage, tt.checked)
+			}
+		})
+	}
+}
+
+func TestOverlaySync(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	tests := []struct {
+		name     string
+		policy   string
+		writable bool
+		options  []string
+	}{
+		{"default", "default", true, nil},
+		{"journal", "journal", true, []string{"data=journal"}},
+		{"writeback", "writeback", true, []string{"data=writeback"}},
+		{"sync", "sync", true, []string{"sync", "dirsync"}},
+		{"read-only journal", "journal", false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "sync-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c, system := newOverlayContainer(t, dir, []overlayEntry{{image.EXT3, []image.Section{ext3Part}, tt.writable}})
+			c.engine.EngineConfig.File.OverlaySync = tt.policy
+
+			if err := c.addOverlayMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			images := system.Points.GetAllImages()
+			if len(images) != 1 {
+				t.Fatalf("got %d images instead of 1", len(images))
+			}
+
+			var options []string
+			for _, option := range images[0].Options {
+				switch option {
+				case "data=journal", "data=writeback", "sync", "dirsync":
+					options = append(options, option)
+				}
+			}
+			if !reflect.DeepEqual(options, tt.options) {
+				t.Errorf("got sync options %v instead of %v", options, tt.options)
+			}
+		})
+	}
+}
+
+func TestRootfsNoatime(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	tests := []struct {
+		name     string
+		noatime  bool
+		writable bool
+		expected bool
+	}{
+		{"read-only", false, false, true},
+		{"writable", false, true, false},
+		{"writable noatime", true, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "noatime-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c, system := newOverlayContainer(t, dir, []overlayEntry{{image.EXT3, []image.Section{ext3Part}, tt.writable}})
+			c.engine.EngineConfig.File.RootfsNoatime = tt.noatime
+
+			if err := c.addOverlayMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			images := system.Points.GetAllImages()
+			if len(images) != 1 {
+				t.Fatalf("got %d images instead of 1", len(images))
+			}
+
+			noatime := false
+			for _, option := range images[0].Options {
+				if option == "noatime" {
+					noatime = true
+				}
+			}
+			if noatime != tt.expected {
+				t.Errorf("got noatime %v instead of %v", noatime, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freespace-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		min     uint
+		check   string
+		path    string
+		wantErr bool
+	}{
+		{"disabled", 0, "error", dir, false},
+		{"enough space", 1, "error", dir, false},
+		{"not enough space warn", 1 << 31, "warn", dir, false},
+		{"not enough space error", 1 << 31, "error", dir, true},
+		{"missing workdir", 1 << 31, "error", filepath.Join(dir, "missing"), false},
+	}
+
+	engineConfig := singularityConfig.NewConfig()
+	c := newContainer(&EngineOperations{EngineConfig: engineConfig}, nil, os.Getpid())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig.File.WorkdirMinFreeSpace = tt.min
+			engineConfig.File.WorkdirFreeSpaceCheck = tt.check
+
+			err := c.checkFreeSpace(tt.path)
+			if err != nil && !tt.wantErr {
+				t.Errorf("unexpected error: %s", err)
+			} else if err == nil && tt.wantErr {
+				t.Errorf("unexpected success")
+			}
+		})
+	}
+}
+
+func TestVerifyImageSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signature-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	keyring := filepath.Join(dir, "keyring")
+	f, err := os.Create(keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(f); err != nil {
+		t.Fatalf("failed to write keyring: %s", err)
+	}
+	f.Close()
+
+	unsigned := filepath.Join(dir, "unsigned.sif")
+	if err := ioutil.WriteFile(unsigned, []byte("not a SIF image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		require bool
+		keyring string
+		imgType int
+		wantErr bool
+	}{
+		{"not required", false, "", image.SQUASHFS, false},
+		{"not a SIF image", true, keyring, image.SQUASHFS, true},
+		{"no keyring", true, "", image.SIF, true},
+		{"missing keyring", true, filepath.Join(dir, "missing"), image.SIF, true},
+		{"unsigned image", true, keyring, image.SIF, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.RequireSignedContainers = tt.require
+			engineConfig.File.SignatureKeyring = tt.keyring
+
+			c := newContainer(&EngineOperations{EngineConfig: engineConfig}, nil, os.Getpid())
+			img := &image.Image{Path: unsigned, Source: unsigned, Type: tt.imgType}
+
+			err := c.verifyImageSignature(img)
+			if err != nil && !tt.wantErr {
+				t.Errorf("unexpected error: %s", err)
+			} else if err == nil && tt.wantErr {
+				t.Errorf("unexpected success")
+			}
+		})
+	}
+}
+
+func TestCheckImageArch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binfmt-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	binfmtMiscPath = dir
+	defer func() { binfmtMiscPath = "/proc/sys/fs/binfmt_misc" }()
+
+	foreign := "s390x"
+	if runtime.GOARCH == foreign {
+		foreign = "amd64"
+	}
+
+	if err := checkImageArch(&image.Image{}); err != nil {
+		t.Errorf("unexpected error for unknown architecture: %s", err)
+	}
+	if err := checkImageArch(&image.Image{Architecture: runtime.GOARCH}); err != nil {
+		t.Errorf("unexpected error for host architecture: %s", err)
+	}
+	if err := checkImageArch(&image.Image{Architecture: foreign}); err == nil {
+		t.Errorf("unexpected success for %s architecture without emulation", foreign)
+	}
+
+	entry := filepath.Join(dir, "qemu-"+qemuArch[foreign])
+	if err := ioutil.WriteFile(entry, []byte("disabled\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkImageArch(&image.Image{Architecture: foreign}); err == nil {
+		t.Errorf("unexpected success for %s architecture with disabled emulation", foreign)
+	}
+
+	if err := ioutil.WriteFile(entry, []byte("enabled\ninterpreter /usr/bin/qemu\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkImageArch(&image.Image{Architecture: foreign}); err != nil {
+		t.Errorf("unexpected error for %s architecture with emulation: %s", foreign, err)
+	}
+}
+
+func TestNvCCLIFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      []string
+		expected []string
+	}{
+		{"default", nil, []string{"--no-cgroups", "--device=all", "--compute", "--utility"}},
+		{"devices", []string{"NVIDIA_VISIBLE_DEVICES=0,1"}, []string{"--no-cgroups", "--device=0,1", "--compute", "--utility"}},
+		{"no device", []string{"NVIDIA_VISIBLE_DEVICES=none"}, []string{"--no-cgroups", "--compute", "--utility"}},
+		{"capabilities", []string{"NVIDIA_DRIVER_CAPABILITIES=video,unknown"}, []string{"--no-cgroups", "--device=all", "--video"}},
+		{"all capabilities", []string{"NVIDIA_DRIVER_CAPABILITIES=all"}, []string{"--no-cgroups", "--device=all", "--compute", "--compat32", "--display", "--graphics", "--utility", "--video"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if flags := nvCCLIFlags(tt.env); !reflect.DeepEqual(flags, tt.expected) {
+				t.Errorf("got flags %v instead of %v", flags, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUseDevProcSymlinks(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string
+		mountProc bool
+		binds     []string
+		expected  bool
+	}{
+		{"yes without proc", "yes", false, nil, true},
+		{"no with proc", "no", true, nil, false},
+		{"auto with proc", "auto", true, nil, true},
+		{"auto without proc", "auto", false, nil, false},
+		{"auto with proc bind", "auto", false, []string{"/opt", "/proc/:/proc/"}, true},
+		{"auto with other bind", "auto", false, []string{"/proc:/hostproc"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if use := useDevProcSymlinks(tt.policy, tt.mountProc, tt.binds); use != tt.expected {
+				t.Errorf("got %v instead of %v", use, tt.expected)
+			}
+		})
+	}
+}
+
+func TestJobCgroupPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+		fail     bool
+	}{
+		{"memory", "4:memory:/slurm/uid_1000/job_42/step_0\n3:cpu,cpuacct:/slurm\n", "/slurm/uid_1000/job_42/step_0", false},
+		{"cpu", "3:cpu,cpuacct:/pbspro.service/jobid/7\n1:name=systemd:/user.slice\n", "/pbspro.service/jobid/7", false},
+		{"hybrid", "4:memory:/slurm/job_42\n0::/slurm/job_42\n", "/slurm/job_42", false},
+		{"root cgroup", "4:memory:/\n3:cpu,cpuacct:/\n", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "cgroup-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			if _, err := f.WriteString(tt.content); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			path, err := jobCgroupPath(f.Name())
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if path != tt.expected {
+				t.Errorf("got %s instead of %s", path, tt.expected)
+			}
+		})
+	}
+
+	// cgroup v2 is reported to be skipped with a warning
+	f, err := ioutil.TempFile("", "cgroup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("0::/user.slice\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if _, err := jobCgroupPath(f.Name()); err != errUnifiedCgroup {
+		t.Errorf("got error %v instead of %v for unified hierarchy", err, errUnifiedCgroup)
+	}
+}
+
+func TestProcReadonly(t *testing.T) {
+	tests := []struct {
+		policy   string
+		uid      int
+		readonly bool
+	}{
+		{"yes", 0, true},
+		{"yes", 1000, true},
+		{"no", 0, false},
+		{"no", 1000, false},
+		{"unprivileged", 0, false},
+		{"unprivileged", 1000, true},
+	}
+
+	for _, tt := range tests {
+		if readonly := procReadonly(tt.policy, tt.uid); readonly != tt.readonly {
+			t.Errorf("got %v for policy %s and uid %d instead of %v", readonly, tt.policy, tt.uid, tt.readonly)
+		}
+	}
+}
+
+func TestOverlaySubdir(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	tests := []struct {
+		name   string
+		subdir string
+		upper  string
+		fail   bool
+	}{
+		{"no subdir", "", "upper", false},
+		{"current dir", ".", "upper", false},
+		{"subdir", "c1", "c1/upper", false},
+		{"nested subdir", "a/../c1/c2/", "c1/c2/upper", false},
+		{"absolute path", "/c1", "", true},
+		{"escaping path", "c1/../../c2", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "overlay-subdir-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c, system := newOverlayContainer(t, dir, []overlayEntry{{image.EXT3, []image.Section{ext3Part}, true}})
+			c.engine.EngineConfig.SetOverlaySubdir(tt.subdir)
+
+			err = c.addOverlayMount(system)
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success with subdirectory %s", tt.subdir)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			upper := c.session.Layer.(*overlay.Overlay).GetUpperDir()
+			if upper != filepath.Join(c.overlayRoot, tt.upper) {
+				t.Errorf("got %s as upper directory instead of %s", upper, filepath.Join(c.overlayRoot, tt.upper))
+			}
+		})
+	}
+}
+
+func TestOverlayStrict(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "overlay-strict-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name          string
+		enableOverlay string
+		strict        bool
+		writableTmpfs bool
+		imageTmpfs    bool
+		fail          bool
+	}{
+		{"try", "try", false, false, false, false},
+		{"try strict", "try", true, false, false, false},
+		{"yes", "yes", false, false, false, false},
+		{"yes strict", "yes", true, false, false, true},
+		{"try writable tmpfs", "try", false, true, false, true},
+		{"try image writable tmpfs", "try", false, true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.EnableOverlay = tt.enableOverlay
+			engineConfig.File.OverlayStrict = tt.strict
+			engineConfig.File.EnableUnderlay = false
+			engineConfig.SetWritableTmpfs(tt.writableTmpfs)
+			engineConfig.SetImageWritableTmpfs(tt.imageTmpfs)
+			engineConfig.SetImageList([]image.Image{{Path: dir, Type: image.SANDBOX}})
+
+			c := newTestContainer(t, dir, engineConfig, false)
+			system := &mount.System{Points: &mount.Points{}}
+
+			err = c.setupSessionLayout(system)
+			if tt.fail && err == nil {
+				t.Errorf("unexpected success without overlay support")
+			} else if !tt.fail && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestSessionSize(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	tests := []struct {
+		name          string
+		writableTmpfs bool
+		expected      int
+	}{
+		{"unbounded", false, 0},
+		{"writable tmpfs", true, 16},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.SessiondirMaxSize = 16
+			engineConfig.SetWritableTmpfs(tt.writableTmpfs)
+
+			c := newContainer(&EngineOperations{EngineConfig: engineConfig}, nil, os.Getpid())
+			if c.sessionSize != tt.expected {
+				t.Errorf("got session size %d instead of %d", c.sessionSize, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAddRemoteOverlayMount(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	tests := []struct {
+		name   string
+		driver string
+		url    string
+		fail   bool
+	}{
+		{"no driver", "", "https://localhost/image.sqfs", true},
+		{"relative driver", "httpfs2", "https://localhost/image.sqfs", true},
+		{"no image name", "/usr/bin/httpfs2", "https://localhost/images/", true},
+		{"remote image", "/usr/bin/httpfs2", "https://localhost/image.sqfs", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "remote-overlay-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c, system := newOverlayContainer(t, dir, nil)
+			c.engine.EngineConfig.File.RemoteOverlayDriver = tt.driver
+			c.engine.EngineConfig.SetRemoteOverlay([]string{tt.url})
+
+			err = c.addOverlayMount(system)
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			dest, err := c.session.GetPath("/overlay-images/remote-0")
+			if err != nil {
+				t.Fatalf("remote overlay session directory not found: %s", err)
+			}
+			if len(system.Points.GetByDest(dest)) == 0 {
+				t.Errorf("remote overlay %s not found in mount list", dest)
+			}
+		})
+	}
+}
+
+func TestCheckSysctl(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	tests := []struct {
+		key   string
+		netNS bool
+		ipcNS bool
+		fail  bool
+	}{
+		{"net.ipv4.ip_local_port_range", true, false, false},
+		{"net.ipv4.ip_local_port_range", false, false, true},
+		{"kernel.shmmax", false, true, false},
+		{"kernel.shmmax", true, false, true},
+		{"fs.mqueue.msg_max", false, true, false},
+		{"kernel.hostname", true, true, true},
+		{"vm.swappiness", true, true, true},
+	}
+
+	for _, tt := range tests {
+		c := &container{netNS: tt.netNS, ipcNS: tt.ipcNS}
+
+		err := c.checkSysctl(tt.key)
+		if tt.fail && err == nil {
+			t.Errorf("unexpected success for %s (net: %v, ipc: %v)", tt.key, tt.netNS, tt.ipcNS)
+		} else if !tt.fail && err != nil {
+			t.Errorf("unexpected error for %s: %s", tt.key, err)
+		}
+	}
+}
+
+func TestSizeToBytes(t *testing.T) {
+	tests := []struct {
+		size  string
+		bytes uint64
+		fail  bool
+	}{
+		{"1024", 1024, false},
+		{"1k", 1024, false},
+		{"2M", 2 << 20, false},
+		{"10G", 10 << 30, false},
+		{"50%", 0, true},
+		{"G", 0, true},
+	}
+
+	for _, tt := range tests {
+		bytes, err := sizeToBytes(tt.size)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("unexpected success with %q", tt.size)
+			}
+		} else if err != nil {
+			t.Errorf("unexpected error with %q: %s", tt.size, err)
+		} else if bytes != tt.bytes {
+			t.Errorf("got %d bytes for %q instead of %d", bytes, tt.size, tt.bytes)
+		}
+	}
+}
+
+func TestMtabContent(t *testing.T) {
+	mounts := []byte(`/dev/sda1 / ext4 rw,relatime 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/loop0 /var/session/final squashfs ro,nosuid,nodev,relatime 0 0
+proc /var/session/final/proc proc rw,nosuid,nodev,relatime 0 0
+tmpfs /var/session/final-other tmpfs rw 0 0
+/dev/sda1 /var/session/final/home/user ext4 rw,nosuid,nodev,relatime 0 0
+`)
+	expected := `/dev/loop0 / squashfs ro,nosuid,nodev,relatime 0 0
+proc /proc proc rw,nosuid,nodev,relatime 0 0
+/dev/sda1 /home/user ext4 rw,nosuid,nodev,relatime 0 0
+`
+
+	if content := string(mtabContent(mounts, "/var/session/final")); content != expected {
+		t.Errorf("unexpected mtab content:\n%s", content)
+	}
+}
+
+func TestSysReadonly(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "sys-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, readonly := range []bool{false, true} {
+		engineConfig := singularityConfig.NewConfig()
+		engineConfig.File.MountSys = true
+		engineConfig.File.MountSysReadonly = readonly
+		engineConfig.File.SysWritablePath = []string{"/sys/kernel", "/sys/non-existent", "/proc/sys"}
+
+		c := newTestContainer(t, dir, engineConfig, false)
+		system := &mount.System{Points: &mount.Points{}}
+
+		if err := c.addKernelMount(system); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		sys := system.Points.GetByDest("/sys")
+		if len(sys) == 0 {
+			t.Fatalf("/sys not found in mount list")
+		}
+		if flagsOf(sys[0])&syscall.MS_RDONLY != 0 != readonly {
+			t.Errorf("unexpected /sys read-only state with 'mount sys readonly = %v'", readonly)
+		}
+
+		writable := system.Points.GetByDest("/sys/kernel")
+		if readonly && (len(writable) == 0 || flagsOf(writable[0])&syscall.MS_RDONLY != 0) {
+			t.Errorf("/sys/kernel is not bound read-write")
+		} else if !readonly && len(writable) != 0 {
+			t.Errorf("unexpected /sys/kernel bind with writable /sys")
+		}
+		if len(system.Points.GetByDest("/sys/non-existent")) != 0 || len(system.Points.GetByDest("/proc/sys")) != 0 {
+			t.Errorf("unexpected writable path in mount list")
+		}
+	}
+}
+
+func TestAddHostnameMount(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "hostname-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name       string
+		utsNS      bool
+		config     bool
+		imageFile  bool
+		expectBind bool
+	}{
+		{"no uts namespace", false, true, false, false},
+		{"disabled by config", true, false, false, false},
+		{"missing image file", true, true, false, true},
+		{"image file", true, true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.ConfigHostname = tt.config
+
+			c := newTestContainer(t, dir, engineConfig, false)
+			c.utsNS = tt.utsNS
+			system := newTestSession(t, c)
+			if err := c.session.Create(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.imageFile {
+				etc := filepath.Join(c.session.RootFsPath(), "etc")
+				if err := os.MkdirAll(etc, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(etc, "hostname"), []byte("image\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := c.addHostnameMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			// only run mount hooks
+			if err := system.MountAll(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			bound := len(system.Points.GetByDest("/etc/hostname")) > 0
+			if bound != tt.expectBind {
+				t.Errorf("unexpected /etc/hostname bind state: %v", bound)
+			}
+		})
+	}
+}
+
+func TestAddMounted(t *testing.T) {
+	c := &container{}
+
+	points := []struct {
+		tag   mount.AuthorizedTag
+		point mount.Point
+	}{
+		{
+			tag: mount.RootfsTag,
+			point: mount.Point{
+				Mount: specs.Mount{
+					Source:      "/image.sif",
+					Destination: "/rootfs",
+					Type:        "squashfs",
+					Options:     []string{"nosuid", "nodev", "ro"},
+				},
+			},
+		},
+		{
+			tag: mount.BindsTag,
+			point: mount.Point{
+				Mount: specs.Mount{
+					Source:      "/data",
+					Destination: "/data",
+					Options:     []string{"rbind", "nosuid", "nodev"},
+				},
+			},
+		},
+		{
+			tag: mount.BindsTag,
+			point: mount.Point{
+				Mount: specs.Mount{
+					Destination: "/data",
+					Options:     []string{"remount", "bind", "nosuid", "nodev", "ro"},
+				},
+			},
+		},
+		{
+			tag: mount.BindsTag,
+			point: mount.Point{
+				Mount: specs.Mount{
+					Destination: "/data",
+					Options:     []string{"slave"},
+				},
+			},
+		},
+	}
+
+	for _, p := range points {
+		c.addMounted(p.tag, &p.point)
+	}
+
+	expected := []mountedPoint{
+		{tag: mount.RootfsTag, source: "/image.sif", dest: "/rootfs", fstype: "squashfs", readonly: true},
+		{tag: mount.BindsTag, source: "/data", dest: "/data", fstype: "bind", readonly: true},
+	}
+	if !reflect.DeepEqual(c.mounted, expected) {
+		t.Errorf("got %+v instead of %+v", c.mounted, expected)
+	}
+}
+
+func TestHostOptPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		child    string
+		allowed  []string
+		expected []string
+	}{
+		{"no restriction", "/opt", nil, []string{"/opt"}},
+		{"allowed file system", "/opt/software/apps", []string{"/opt/software"}, []string{"/opt/software/apps"}},
+		{"allowed exact file system", "/opt/software", []string{"/opt/software/"}, []string{"/opt/software"}},
+		{"not allowed file system", "/opt/other", []string{"/opt/software"}, []string{}},
+		{"prefix only", "/opt/softwares", []string{"/opt/software"}, []string{}},
+		{"missing subpath", "/opt", []string{"/opt/non-existent-subpath"}, []string{}},
+		{"not under opt", "/opt", []string{"/usr/local"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.HostfsOptPath = tt.allowed
+
+			c := &container{engine: &EngineOperations{EngineConfig: engineConfig}}
+
+			paths := c.hostOptPaths(tt.child)
+			if !reflect.DeepEqual(paths, tt.expected) {
+				t.Errorf("got %v instead of %v", paths, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAddOciDevices(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "devices-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		device  specs.LinuxDevice
+		bound   bool
+		wantErr bool
+	}{
+		{"matching host device", specs.LinuxDevice{Path: "/dev/null", Type: "c", Major: 1, Minor: 3}, true, false},
+		{"no matching host device", specs.LinuxDevice{Path: "/dev/null", Type: "c", Major: 1, Minor: 5}, false, false},
+		{"missing host device", specs.LinuxDevice{Path: "/dev/non-existent", Type: "c", Major: 1, Minor: 3}, false, false},
+		{"not under /dev", specs.LinuxDevice{Path: "/etc/null", Type: "c", Major: 1, Minor: 3}, false, true},
+		{"unknown type", specs.LinuxDevice{Path: "/dev/null", Type: "x", Major: 1, Minor: 3}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.OciConfig.Linux = &specs.Linux{Devices: []specs.LinuxDevice{tt.device}}
+
+			c := newTestContainer(t, dir, engineConfig, false)
+			c.userNS = true
+			system := newTestSession(t, c)
+
+			err = c.addOciDevices(system)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			bound := false
+			for _, point := range system.Points.GetByTag(mount.DevTag) {
+				if point.Source == tt.device.Path {
+					bound = true
+				}
+			}
+			if bound != tt.bound {
+				t.Errorf("device %s bound: %v, expected %v", tt.device.Path, bound, tt.bound)
+			}
+		})
+	}
+}
+
+func TestCheckReadonly(t *testing.T) {
+	mountinfo, err := ioutil.TempFile("", "mountinfo-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(mountinfo.Name())
+
+	data := `30 28 0:27 / /session/final/data rw,nosuid,nodev shared:9 - ext4 /dev/sda1 rw
+31 30 0:27 / /session/final/data ro,nosuid,nodev shared:9 - ext4 /dev/sda1 rw
+32 28 0:27 / /session/final/opt rw,nosuid,nodev shared:9 - ext4 /dev/sda1 rw
+`
+	if _, err := mountinfo.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	mountinfo.Close()
+
+	tests := []struct {
+		name  string
+		dest  string
+		check string
+		fail  bool
+	}{
+		{"read-only", "/session/final/data", "error", false},
+		{"writable", "/session/final/opt", "error", true},
+		{"writable warning", "/session/final/opt", "warn", false},
+		{"writable no check", "/session/final/opt", "no", false},
+		{"not mounted", "/session/final/srv", "error", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.ReadonlyBindCheck = tt.check
+
+			c := &container{
+				engine:        &EngineOperations{EngineConfig: engineConfig},
+				mountInfoPath: mountinfo.Name(),
+			}
+
+			err := c.checkReadonly(tt.dest)
+			if tt.fail && err == nil {
+				t.Errorf("unexpected success")
+			} else if !tt.fail && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestAddCACertificatesMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certs := filepath.Join(dir, "certs")
+	if err := os.Mkdir(certs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	defaultPaths := caCertificatesPaths
+	defer func() { caCertificatesPaths = defaultPaths }()
+
+	tests := []struct {
+		name    string
+		enabled bool
+		paths   []string
+		bound   []string
+	}{
+		{"disabled", false, []string{certs}, nil},
+		{"enabled", true, []string{missing, certs}, []string{certs}},
+		{"not found", true, []string{missing}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caCertificatesPaths = tt.paths
+
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.ConfigCACertificates = tt.enabled
+
+			c := &container{engine: &EngineOperations{EngineConfig: engineConfig}}
+			system := &mount.System{Points: &mount.Points{}}
+
+			if err := c.addCACertificatesMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var bound []string
+			points := system.Points.GetByTag(mount.FilesTag)
+			for _, p := range points {
+				if flagsOf(p)&syscall.MS_REMOUNT == 0 {
+					bound = append(bound, p.Destination)
+				}
+			}
+			if !reflect.DeepEqual(bound, tt.bound) {
+				t.Errorf("got %v bound instead of %v", bound, tt.bound)
+			}
+			for _, dest := range bound {
+				if !isReadOnly(points, dest) {
+					t.Errorf("%s is not bound read-only", dest)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckRootfsFstype(t *testing.T) {
+	if err := checkRootfsFstype("xfs"); err == nil {
+		t.Errorf("unexpected success with unauthorized xfs file system type")
+	}
+
+	for _, fstype := range []string{"ext2", "ext3", "ext4", "squashfs"} {
+		has, err := proc.HasFilesystem(fstype)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkRootfsFstype(fstype)
+		if has && err != nil {
+			t.Errorf("unexpected error for %s: %s", fstype, err)
+		} else if !has && err == nil {
+			t.Errorf("unexpected success for %s not supported by kernel", fstype)
+		}
+	}
+}
+
+func TestMountFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   []string
+		suidFlag  uintptr
+		allowSUID bool
+		tag       mount.AuthorizedTag
+		flags     uintptr
+		fail      bool
+	}{
+		{"default", nil, syscall.MS_NOSUID, true, mount.BindsTag, syscall.MS_NOSUID | syscall.MS_NODEV, false},
+		{"default suid allowed", nil, 0, true, mount.BindsTag, syscall.MS_NODEV, false},
+		{"default nosuid kept", nil, 0, false, mount.FilesTag, syscall.MS_NOSUID | syscall.MS_NODEV, false},
+		{"drop nodev", []string{"binds:nosuid"}, syscall.MS_NOSUID, true, mount.BindsTag, syscall.MS_NOSUID, false},
+		{"other tag untouched", []string{"binds:nosuid"}, syscall.MS_NOSUID, true, mount.HomeTag, syscall.MS_NOSUID | syscall.MS_NODEV, false},
+		{"nosuid enforced", []string{"home:nodev noexec"}, syscall.MS_NOSUID, false, mount.HomeTag, syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, false},
+		{"nosuid dropped", []string{"files:nodev"}, 0, false, mount.FilesTag, syscall.MS_NODEV, false},
+		{"layer unprivileged", nil, syscall.MS_NOSUID, true, mount.LayerTag, syscall.MS_NOSUID | syscall.MS_NODEV, false},
+		{"layer trusted", []string{"layer:nosuid"}, 0, true, mount.LayerTag, 0, false},
+		{"bad entry", []string{"binds"}, 0, false, mount.BindsTag, 0, true},
+		{"bad tag", []string{"dev:nosuid"}, 0, false, mount.DevTag, 0, true},
+		{"bad flag", []string{"binds:ro"}, 0, false, mount.BindsTag, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tagFlags, err := parseMountFlags(tt.entries)
+			if err != nil && !tt.fail {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.fail {
+				t.Fatalf("unexpected success")
+			} else if tt.fail {
+				return
+			}
+
+			c := &container{tagFlags: tagFlags, suidFlag: tt.suidFlag}
+			if flags := c.mountFlags(tt.tag, tt.allowSUID); flags != tt.flags {
+				t.Errorf("got flags %#x instead of %#x", flags, tt.flags)
+			}
+		})
+	}
+}
+
+func TestAddDevLogMount(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "devlog-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "dev-log")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	link := filepath.Join(dir, "log")
+	if err := os.Symlink(socket, link); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultPaths := devLogPaths
+	defer func() { devLogPaths = defaultPaths }()
+
+	tests := []struct {
+		name    string
+		enabled bool
+		paths   []string
+		source  string
+	}{
+		{"disabled", false, []string{socket}, ""},
+		{"socket", true, []string{socket}, socket},
+		{"symlink to socket", true, []string{link}, socket},
+		{"not a socket", true, []string{file}, ""},
+		{"fallback", true, []string{filepath.Join(dir, "missing"), socket}, socket},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devLogPaths = tt.paths
+
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.MountDevLog = tt.enabled
+
+			c := newTestContainer(t, dir, engineConfig, false)
+			system := newTestSession(t, c)
+
+			if err := c.addDevLogMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			source := ""
+			for _, point := range system.Points.GetByTag(mount.DevTag) {
+				if strings.HasSuffix(point.Destination, "/dev/log") {
+					source = point.Source
+				}
+			}
+			if source != tt.source {
+				t.Errorf("got /dev/log source %q instead of %q", source, tt.source)
+			}
+		})
+	}
+}
+
+func TestExtFstype(t *testing.T) {
+	mke2fs, err := exec.LookPath("mke2fs")
+	if err != nil {
+		t.Skip("mke2fs not available, skipping the test")
+	}
+
+	dir, err := ioutil.TempDir("", "extfstype-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, fstype := range []string{"ext3", "ext4"} {
+		path := filepath.Join(dir, fstype+".img")
+		if err := ioutil.WriteFile(path, make([]byte, 8*1024*1024), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command(mke2fs, "-q", "-F", "-t", fstype, path).CombinedOutput(); err != nil {
+			t.Fatalf("failed to create %s image: %s: %s", fstype, err, out)
+		}
+
+		img := &image.Image{Path: path, Source: path}
+		if got := extFstype(img, image.Section{}); got != fstype {
+			t.Errorf("got %s file system type for %s image", got, fstype)
+		}
+	}
+
+	img := &image.Image{Path: dir, Source: filepath.Join(dir, "missing")}
+	if got := extFstype(img, image.Section{}); got != "ext3" {
+		t.Errorf("got %s file system type for missing image instead of ext3", got)
+	}
+}
+
+func TestCheckBindDest(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "binddest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	engineConfig := singularityConfig.NewConfig()
+	engineConfig.File.UserBindDenyDest = []string{"/etc", "/bin/"}
+
+	c := newTestContainer(t, dir, engineConfig, false)
+
+	tests := []struct {
+		dst     string
+		allowed bool
+	}{
+		{"/etc", false},
+		{"/etc/passwd", false},
+		{"/bin", false},
+		{"/usr/../etc/shadow", false},
+		{"/etcd", true},
+		{"/opt/etc", true},
+		{"/mnt", true},
+	}
+	for _, tt := range tests {
+		err := c.checkBindDest(tt.dst)
+		if tt.allowed && err != nil {
+			t.Errorf("unexpected error for %s: %s", tt.dst, err)
+		} else if !tt.allowed && err == nil {
+			t.Errorf("unexpected success for %s", tt.dst)
+		}
+	}
+
+	system := &mount.System{Points: &mount.Points{}}
+	c.session, err = layout.NewSession(c.sessionPath, c.sessionFsType, 0, 0, system, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootfs := c.session.RootFsPath()
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(rootfs, "config")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := system.Points.AddBind(mount.UserbindsTag, "/tmp", "/mnt", syscall.MS_BIND); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.checkResolvedBindDest(system); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := system.Points.AddBind(mount.UserbindsTag, "/tmp", "/config/hosts", syscall.MS_BIND); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.checkResolvedBindDest(system); err == nil {
+		t.Errorf("unexpected success with destination resolved under /etc")
+	}
+}
+
+func TestAddCwdMount(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "cwd-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cwd, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(pwd)
+
+	tests := []struct {
+		name  string
+		skip  []string
+		bound bool
+	}{
+		{"not skipped", []string{"/", "/opt"}, true},
+		{"skipped", []string{"/", cwd + "/"}, false},
+		{"empty list", []string{""}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.UserBindControl = true
+			engineConfig.File.CwdSkipPath = tt.skip
+			engineConfig.OciConfig.Process = &specs.Process{Cwd: cwd}
+
+			c := newTestContainer(t, dir, engineConfig, false)
+			system := &mount.System{Points: &mount.Points{}}
+
+			if err := c.addCwdMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if bound := len(system.Points.GetByTag(mount.CwdTag)) > 0; bound != tt.bound {
+				t.Errorf("current directory bound: %v, expected %v", bound, tt.bound)
+			}
+		})
+	}
+}
+
+func TestDevShmOwnerOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		userNS   bool
+		fakeroot bool
+		options  string
+	}{
+		{"no user namespace", false, false, ""},
+		{"user namespace", true, false, fmt.Sprintf(",uid=%d,gid=%d", os.Getuid(), os.Getgid())},
+		{"fakeroot", true, true, ",uid=0,gid=0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.SetFakeroot(tt.fakeroot)
+
+			c := &container{
+				engine: &EngineOperations{EngineConfig: engineConfig},
+				userNS: tt.userNS,
+			}
+			if options := c.devShmOwnerOptions(); options != tt.options {
+				t.Errorf("got options %q, expected %q", options, tt.options)
+			}
+		})
+	}
+}
+
+func TestSquashfsOverlayLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overlay-layers-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a.sqfs")
+	b := filepath.Join(dir, "b.sqfs")
+	link := filepath.Join(dir, "link.sqfs")
+	for _, p := range []string{a, b} {
+		if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link(a, link); err != nil {
+		t.Fatal(err)
+	}
+
+	squashfs := func(path string) image.Image {
+		return image.Image{
+			Path:       path,
+			Type:       image.SQUASHFS,
+			Partitions: []image.Section{{Type: image.SQUASHFS}},
+		}
+	}
+	images := []image.Image{
+		squashfs(a),
+		squashfs(b),
+		{Path: dir, Type: image.SANDBOX},
+		squashfs(link),
+	}
+
+	topmost := squashfsOverlayLayers(images)
+	if len(topmost) != 2 {
+		t.Fatalf("got %d distinct layers instead of 2", len(topmost))
+	}
+
+	for i, expected := range []int{3, 1, -1, 3} {
+		part, _ := overlayPartition(&images[i])
+		if part == nil {
+			continue
+		}
+		key, ok := overlayLayerKey(&images[i], part)
+		if !ok {
+			t.Fatalf("no key for %s", images[i].Path)
+		}
+		if topmost[key] != expected {
+			t.Errorf("%s topmost position is %d instead of %d", images[i].Path, topmost[key], expected)
+		}
+	}
+}
+
+func TestAddSysClassNetMount(t *testing.T) {
+	if _, err := os.Stat(sysClassNet); err != nil {
+		t.Skipf("%s not available", sysClassNet)
+	}
+
+	tests := []struct {
+		name    string
+		enabled bool
+		netNS   bool
+		bound   bool
+	}{
+		{"disabled", false, false, false},
+		{"enabled", true, false, true},
+		{"network namespace", true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.MountSysClassNet = tt.enabled
+
+			c := &container{
+				engine: &EngineOperations{EngineConfig: engineConfig},
+				netNS:  tt.netNS,
+			}
+			system := &mount.System{Points: &mount.Points{}}
+
+			if err := c.addSysClassNetMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			bound := false
+			for _, p := range system.Points.GetByTag(mount.KernelTag) {
+				if p.Destination == sysClassNet {
+					bound = true
+				} else if !strings.HasPrefix(p.Destination, "/sys/") {
+					t.Errorf("unexpected mount point %s", p.Destination)
+				}
+			}
+			if bound != tt.bound {
+				t.Errorf("%s bound: %v, expected %v", sysClassNet, bound, tt.bound)
+			}
+		})
+	}
+}
+
+func TestLockOverlayImage(t *testing.T) {
+	f, err := ioutil.TempFile("", "overlay-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	tests := []struct {
+		name     string
+		policy   string
+		locked   bool
+		writable bool
+		fail     bool
+	}{
+		{"not locked", "fail", false, true, false},
+		{"fail", "fail", true, false, true},
+		{"readonly", "readonly", true, false, false},
+		{"wait", "wait", true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// another container holding the image lock
+			other, err := os.Open(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer other.Close()
+			if tt.locked {
+				if err := syscall.Flock(int(other.Fd()), syscall.LOCK_EX); err != nil {
+					t.Fatal(err)
+				}
+				if tt.policy == "wait" {
+					go func() {
+						time.Sleep(50 * time.Millisecond)
+						syscall.Flock(int(other.Fd()), syscall.LOCK_UN)
+					}()
+				}
+			}
+
+			img, err := os.Open(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer img.Close()
+
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.OverlayLockPolicy = tt.policy
+			c := &container{engine: &EngineOperations{EngineConfig: engineConfig}}
+
+			writable, err := c.lockOverlayImage(&image.Image{Path: f.Name(), Fd: img.Fd()})
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if writable != tt.writable {
+				t.Errorf("got writable %v, expected %v", writable, tt.writable)
+			}
+		})
+	}
+}
+
+func TestAddDevicesCgroup(t *testing.T) {
+	engineConfig := singularityConfig.NewConfig()
+	c := &container{engine: &EngineOperations{EngineConfig: engineConfig}}
+
+	userRule := specs.LinuxDeviceCgroup{Allow: true, Type: "c", Access: "r"}
+	resources := &specs.LinuxResources{Devices: []specs.LinuxDeviceCgroup{userRule}}
+
+	// host /dev mounted, nothing is restricted
+	c.addDevicesCgroup(resources)
+	if len(resources.Devices) != 1 {
+		t.Fatalf("unexpected device rules %v", resources.Devices)
+	}
+
+	c.allowDevice("/dev/null")
+	c.allowDevice("/dev")
+	c.allowDeviceNode(syscall.S_IFCHR, 136, -1)
+	c.addDevicesCgroup(resources)
+
+	devices := resources.Devices
+	if len(devices) != 4 {
+		t.Fatalf("got %d device rules instead of 4: %v", len(devices), devices)
+	}
+	if devices[0].Allow || devices[0].Access != "rwm" {
+		t.Errorf("first rule doesn't deny all devices: %v", devices[0])
+	}
+	if devices[1].Type != "c" || *devices[1].Major != 1 || *devices[1].Minor != 3 {
+		t.Errorf("unexpected /dev/null rule: %v", devices[1])
+	}
+	if *devices[2].Major != 136 || devices[2].Minor != nil {
+		t.Errorf("unexpected pts rule: %v", devices[2])
+	}
+	if !reflect.DeepEqual(devices[3], userRule) {
+		t.Errorf("configuration rule not applied last: %v", devices[3])
+	}
+	if engineConfig.OciConfig.Linux == nil || engineConfig.OciConfig.Linux.Resources != resources {
+		t.Errorf("OCI resources not populated")
+	}
+}
+
+func TestUserbindsDevicesCgroup(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "devices-cgroup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		bind     string
+		major    int64
+		minor    int64
+		restrict bool
+	}{
+		{"device node", "/dev/null", 1, 3, true},
+		{"device directory", "/dev/pts", 5, 2, true},
+		{"host /dev", "/dev", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.SetContain(true)
+			engineConfig.SetBindPath([]string{tt.bind})
+
+			c := newTestContainer(t, dir, engineConfig, false)
+			system := newTestSession(t, c)
+			if err := c.session.AddDir("/dev"); err != nil {
+				t.Fatal(err)
+			}
+			// staged /dev with /dev/zero already allowed
+			c.allowDevice("/dev/zero")
+
+			if err := c.addUserbindsMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			resources := &specs.LinuxResources{}
+			c.addDevicesCgroup(resources)
+			if !tt.restrict {
+				if len(resources.Devices) != 0 {
+					t.Errorf("unexpected device rules with host /dev: %v", resources.Devices)
+				}
+				return
+			}
+
+			found := false
+			for _, d := range resources.Devices {
+				if d.Allow && d.Type == "c" && d.Major != nil && *d.Major == tt.major && d.Minor != nil && *d.Minor == tt.minor {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("no rule allowing bound device %d:%d: %v", tt.major, tt.minor, resources.Devices)
+			}
+		})
+	}
+}
+
+func TestAddHomeMountTemporary(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "home-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name      string
+		source    string
+		temporary bool
+		fail      bool
+	}{
+		{"existing home", dir, true, false},
+		{"temporary home", missing, true, false},
+		{"temporary home disabled", missing, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.UserBindControl = true
+			engineConfig.File.TemporaryHome = tt.temporary
+			engineConfig.SetCustomHome(true)
+			engineConfig.SetHomeSource(tt.source)
+			engineConfig.SetHomeDest("/home/test")
+
+			c := newTestContainer(t, dir, engineConfig, false)
+			system := newTestSession(t, c)
+
+			err = c.addHomeMount(system)
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			bound := len(system.Points.GetBySource(tt.source)) > 0
+			if bound != (tt.source != missing) {
+				t.Errorf("home source %s bound: %v", tt.source, bound)
+			}
+			if _, err := c.session.GetPath("/home/test"); err != nil {
+				t.Errorf("home directory not staged: %s", err)
+			}
+		})
+	}
+}
+
+func TestParseOverlayMounts(t *testing.T) {
+	tests := []struct {
+		name    string
+		root    string
+		entries []string
+		mounts  []overlayMount
+		fail    bool
+	}{
+		{
+			name:    "none",
+			entries: nil,
+			mounts:  []overlayMount{},
+		},
+		{
+			name:    "multiple",
+			entries: []string{"data:/data", "cache/a:/var/cache/"},
+			mounts:  []overlayMount{{subdir: "data", dest: "/data"}, {subdir: "cache/a", dest: "/var/cache"}},
+		},
+		{
+			name:    "root subdir",
+			root:    "rootfs",
+			entries: []string{"data:/data"},
+			mounts:  []overlayMount{{subdir: "data", dest: "/data"}},
+		},
+		{name: "bad format", entries: []string{"data"}, fail: true},
+		{name: "absolute subdir", entries: []string{"/data:/data"}, fail: true},
+		{name: "escaping subdir", entries: []string{"../data:/data"}, fail: true},
+		{name: "image root", entries: []string{".:/data"}, fail: true},
+		{name: "relative destination", entries: []string{"data:data"}, fail: true},
+		{name: "root destination", entries: []string{"data:/"}, fail: true},
+		{name: "upper overlap", entries: []string{"upper/data:/data"}, fail: true},
+		{name: "root subdir overlap", root: "rootfs", entries: []string{"rootfs:/data"}, fail: true},
+		{name: "nested subdirs", entries: []string{"data:/data", "data/a:/opt"}, fail: true},
+		{name: "same destination", entries: []string{"a:/data", "b:/data/"}, fail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounts, err := parseOverlayMounts(tt.root, tt.entries)
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(mounts, tt.mounts) {
+				t.Errorf("got %v, expected %v", mounts, tt.mounts)
+			}
+		})
+	}
+}
+
+func TestAddLibsMount(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "libs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var libs []string
+	for _, d := range []string{"first", "second"} {
+		lib := filepath.Join(dir, d, "libcuda.so.1")
+		if err := os.MkdirAll(filepath.Dir(lib), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(lib, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		libs = append(libs, lib)
+	}
+
+	engineConfig := singularityConfig.NewConfig()
+	engineConfig.File.UserBindControl = true
+	engineConfig.SetLibrariesPath(libs)
+
+	c := newTestContainer(t, dir, engineConfig, false)
+	system := newTestSession(t, c)
+
+	if err := c.addLibsMount(system); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(system.Points.GetBySource(libs[0])) == 0 {
+		t.Errorf("library %s not bound", libs[0])
+	}
+	if len(system.Points.GetBySource(libs[1])) != 0 {
+		t.Errorf("library %s with the same name bound", libs[1])
+	}
+}
+
+func TestAddBusyboxMount(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "busybox-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	busybox := filepath.Join(dir, "busybox")
+	if err := ioutil.WriteFile(busybox, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		busybox    string
+		action     string
+		imageShell bool
+		expectBind bool
+		fail       bool
+	}{
+		{"disabled", "", "/.singularity.d/actions/shell", false, false, false},
+		{"exec command", busybox, "/.singularity.d/actions/exec", false, false, false},
+		{"image shell", busybox, "/.singularity.d/actions/shell", true, false, false},
+		{"no image shell", busybox, "/.singularity.d/actions/shell", false, true, false},
+		{"missing busybox", filepath.Join(dir, "missing"), "/.singularity.d/actions/shell", false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.BusyboxPath = tt.busybox
+			engineConfig.OciConfig.Process = &specs.Process{Args: []string{tt.action}}
+
+			c := newTestContainer(t, dir, engineConfig, false)
+			system := newTestSession(t, c)
+			if err := c.session.Create(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.imageShell {
+				bin := filepath.Join(c.session.RootFsPath(), "bin")
+				if err := os.MkdirAll(bin, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(bin, "sh"), nil, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := c.addBusyboxMount(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			// only run mount hooks
+			err = system.MountAll()
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			bound := len(system.Points.GetBySource(tt.busybox)) > 0 && len(system.Points.GetByDest("/bin")) > 0
+			if bound != tt.expectBind {
+				t.Errorf("unexpected busybox bind state: %v", bound)
+			}
+		})
+	}
+}
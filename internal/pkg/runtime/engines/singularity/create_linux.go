@@ -6,13 +6,18 @@
 package singularity
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/rpc"
+	"syscall"
 
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/rpc/client"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/mount"
 	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engines/singularity/config"
 )
 
@@ -30,6 +35,7 @@ func (e *EngineOperations) CreateContainer(pid int, rpcConn net.Conn) error {
 	if err := config.Parser(configurationFile, e.EngineConfig.File); err != nil {
 		return fmt.Errorf("unable to parse singularity.conf file: %s", err)
 	}
+	e.applySecureProfile()
 
 	rpcOps := &client.RPC{
 		Client: rpc.NewClient(rpcConn),
@@ -39,5 +45,56 @@ func (e *EngineOperations) CreateContainer(pid int, rpcConn net.Conn) error {
 		return fmt.Errorf("failed to initialize RPC client")
 	}
 
-	return create(e, rpcOps, pid)
+	if err := create(e, rpcOps, pid); err != nil {
+		if path := e.EngineConfig.GetDiagnosticFile(); path != "" {
+			if err := writeDiagnostic(path, e.EngineConfig, err); err != nil {
+				sylog.Warningf("Could not write diagnostic file %s: %s", path, err)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// diagnostic describes a container creation failure for batch systems
+// and automated tools
+type diagnostic struct {
+	Error       string                        `json:"error"`
+	Tag         mount.AuthorizedTag           `json:"tag,omitempty"`
+	Operation   string                        `json:"operation,omitempty"`
+	Source      string                        `json:"source,omitempty"`
+	Destination string                        `json:"destination,omitempty"`
+	Errno       int                           `json:"errno,omitempty"`
+	Config      singularityConfig.JSONConfig  `json:"config"`
+	File        *singularityConfig.FileConfig `json:"file"`
+}
+
+// writeDiagnostic writes the JSON diagnostic of the creation failure err
+// in path along with the effective engine configuration, mount failure
+// details are reported for *mount.Error errors
+func writeDiagnostic(path string, engineConfig *singularityConfig.EngineConfig, err error) error {
+	d := diagnostic{
+		Error:  err.Error(),
+		Config: *engineConfig.JSON,
+		File:   engineConfig.File,
+	}
+	// don't leak encryption key
+	d.Config.EncryptionKey = nil
+
+	if merr, ok := err.(*mount.Error); ok {
+		d.Tag = merr.Tag
+		d.Operation = merr.Syscall
+		d.Source = merr.Source
+		d.Destination = merr.Destination
+		err = merr.Err
+	}
+	if errno, ok := err.(syscall.Errno); ok {
+		d.Errno = int(errno)
+	}
+
+	data, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal diagnostic: %s", err)
+	}
+	return ioutil.WriteFile(path, data, 0644)
 }
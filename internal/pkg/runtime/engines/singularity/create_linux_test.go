@@ -0,0 +1,80 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/mount"
+	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engines/singularity/config"
+)
+
+func TestWriteDiagnostic(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "diagnostic-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name  string
+		err   error
+		tag   mount.AuthorizedTag
+		errno int
+	}{
+		{"generic error", fmt.Errorf("generic failure"), "", 0},
+		{
+			"mount error",
+			&mount.Error{Tag: mount.BindsTag, Source: "/src", Destination: "/dst", Syscall: "mount", Err: syscall.EPERM},
+			mount.BindsTag,
+			int(syscall.EPERM),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.SetImage("/image.sif")
+			engineConfig.SetEncryptionKey([]byte("secret"))
+
+			path := filepath.Join(dir, "diagnostic.json")
+			if err := writeDiagnostic(path, engineConfig, tt.err); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := diagnostic{}
+			if err := json.Unmarshal(data, &d); err != nil {
+				t.Fatalf("failed to decode diagnostic: %s", err)
+			}
+			if d.Error != tt.err.Error() {
+				t.Errorf("got error %q instead of %q", d.Error, tt.err.Error())
+			}
+			if d.Tag != tt.tag || d.Errno != tt.errno {
+				t.Errorf("got tag %q and errno %d instead of %q and %d", d.Tag, d.Errno, tt.tag, tt.errno)
+			}
+			if d.Config.Image != "/image.sif" {
+				t.Errorf("configuration not reported")
+			}
+			if d.Config.EncryptionKey != nil || engineConfig.GetEncryptionKey() == nil {
+				t.Errorf("encryption key leaked or altered")
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ import (
 type EngineOperations struct {
 	CommonConfig *config.Common                  `json:"-"`
 	EngineConfig *singularityConfig.EngineConfig `json:"engineConfig"`
+	sessionDir   *sessionDir
 }
 
 // InitConfig stores the pointer to config.Common
@@ -47,6 +47,21 @@ var nsProcName = map[specs.LinuxNamespaceType]string{
 	specs.UserNamespace:    "user",
 }
 
+// defaultSeccompProfile is the seccomp profile applied by the secure profile
+var defaultSeccompProfile = filepath.Join(buildcfg.SYSCONFDIR, "singularity", "seccomp-profiles", "default.json")
+
+// applySecureProfile adjusts configuration file settings for the secure
+// profile features not relaxed by the user, it must be called each time
+// the configuration file is parsed
+func (e *EngineOperations) applySecureProfile() {
+	if e.EngineConfig.SecureProfile("private-dev") {
+		e.EngineConfig.File.MountDev = "minimal"
+	}
+	if e.EngineConfig.SecureProfile("proc-readonly") {
+		e.EngineConfig.File.MountProcReadonly = "yes"
+	}
+}
+
 // prepareUserCaps is responsible for checking that user's requested
 // capabilities are authorized
 func (e *EngineOperations) prepareUserCaps(enforced bool) error {
@@ -151,6 +166,338 @@ func (e *EngineOperations) prepareUserCaps(enforced bool) error {
 	return nil
 }
 
+// parseUserSpec returns user and group IDs corresponding to a
+// <uid|name>[:<gid|name>] specification, when the group is not
+// specified, the primary group of the user is used if known
+func parseUserSpec(spec string) (uint32, uint32, error) {
+	var uid, gid uint32
+
+	splitted := strings.SplitN(spec, ":", 2)
+
+	pw, err := user.GetPwNam(splitted[0])
+	if err == nil {
+		uid, gid = pw.UID, pw.GID
+	} else if u, err := strconv.ParseUint(splitted[0], 10, 32); err == nil {
+		uid, gid = uint32(u), uint32(u)
+		if pw, err := user.GetPwUID(uid); err == nil {
+			gid = pw.GID
+		}
+	} else {
+		return 0, 0, fmt.Errorf("unknown user %s", splitted[0])
+	}
+
+	if len(splitted) == 1 {
+		return uid, gid, nil
+	}
+
+	gid, err = parseGroup(splitted[1])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uid, gid, nil
+}
+
+// parseGroup returns the group ID corresponding to a group name or ID
+func parseGroup(group string) (uint32, error) {
+	if gr, err := user.GetGrNam(group); err == nil {
+		return gr.GID, nil
+	} else if g, err := strconv.ParseUint(group, 10, 32); err == nil {
+		return uint32(g), nil
+	}
+	return 0, fmt.Errorf("unknown group %s", group)
+}
+
+// isMapped returns if id is in one of the user namespace mapping ranges
+func isMapped(id uint32, mappings []specs.LinuxIDMapping) bool {
+	for _, m := range mappings {
+		if id >= m.ContainerID && id-m.ContainerID < m.Size {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareUser sets the container process user and group requested
+// with --user, when a user namespace is requested IDs must be mapped
+// and unprivileged users are restricted to their own IDs otherwise
+func (e *EngineOperations) prepareUser() error {
+	spec := e.EngineConfig.GetUser()
+	if spec == "" {
+		return nil
+	}
+
+	uid, gid, err := parseUserSpec(spec)
+	if err != nil {
+		return fmt.Errorf("invalid user specification %s: %s", spec, err)
+	}
+
+	if linux := e.EngineConfig.OciConfig.Linux; linux != nil && len(linux.UIDMappings) > 0 {
+		if !isMapped(uid, linux.UIDMappings) {
+			return fmt.Errorf("uid %d is not mapped in user namespace", uid)
+		}
+		if !isMapped(gid, linux.GIDMappings) {
+			return fmt.Errorf("gid %d is not mapped in user namespace", gid)
+		}
+	} else if os.Getuid() != 0 {
+		if uid != uint32(os.Getuid()) || gid != uint32(os.Getgid()) {
+			return fmt.Errorf("only root user can run container process as uid %d and gid %d", uid, gid)
+		}
+		// already running as the requested user
+		return nil
+	}
+
+	sylog.Debugf("Running container process as uid %d and gid %d", uid, gid)
+
+	e.EngineConfig.OciConfig.Process.User.UID = uid
+	e.EngineConfig.OciConfig.Process.User.GID = gid
+	e.EngineConfig.SetTargetUID(int(uid))
+	e.EngineConfig.SetTargetGID([]int{int(gid)})
+
+	return nil
+}
+
+// prepareAdditionalGroups adds supplementary groups listed in the OCI
+// process user and requested with --group-add to target GIDs, only root
+// user can set them, unprivileged users are restricted to groups they
+// already belong to
+func (e *EngineOperations) prepareAdditionalGroups() error {
+	user := &e.EngineConfig.OciConfig.Process.User
+
+	additional := make([]uint32, 0, len(user.AdditionalGids))
+	additional = append(additional, user.AdditionalGids...)
+	for _, g := range e.EngineConfig.GetGroupAdd() {
+		gid, err := parseGroup(g)
+		if err != nil {
+			return fmt.Errorf("invalid supplementary group: %s", err)
+		}
+		additional = append(additional, gid)
+	}
+	if len(additional) == 0 {
+		return nil
+	}
+
+	if os.Getuid() != 0 {
+		groups, err := os.Getgroups()
+		if err != nil {
+			return fmt.Errorf("while getting groups: %s", err)
+		}
+		groups = append(groups, os.Getgid())
+		for _, gid := range additional {
+			if !hasGroup(groups, int(gid)) {
+				return fmt.Errorf("only root user can add supplementary group %d", gid)
+			}
+		}
+		// already member of requested groups
+		return nil
+	}
+
+	if linux := e.EngineConfig.OciConfig.Linux; linux != nil && len(linux.GIDMappings) > 0 {
+		for _, gid := range additional {
+			if !isMapped(gid, linux.GIDMappings) {
+				return fmt.Errorf("supplementary gid %d is not mapped in user namespace", gid)
+			}
+		}
+	}
+
+	gids := e.EngineConfig.GetTargetGID()
+	if len(gids) == 0 {
+		gids = []int{os.Getgid()}
+	}
+	user.AdditionalGids = user.AdditionalGids[:0]
+	for _, gid := range additional {
+		if hasGroup(gids, int(gid)) {
+			continue
+		}
+		gids = append(gids, int(gid))
+		user.AdditionalGids = append(user.AdditionalGids, gid)
+	}
+
+	sylog.Debugf("Running container process with supplementary groups %v", user.AdditionalGids)
+	e.EngineConfig.SetTargetGID(gids)
+
+	return nil
+}
+
+// hasGroup returns if gid is in groups
+func hasGroup(groups []int, gid int) bool {
+	for _, g := range groups {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareScheduling checks the scheduling policy requested with
+// --scheduling, unprivileged users can only lower the process priority
+func (e *EngineOperations) prepareScheduling() error {
+	spec := e.EngineConfig.GetScheduling()
+	if spec == "" {
+		return nil
+	}
+
+	sched, err := parseScheduling(spec)
+	if err != nil {
+		return err
+	}
+	if os.Getuid() == 0 {
+		return nil
+	}
+
+	switch sched.policy {
+	case schedFIFO, schedRR:
+		return fmt.Errorf("only root user can use %s real-time scheduling policy", sched.name)
+	case schedOther, schedBatch:
+		// kernel returns 20 - nice value
+		prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+		if err != nil {
+			return fmt.Errorf("while getting process priority: %s", err)
+		}
+		if nice := 20 - prio; sched.priority < nice {
+			return fmt.Errorf("only root user can raise process priority, nice value must be greater or equal to %d", nice)
+		}
+	}
+	return nil
+}
+
+// prepareOomScoreAdj computes the OOM score adjustment of the container
+// process from 'oom score adj' directive and --oom-score-adj option, only
+// root user can request a value lower than the configured one
+func (e *EngineOperations) prepareOomScoreAdj() error {
+	adj := e.EngineConfig.File.OomScoreAdj
+	if adj < -1000 || adj > 1000 {
+		return fmt.Errorf("bad 'oom score adj' value %d: must be between -1000 and 1000", adj)
+	}
+
+	if request := e.EngineConfig.GetOomScoreAdj(); request != "" {
+		n, err := strconv.Atoi(request)
+		if err != nil || n < -1000 || n > 1000 {
+			return fmt.Errorf("bad OOM score adjustment %q: must be an integer between -1000 and 1000", request)
+		}
+		if n < adj && os.Getuid() != 0 {
+			return fmt.Errorf("only root user can set an OOM score adjustment lower than %d", adj)
+		}
+		adj = n
+	} else if adj == 0 {
+		return nil
+	}
+
+	e.EngineConfig.OciConfig.Process.OOMScoreAdj = &adj
+	return nil
+}
+
+// bindSpec describes a src[:dst[:options]] bind specification
+type bindSpec struct {
+	src     string
+	dst     string
+	options []string
+}
+
+// String returns the normalized bind specification
+func (b bindSpec) String() string {
+	return strings.Join(append([]string{b.src, b.dst}, b.options...), ":")
+}
+
+// parseBindSpec validates and normalizes the bind specification spec,
+// destination defaults to source when empty or not specified
+func parseBindSpec(spec string) (bindSpec, error) {
+	splitted := strings.Split(spec, ":")
+	for i := range splitted {
+		splitted[i] = strings.TrimSpace(splitted[i])
+	}
+
+	b := bindSpec{src: splitted[0]}
+	if b.src == "" {
+		return b, fmt.Errorf("bind specification %q has an empty source path", spec)
+	}
+
+	b.dst = b.src
+	if len(splitted) > 1 {
+		if splitted[1] != "" {
+			b.dst = splitted[1]
+		}
+		for _, opt := range splitted[2:] {
+			if opt == "" {
+				return b, fmt.Errorf("bind specification %q has an empty option", spec)
+			}
+			b.options = append(b.options, opt)
+		}
+	}
+	return b, nil
+}
+
+// parseSystemBindSpec parses a 'bind path' directive value, contrary
+// to user bind specifications an empty option field is accepted and
+// means a read-write mount
+func parseSystemBindSpec(spec string) (bindSpec, error) {
+	if strings.Count(spec, ":") == 2 && strings.TrimSpace(spec[strings.LastIndex(spec, ":")+1:]) == "" {
+		spec = spec[:strings.LastIndex(spec, ":")]
+	}
+	return parseBindSpec(spec)
+}
+
+// prepareBinds validates user and system bind specifications so
+// malformed entries are reported before container creation, user
+// bind specifications are normalized
+func (e *EngineOperations) prepareBinds() error {
+	for _, b := range e.EngineConfig.File.BindPath {
+		if _, err := parseSystemBindSpec(b); err != nil {
+			return fmt.Errorf("bad 'bind path' directive: %s", err)
+		}
+	}
+	for _, b := range e.EngineConfig.File.SchedulerBindPath {
+		if _, err := parseSystemBindSpec(b); err != nil {
+			return fmt.Errorf("bad 'scheduler bind path' directive: %s", err)
+		}
+	}
+
+	binds := e.EngineConfig.GetBindPath()
+	normalized := make([]string, 0, len(binds))
+	for _, b := range binds {
+		spec, err := parseBindSpec(b)
+		if err != nil {
+			return err
+		}
+		normalized = append(normalized, spec.String())
+	}
+	if len(normalized) > 0 {
+		e.EngineConfig.SetBindPath(normalized)
+	}
+	return nil
+}
+
+// prepareEnv removes variables listed by 'env deny' directive from
+// the container process environment, except those explicitly set by
+// the user
+func (e *EngineOperations) prepareEnv() {
+	if e.EngineConfig.OciConfig.Process == nil {
+		return
+	}
+
+	deny := make(map[string]bool)
+	for _, key := range e.EngineConfig.File.EnvDeny {
+		deny[key] = true
+	}
+	for _, key := range e.EngineConfig.GetEnvOverrides() {
+		delete(deny, key)
+	}
+	if len(deny) == 0 {
+		return
+	}
+
+	env := e.EngineConfig.OciConfig.Process.Env[:0]
+	for _, keyval := range e.EngineConfig.OciConfig.Process.Env {
+		key := strings.SplitN(keyval, "=", 2)[0]
+		if deny[key] {
+			sylog.Verbosef("Not forwarding %s to container environment (env deny)", key)
+			continue
+		}
+		env = append(env, keyval)
+	}
+	e.EngineConfig.OciConfig.Process.Env = env
+}
+
 // prepareRootCaps is responsible for setting root capabilities
 // based on capability/configuration files and requested capabilities
 func (e *EngineOperations) prepareRootCaps() error {
@@ -429,6 +776,13 @@ func (e *EngineOperations) prepareContainerConfig(starterConfig *starter.Config)
 		e.EngineConfig.OciConfig.SetProcessApparmorProfile(param)
 	}
 	param = security.GetParam(e.EngineConfig.GetSecurity(), "seccomp")
+	if param == "" && e.EngineConfig.SecureProfile("seccomp") {
+		if seccomp.Enabled() {
+			param = defaultSeccompProfile
+		} else {
+			sylog.Warningf("Secure profile: seccomp not enabled, seccomp library is missing or too old")
+		}
+	}
 	if param != "" {
 		sylog.Debugf("Applying seccomp rule from %s", param)
 		generator := &e.EngineConfig.OciConfig.Generator
@@ -437,6 +791,10 @@ func (e *EngineOperations) prepareContainerConfig(starterConfig *starter.Config)
 		}
 	}
 
+	if e.EngineConfig.SecureProfile("no-new-privs") {
+		e.EngineConfig.OciConfig.SetProcessNoNewPrivileges(true)
+	}
+
 	// open file descriptors (autofs bug path)
 	return e.prepareFd(starterConfig)
 }
@@ -724,6 +1082,7 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 	if err := config.Parser(configurationFile, e.EngineConfig.File); err != nil {
 		return fmt.Errorf("Unable to parse singularity.conf file: %s", err)
 	}
+	e.applySecureProfile()
 
 	if !e.EngineConfig.File.AllowSetuid && starterConfig.GetIsSUID() {
 		return fmt.Errorf("suid workflow disabled by administrator")
@@ -763,10 +1122,30 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 		return fmt.Errorf("container process arguments not found")
 	}
 
+	if err := e.prepareUser(); err != nil {
+		return err
+	}
+	if err := e.prepareAdditionalGroups(); err != nil {
+		return err
+	}
+	if err := e.prepareScheduling(); err != nil {
+		return err
+	}
+	if _, err := parseUmask(e.EngineConfig.GetUmask()); err != nil {
+		return err
+	}
+	if err := e.prepareOomScoreAdj(); err != nil {
+		return err
+	}
+	if err := e.prepareBinds(); err != nil {
+		return err
+	}
+	e.prepareEnv()
+
 	uid := e.EngineConfig.GetTargetUID()
 	gids := e.EngineConfig.GetTargetGID()
 
-	if os.Getuid() == 0 && (uid != 0 || len(gids) > 0) {
+	if (os.Getuid() == 0 || e.EngineConfig.GetUser() != "") && (uid != 0 || len(gids) > 0) {
 		starterConfig.SetTargetUID(uid)
 		starterConfig.SetTargetGID(gids)
 		e.EngineConfig.OciConfig.SetProcessNoNewPrivileges(true)
@@ -0,0 +1,292 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/test"
+	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engines/singularity/config"
+)
+
+func TestParseUserSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		uid  uint32
+		gid  uint32
+		fail bool
+	}{
+		{"root", 0, 0, false},
+		{"0", 0, 0, false},
+		{"root:root", 0, 0, false},
+		{"1000:1001", 1000, 1001, false},
+		{"0:1001", 0, 1001, false},
+		{"root:1001", 0, 1001, false},
+		{"unknown-singularity-user", 0, 0, true},
+		{"0:unknown-singularity-group", 0, 0, true},
+		{"-1", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		uid, gid, err := parseUserSpec(tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("unexpected success with %q", tt.spec)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("unexpected error with %q: %s", tt.spec, err)
+			continue
+		}
+		if uid != tt.uid || gid != tt.gid {
+			t.Errorf("got %d:%d for %q instead of %d:%d", uid, gid, tt.spec, tt.uid, tt.gid)
+		}
+	}
+}
+
+func TestParseBindSpec(t *testing.T) {
+	tests := []struct {
+		spec       string
+		normalized string
+		fail       bool
+	}{
+		{"/opt", "/opt:/opt", false},
+		{"/opt:", "/opt:/opt", false},
+		{"/opt:/mnt", "/opt:/mnt", false},
+		{" /opt : /mnt ", "/opt:/mnt", false},
+		{"/opt::ro", "/opt:/opt:ro", false},
+		{"/opt:/mnt:ro", "/opt:/mnt:ro", false},
+		{"", "", true},
+		{":/mnt", "", true},
+		{"/opt:/mnt:", "", true},
+	}
+
+	for _, tt := range tests {
+		spec, err := parseBindSpec(tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("unexpected success with %q", tt.spec)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("unexpected error with %q: %s", tt.spec, err)
+			continue
+		}
+		if spec.String() != tt.normalized {
+			t.Errorf("got %q for %q instead of %q", spec.String(), tt.spec, tt.normalized)
+		}
+	}
+}
+
+func TestParseSystemBindSpec(t *testing.T) {
+	tests := []struct {
+		spec       string
+		normalized string
+		fail       bool
+	}{
+		{"/opt", "/opt:/opt", false},
+		{"/opt:/mnt", "/opt:/mnt", false},
+		{"/opt:/mnt:", "/opt:/mnt", false},
+		{"/opt:/mnt: ", "/opt:/mnt", false},
+		{"/opt::", "/opt:/opt", false},
+		{"/opt:/mnt:ro", "/opt:/mnt:ro", false},
+		{"/opt:/mnt:rw", "/opt:/mnt:rw", false},
+		{"/opt:/mnt:ro:", "", true},
+		{":/mnt:ro", "", true},
+	}
+
+	for _, tt := range tests {
+		spec, err := parseSystemBindSpec(tt.spec)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("unexpected success with %q", tt.spec)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("unexpected error with %q: %s", tt.spec, err)
+			continue
+		}
+		if spec.String() != tt.normalized {
+			t.Errorf("got %q for %q instead of %q", spec.String(), tt.spec, tt.normalized)
+		}
+	}
+}
+
+func TestIsMapped(t *testing.T) {
+	mappings := []specs.LinuxIDMapping{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+	}
+
+	tests := []struct {
+		id     uint32
+		mapped bool
+	}{
+		{0, true},
+		{1, true},
+		{65536, true},
+		{65537, false},
+	}
+
+	for _, tt := range tests {
+		if isMapped(tt.id, mappings) != tt.mapped {
+			t.Errorf("unexpected mapping result for id %d", tt.id)
+		}
+	}
+	if isMapped(0, nil) {
+		t.Errorf("id mapped without mappings")
+	}
+}
+
+func TestPrepareEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		deny      []string
+		overrides []string
+		env       []string
+		expected  []string
+	}{
+		{"no deny", nil, nil, []string{"LD_PRELOAD=a.so", "FOO=bar"}, []string{"LD_PRELOAD=a.so", "FOO=bar"}},
+		{"deny", []string{"LD_PRELOAD", "LD_AUDIT"}, nil, []string{"LD_PRELOAD=a.so", "FOO=bar", "LD_AUDIT=b.so"}, []string{"FOO=bar"}},
+		{"override", []string{"LD_PRELOAD", "LD_AUDIT"}, []string{"LD_PRELOAD"}, []string{"LD_PRELOAD=a.so", "LD_AUDIT=b.so"}, []string{"LD_PRELOAD=a.so"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.EnvDeny = tt.deny
+			engineConfig.SetEnvOverrides(tt.overrides)
+			engineConfig.OciConfig.Process = &specs.Process{Env: tt.env}
+
+			e := &EngineOperations{EngineConfig: engineConfig}
+			e.prepareEnv()
+
+			if env := engineConfig.OciConfig.Process.Env; !reflect.DeepEqual(env, tt.expected) {
+				t.Errorf("got environment %v instead of %v", env, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPrepareOomScoreAdj(t *testing.T) {
+	unprivileged := os.Getuid() != 0
+
+	tests := []struct {
+		name     string
+		config   int
+		request  string
+		expected *int
+		wantErr  bool
+	}{
+		{"unset", 0, "", nil, false},
+		{"configured", 500, "", intPtr(500), false},
+		{"raised", 500, "800", intPtr(800), false},
+		{"lowered", 500, "-100", intPtr(-100), unprivileged},
+		{"bad request", 0, "high", nil, true},
+		{"request out of range", 0, "1001", nil, true},
+		{"config out of range", -1001, "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.File.OomScoreAdj = tt.config
+			engineConfig.SetOomScoreAdj(tt.request)
+			engineConfig.OciConfig.Process = &specs.Process{}
+
+			e := &EngineOperations{EngineConfig: engineConfig}
+			err := e.prepareOomScoreAdj()
+			if err != nil && !tt.wantErr {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.wantErr {
+				t.Fatalf("unexpected success")
+			} else if err != nil {
+				return
+			}
+
+			if adj := engineConfig.OciConfig.Process.OOMScoreAdj; !reflect.DeepEqual(adj, tt.expected) {
+				t.Errorf("got OOM score adjustment %v instead of %v", adj, tt.expected)
+			}
+		})
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestPrepareAdditionalGroups(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	tests := []struct {
+		name       string
+		targetGID  []int
+		ociGids    []uint32
+		groupAdd   []string
+		mappings   []specs.LinuxIDMapping
+		gids       []int
+		additional []uint32
+		fail       bool
+	}{
+		{"none", nil, nil, nil, nil, nil, nil, false},
+		{"group add", []int{1000}, nil, []string{"root", "1001"}, nil, []int{1000, 0, 1001}, []uint32{0, 1001}, false},
+		{"oci and group add", []int{1000}, []uint32{1002}, []string{"1001", "1000"}, nil, []int{1000, 1002, 1001}, []uint32{1002, 1001}, false},
+		{"current gid", nil, nil, []string{"1001"}, nil, []int{0, 1001}, []uint32{1001}, false},
+		{"unknown group", nil, nil, []string{"unknown-singularity-group"}, nil, nil, nil, true},
+		{"mapped", []int{0}, nil, []string{"1"}, []specs.LinuxIDMapping{{ContainerID: 0, HostID: 0, Size: 2}}, []int{0, 1}, []uint32{1}, false},
+		{"not mapped", []int{0}, nil, []string{"1001"}, []specs.LinuxIDMapping{{ContainerID: 0, HostID: 0, Size: 2}}, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.SetTargetGID(tt.targetGID)
+			engineConfig.SetGroupAdd(tt.groupAdd)
+			engineConfig.OciConfig.Process = &specs.Process{User: specs.User{AdditionalGids: tt.ociGids}}
+			if tt.mappings != nil {
+				engineConfig.OciConfig.Linux = &specs.Linux{GIDMappings: tt.mappings}
+			}
+
+			e := &EngineOperations{EngineConfig: engineConfig}
+
+			err := e.prepareAdditionalGroups()
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if gids := engineConfig.GetTargetGID(); !reflect.DeepEqual(gids, tt.gids) {
+				t.Errorf("got target gids %v instead of %v", gids, tt.gids)
+			}
+			if additional := engineConfig.OciConfig.Process.User.AdditionalGids; len(additional) > 0 || len(tt.additional) > 0 {
+				if !reflect.DeepEqual(additional, tt.additional) {
+					t.Errorf("got additional gids %v instead of %v", additional, tt.additional)
+				}
+			}
+		})
+	}
+
+	t.Run("unprivileged", func(t *testing.T) {
+		test.DropPrivilege(t)
+		defer test.ResetPrivilege(t)
+
+		engineConfig := singularityConfig.NewConfig()
+		engineConfig.SetGroupAdd([]string{"4194304"})
+		engineConfig.OciConfig.Process = &specs.Process{}
+
+		e := &EngineOperations{EngineConfig: engineConfig}
+		if err := e.prepareAdditionalGroups(); err == nil {
+			t.Errorf("unexpected success while adding a group as unprivileged user")
+		}
+	})
+}
@@ -18,15 +18,19 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 	"unsafe"
 
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	"github.com/sylabs/singularity/internal/pkg/security"
 	singularity "github.com/sylabs/singularity/pkg/runtime/engines/singularity/config"
 
 	"github.com/sylabs/singularity/internal/pkg/util/user"
 
+	securejoin "github.com/cyphar/filepath-securejoin"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sylabs/singularity/internal/pkg/instance"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
@@ -59,6 +63,113 @@ func elfToGoArch(elfFile *elf.File) string {
 	return "UNKNOWN"
 }
 
+// scheduling policies as defined in linux/sched.h
+const (
+	schedOther = 0
+	schedFIFO  = 1
+	schedRR    = 2
+	schedBatch = 3
+	schedIdle  = 5
+)
+
+// schedPolicies maps scheduling policy names accepted by --scheduling
+var schedPolicies = map[string]int{
+	"other": schedOther,
+	"fifo":  schedFIFO,
+	"rr":    schedRR,
+	"batch": schedBatch,
+	"idle":  schedIdle,
+}
+
+// scheduling describes the container process scheduling, priority is the
+// nice value for other and batch policies and the real-time priority for
+// fifo and rr policies
+type scheduling struct {
+	name     string
+	policy   int
+	priority int
+}
+
+// parseScheduling parses a scheduling specification of the form
+// policy[:priority]
+func parseScheduling(spec string) (*scheduling, error) {
+	splitted := strings.SplitN(spec, ":", 2)
+
+	policy, ok := schedPolicies[splitted[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown scheduling policy %q", splitted[0])
+	}
+	sched := &scheduling{name: splitted[0], policy: policy}
+
+	if len(splitted) == 2 {
+		prio, err := strconv.Atoi(splitted[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad scheduling priority %q: %s", splitted[1], err)
+		}
+		sched.priority = prio
+	}
+
+	switch policy {
+	case schedFIFO, schedRR:
+		if sched.priority < 1 || sched.priority > 99 {
+			return nil, fmt.Errorf("%s real-time priority must be between 1 and 99", sched.name)
+		}
+	case schedIdle:
+		if len(splitted) == 2 {
+			return nil, fmt.Errorf("idle scheduling policy doesn't take a priority")
+		}
+	default:
+		if sched.priority < -20 || sched.priority > 19 {
+			return nil, fmt.Errorf("nice value must be between -20 and 19")
+		}
+	}
+	return sched, nil
+}
+
+// setScheduling applies the scheduling policy requested with --scheduling
+// to the current process, it's inherited by the container process
+func (e *EngineOperations) setScheduling() error {
+	spec := e.EngineConfig.GetScheduling()
+	if spec == "" {
+		return nil
+	}
+
+	sched, err := parseScheduling(spec)
+	if err != nil {
+		return err
+	}
+
+	param := struct{ priority int32 }{}
+	if sched.policy == schedFIFO || sched.policy == schedRR {
+		param.priority = int32(sched.priority)
+	}
+
+	sylog.Debugf("Setting %s scheduling policy with priority %d", sched.name, sched.priority)
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, 0, uintptr(sched.policy), uintptr(unsafe.Pointer(&param)))
+	if errno != 0 {
+		return fmt.Errorf("failed to set %s scheduling policy: %s", sched.name, errno)
+	}
+
+	if sched.policy == schedOther || sched.policy == schedBatch {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, sched.priority); err != nil {
+			return fmt.Errorf("failed to set nice value %d: %s", sched.priority, err)
+		}
+	}
+	return nil
+}
+
+// parseUmask parses an octal umask, -1 is returned for an empty value
+func parseUmask(umask string) (int, error) {
+	if umask == "" {
+		return -1, nil
+	}
+	mask, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || mask > 0777 {
+		return -1, fmt.Errorf("bad umask %q: must be an octal value between 0000 and 0777", umask)
+	}
+	return int(mask), nil
+}
+
 func (e *EngineOperations) checkExec() error {
 	shell := e.EngineConfig.GetShell()
 
@@ -89,6 +200,20 @@ func (e *EngineOperations) checkExec() error {
 		}
 	}
 
+	// a login shell sources /etc/profile in addition to the container
+	// environment scripts, argv[0] can't be prefixed with '-' as the
+	// kernel replaces it when executing the shell action script
+	if e.EngineConfig.GetLoginShell() && args[0] == "/.singularity.d/actions/shell" {
+		args = append([]string{args[0], "-l"}, args[1:]...)
+	}
+
+	// the entrypoint replaces the runscript and receives the user
+	// arguments, it's dispatched through the exec action so the
+	// container environment scripts are sourced before
+	if entrypoint := e.EngineConfig.GetEntrypoint(); entrypoint != "" && args[0] == "/.singularity.d/actions/run" {
+		args = append([]string{"/.singularity.d/actions/exec", entrypoint}, args[1:]...)
+	}
+
 	// If args[0] is an absolute path, exec.LookPath() looks for
 	// this file directly instead of within PATH
 	if _, err := exec.LookPath(args[0]); err == nil {
@@ -317,6 +442,17 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 		}
 	}
 
+	if err := e.setScheduling(); err != nil {
+		return err
+	}
+
+	if umask, err := parseUmask(e.EngineConfig.GetUmask()); err != nil {
+		return err
+	} else if umask >= 0 {
+		sylog.Debugf("Setting umask to %04o", umask)
+		syscall.Umask(umask)
+	}
+
 	if err := security.Configure(&e.EngineConfig.OciConfig.Spec); err != nil {
 		return fmt.Errorf("failed to apply security configuration: %s", err)
 	}
@@ -334,7 +470,7 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 				return fmt.Errorf("failed to open %s for inspection: %s", shell, errElf)
 			}
 			defer self.Close()
-			if elfArch := elfToGoArch(self); elfArch != runtime.GOARCH {
+			if elfArch := elfToGoArch(self); elfArch != runtime.GOARCH && !hasEmulation(elfArch) {
 				return fmt.Errorf("image targets %s, cannot run on %s", elfArch, runtime.GOARCH)
 			}
 			// Assume a missing shared library on ENOENT
@@ -521,7 +657,65 @@ func (e *EngineOperations) PostStartProcess(pid int) error {
 			return err
 		}
 
-		return file.Update()
+		if err := file.Update(); err != nil {
+			return err
+		}
+
+		return e.waitReady(pid)
+	}
+	return nil
+}
+
+// readyProbeInterval is the delay between two instance readiness probes
+var readyProbeInterval = 500 * time.Millisecond
+
+// waitReady waits until the instance readiness file exists or the
+// readiness command succeeds, an error is returned if the instance
+// is not ready before the readiness timeout
+func (e *EngineOperations) waitReady(pid int) error {
+	readyFile := e.EngineConfig.GetReadyFile()
+	readyCommand := e.EngineConfig.GetReadyCommand()
+
+	if readyFile == "" && readyCommand == "" {
+		return nil
+	}
+
+	timeout := time.Duration(e.EngineConfig.GetReadyTimeout()) * time.Second
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := e.probeReady(pid, readyFile, readyCommand)
+		if err == nil {
+			sylog.Debugf("Instance %s is ready", e.CommonConfig.ContainerID)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("instance not ready after %s: %s", timeout, err)
+		}
+		time.Sleep(readyProbeInterval)
+	}
+}
+
+// probeReady runs instance readiness checks once
+func (e *EngineOperations) probeReady(pid int, readyFile string, readyCommand string) error {
+	if readyFile != "" {
+		// resolve symlinks within the container root filesystem,
+		// an absolute symlink would point to the host otherwise
+		path, err := securejoin.SecureJoin(fmt.Sprintf("/proc/%d/root", pid), readyFile)
+		if err != nil {
+			return fmt.Errorf("failed to resolve ready file %s: %s", readyFile, err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("ready file %s not found", readyFile)
+		}
+	}
+	if readyCommand != "" {
+		binary := filepath.Join(buildcfg.BINDIR, "singularity")
+		uri := "instance://" + e.CommonConfig.ContainerID
+		cmd := exec.Command(binary, "exec", uri, defaultShell, "-c", readyCommand)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("ready command failed: %s: %s", err, strings.TrimSpace(string(out)))
+		}
 	}
 	return nil
 }
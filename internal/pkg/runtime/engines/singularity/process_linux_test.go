@@ -0,0 +1,257 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
+	"github.com/sylabs/singularity/internal/pkg/test"
+	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engines/singularity/config"
+)
+
+func TestWaitReady(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "ready-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	delayed := filepath.Join(dir, "delayed")
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultInterval := readyProbeInterval
+	readyProbeInterval = 10 * time.Millisecond
+	defer func() { readyProbeInterval = defaultInterval }()
+
+	tests := []struct {
+		name      string
+		readyFile string
+		timeout   int
+		fail      bool
+	}{
+		{"no probe", "", 0, false},
+		{"ready file", dir, 0, false},
+		{"symlinked ready file", link, 0, false},
+		{"delayed ready file", delayed, 2, false},
+		{"missing ready file", filepath.Join(dir, "missing"), 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.SetReadyFile(tt.readyFile)
+			engineConfig.SetReadyTimeout(tt.timeout)
+
+			e := &EngineOperations{
+				CommonConfig: &config.Common{ContainerID: "test"},
+				EngineConfig: engineConfig,
+			}
+
+			if tt.readyFile == delayed {
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					ioutil.WriteFile(delayed, nil, 0644)
+				}()
+			}
+
+			err := e.waitReady(os.Getpid())
+			if tt.fail && err == nil {
+				t.Errorf("unexpected success")
+			} else if !tt.fail && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestCheckExecLoginShell(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	tests := []struct {
+		name  string
+		login bool
+		args  []string
+	}{
+		{"shell", false, []string{defaultShell}},
+		{"login shell", true, []string{defaultShell, "-l"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.SetLoginShell(tt.login)
+			engineConfig.OciConfig.Process = &specs.Process{
+				Args: []string{"/.singularity.d/actions/shell"},
+				Env:  []string{"PATH=/bin:/usr/bin"},
+			}
+
+			e := &EngineOperations{EngineConfig: engineConfig}
+
+			// host has no shell action, shell is called directly
+			if err := e.checkExec(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if args := engineConfig.OciConfig.Process.Args; !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("got arguments %v, expected %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestCheckExecEntrypoint(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	tests := []struct {
+		name       string
+		entrypoint string
+		args       []string
+		expected   []string
+		fail       bool
+	}{
+		{"absolute", "/bin/true", []string{"/.singularity.d/actions/run", "arg"}, []string{"/bin/true", "arg"}, false},
+		{"path lookup", "true", []string{"/.singularity.d/actions/run"}, []string{"/bin/true"}, false},
+		{"not found", "/non/existent", []string{"/.singularity.d/actions/run"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.SetEntrypoint(tt.entrypoint)
+			engineConfig.OciConfig.Process = &specs.Process{
+				Args: tt.args,
+				Env:  []string{"PATH=/bin"},
+			}
+
+			e := &EngineOperations{EngineConfig: engineConfig}
+
+			err := e.checkExec()
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if args := engineConfig.OciConfig.Process.Args; !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("got arguments %v, expected %v", args, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseScheduling(t *testing.T) {
+	tests := []struct {
+		spec     string
+		policy   int
+		priority int
+		fail     bool
+	}{
+		{"batch", schedBatch, 0, false},
+		{"other:10", schedOther, 10, false},
+		{"batch:-5", schedBatch, -5, false},
+		{"idle", schedIdle, 0, false},
+		{"fifo:50", schedFIFO, 50, false},
+		{"rr:99", schedRR, 99, false},
+		{"fifo", 0, 0, true},
+		{"rr:100", 0, 0, true},
+		{"idle:10", 0, 0, true},
+		{"other:20", 0, 0, true},
+		{"batch:high", 0, 0, true},
+		{"deadline", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			sched, err := parseScheduling(tt.spec)
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if sched.policy != tt.policy || sched.priority != tt.priority {
+				t.Errorf("got policy %d priority %d, expected %d and %d", sched.policy, sched.priority, tt.policy, tt.priority)
+			}
+		})
+	}
+}
+
+func TestPrepareScheduling(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	tests := []struct {
+		spec string
+		fail bool
+	}{
+		{"", false},
+		{"batch:19", false},
+		{"idle", false},
+		{"other:-20", true},
+		{"fifo:10", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			engineConfig.SetScheduling(tt.spec)
+
+			e := &EngineOperations{EngineConfig: engineConfig}
+
+			err := e.prepareScheduling()
+			if tt.fail && err == nil {
+				t.Errorf("unexpected success")
+			} else if !tt.fail && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestParseUmask(t *testing.T) {
+	tests := []struct {
+		umask string
+		mask  int
+		fail  bool
+	}{
+		{"", -1, false},
+		{"0022", 0022, false},
+		{"077", 0077, false},
+		{"0", 0, false},
+		{"0800", -1, true},
+		{"1000", -1, true},
+		{"u=rwx", -1, true},
+	}
+
+	for _, tt := range tests {
+		mask, err := parseUmask(tt.umask)
+		if tt.fail && err == nil {
+			t.Errorf("unexpected success for %q", tt.umask)
+		} else if !tt.fail && err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.umask, err)
+		} else if mask != tt.mask {
+			t.Errorf("got umask %o for %q instead of %o", mask, tt.umask, tt.mask)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package reaper releases resources left by a session whose container
+// processes were killed without running the engine cleanup, like loop
+// devices which are still attached or mount points which are still present.
+package reaper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/util/fs/proc"
+	"github.com/sylabs/singularity/pkg/util/loop"
+)
+
+const (
+	// LockSuffix is the suffix of the session lock file
+	LockSuffix = ".lock"
+	// LoopsSuffix is the suffix of the file recording session loop devices
+	LoopsSuffix = ".loops"
+)
+
+// ErrActive is returned when the session lock is still held
+var ErrActive = errors.New("session is still active")
+
+// RecordLoop records in session record file the loop device path
+// attached to image
+func RecordLoop(record *os.File, device string, image string) error {
+	if _, err := fmt.Fprintf(record, "%s\t%s\n", device, image); err != nil {
+		return fmt.Errorf("failed to record loop device %s: %s", device, err)
+	}
+	return nil
+}
+
+// Reap unmounts leftover mount points of the session id located in root
+// directory, detaches loop devices recorded for the session and removes
+// the session directory. It returns ErrActive if the session is in use
+func Reap(root string, id string) error {
+	if _, err := strconv.Atoi(id); err != nil {
+		return fmt.Errorf("invalid session id %q", id)
+	}
+
+	path := filepath.Join(root, id)
+	lockPath := path + LockSuffix
+
+	lock, err := os.OpenFile(lockPath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open session lock file %s: %s", lockPath, err)
+	}
+	defer lock.Close()
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return ErrActive
+	}
+
+	if err := unmountAll(path); err != nil {
+		return err
+	}
+	detachLoops(path + LoopsSuffix)
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove session directory %s: %s", path, err)
+	}
+	if err := os.Remove(path + LoopsSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove session loop record %s: %s", path+LoopsSuffix, err)
+	}
+	if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove session lock file %s: %s", lockPath, err)
+	}
+	return nil
+}
+
+// unmountAll lazily unmounts path and all mount points below it,
+// deepest mount points first
+func unmountAll(path string) error {
+	mounts, err := proc.ParseMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return fmt.Errorf("while reading mount points: %s", err)
+	}
+
+	found := make(map[string]bool)
+	for parent, children := range mounts {
+		for _, p := range append(children, parent) {
+			if p == path || strings.HasPrefix(p, path+"/") {
+				found[p] = true
+			}
+		}
+	}
+
+	points := make([]string, 0, len(found))
+	for p := range found {
+		points = append(points, p)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(points)))
+
+	for _, p := range points {
+		sylog.Debugf("Unmounting leftover mount point %s", p)
+		if err := syscall.Unmount(p, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
+			return fmt.Errorf("failed to unmount %s: %s", p, err)
+		}
+	}
+	return nil
+}
+
+// detachLoops detaches loop devices listed in record file which are
+// still attached to their recorded image, devices reused for another
+// image in the meantime are left untouched
+func detachLoops(record string) {
+	f, err := os.Open(record)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			sylog.Warningf("Could not read loop devices record: %s", err)
+		}
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		device, image := fields[0], fields[1]
+
+		if !attachedTo(device, image) {
+			continue
+		}
+		sylog.Debugf("Detaching loop device %s from %s", device, image)
+		if err := loop.DetachFromPath(device); err != nil {
+			sylog.Warningf("%s", err)
+		}
+	}
+}
+
+// attachedTo returns whether loop device is backed by image
+func attachedTo(device string, image string) bool {
+	var st syscall.Stat_t
+
+	if err := syscall.Stat(image, &st); err != nil {
+		return false
+	}
+	info, err := loop.GetStatusFromPath(device)
+	if err != nil {
+		return false
+	}
+	return info.Inode == st.Ino && info.Device == uint64(st.Dev)
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package reaper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+)
+
+func TestReap(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	root, err := ioutil.TempDir("", "reaper-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := Reap(root, "../etc"); err == nil {
+		t.Errorf("unexpected success with invalid session id")
+	}
+
+	path := filepath.Join(root, "4194304")
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := os.OpenFile(path+LockSuffix, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+
+	record, err := os.OpenFile(path+LoopsSuffix, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// device not attached to the recorded image must be left untouched
+	if err := RecordLoop(record, "/dev/null", path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	record.Close()
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reap(root, "4194304"); err != ErrActive {
+		t.Errorf("expected %q error for locked session, got %v", ErrActive, err)
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_UN); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Reap(root, "4194304"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, p := range []string{path, path + LockSuffix, path + LoopsSuffix} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", p)
+		}
+	}
+}
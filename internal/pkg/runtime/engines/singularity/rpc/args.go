@@ -23,7 +23,15 @@ type LoopArgs struct {
 	Mode       int
 	Info       loop.Info64
 	MaxDevices int
+	Retries    int
+	BlockSize  uint32
 	Shared     bool
+	Pool       []string
+}
+
+// LoopDetachArgs defines the arguments to detach a loop device.
+type LoopDetachArgs struct {
+	Device string
 }
 
 // MountArgs defines the arguments to mount.
@@ -45,8 +53,9 @@ type CryptArgs struct {
 
 // ChrootArgs defines the arguments to chroot.
 type ChrootArgs struct {
-	Root   string
-	Method string
+	Root    string
+	Method  string
+	Retries int
 }
 
 // HostnameArgs defines the arguments to sethostname.
@@ -60,7 +69,56 @@ type SetFsIDArgs struct {
 	GID int
 }
 
+// MknodArgs defines the arguments to create a device node.
+type MknodArgs struct {
+	Path string
+	Mode uint32
+	Dev  int
+	UID  int
+	GID  int
+}
+
 // ChdirArgs defines the arguments to chdir.
 type ChdirArgs struct {
 	Dir string
 }
+
+// FsckArgs defines the arguments to fsck.
+type FsckArgs struct {
+	Device string
+}
+
+// NvCCLIArgs defines the arguments to nvidia-container-cli.
+type NvCCLIArgs struct {
+	Flags      []string
+	RootFsPath string
+	Pid        int
+	UserNS     bool
+}
+
+// SysctlArgs defines the arguments to set a kernel parameter.
+type SysctlArgs struct {
+	Key   string
+	Value string
+}
+
+// SquashfuseArgs defines the arguments to mount a squashfs image with squashfuse.
+type SquashfuseArgs struct {
+	Image  string
+	Offset uint64
+	Target string
+}
+
+// RemoteMountArgs defines the arguments to mount a remote image.
+type RemoteMountArgs struct {
+	Driver   string
+	URL      string
+	Target   string
+	CacheDir string
+}
+
+// ProjectQuotaArgs defines the arguments to set project quota.
+type ProjectQuotaArgs struct {
+	Path string
+	Size uint64
+}
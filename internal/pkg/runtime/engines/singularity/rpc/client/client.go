@@ -15,6 +15,10 @@ import (
 	"github.com/sylabs/singularity/pkg/util/loop"
 )
 
+// DefaultChrootRetries is the number of chroot retries used by
+// engines without a configuration file.
+const DefaultChrootRetries = 4
+
 // RPC holds the state necessary for remote procedure calls.
 type RPC struct {
 	Client *rpc.Client
@@ -57,6 +61,89 @@ func (t *RPC) Decrypt(offset uint64, path string, key []byte, masterPid int) (st
 	return reply, err
 }
 
+// Fsck calls the fsck RPC using the supplied arguments.
+func (t *RPC) Fsck(device string) (int, error) {
+	arguments := &args.FsckArgs{
+		Device: device,
+	}
+
+	var reply int
+	err := t.Client.Call(t.Name+".Fsck", arguments, &reply)
+
+	return reply, err
+}
+
+// NvCCLI calls the nvidia-container-cli RPC using the supplied arguments.
+func (t *RPC) NvCCLI(flags []string, rootfs string, pid int, userNS bool) (int, error) {
+	arguments := &args.NvCCLIArgs{
+		Flags:      flags,
+		RootFsPath: rootfs,
+		Pid:        pid,
+		UserNS:     userNS,
+	}
+
+	var reply int
+	err := t.Client.Call(t.Name+".NvCCLI", arguments, &reply)
+
+	return reply, err
+}
+
+// Sysctl calls the sysctl RPC using the supplied arguments.
+func (t *RPC) Sysctl(key, value string) (int, error) {
+	arguments := &args.SysctlArgs{
+		Key:   key,
+		Value: value,
+	}
+
+	var reply int
+	err := t.Client.Call(t.Name+".Sysctl", arguments, &reply)
+
+	return reply, err
+}
+
+// Squashfuse calls the squashfuse RPC using the supplied arguments,
+// it returns the squashfuse process ID.
+func (t *RPC) Squashfuse(image string, offset uint64, target string) (int, error) {
+	arguments := &args.SquashfuseArgs{
+		Image:  image,
+		Offset: offset,
+		Target: target,
+	}
+
+	var reply int
+	err := t.Client.Call(t.Name+".Squashfuse", arguments, &reply)
+
+	return reply, err
+}
+
+// RemoteMount calls the remote mount RPC using the supplied arguments.
+func (t *RPC) RemoteMount(driver, url, target, cacheDir string) (int, error) {
+	arguments := &args.RemoteMountArgs{
+		Driver:   driver,
+		URL:      url,
+		Target:   target,
+		CacheDir: cacheDir,
+	}
+
+	var reply int
+	err := t.Client.Call(t.Name+".RemoteMount", arguments, &reply)
+
+	return reply, err
+}
+
+// SetProjectQuota calls the project quota RPC using the supplied arguments.
+func (t *RPC) SetProjectQuota(path string, size uint64) (int, error) {
+	arguments := &args.ProjectQuotaArgs{
+		Path: path,
+		Size: size,
+	}
+
+	var reply int
+	err := t.Client.Call(t.Name+".SetProjectQuota", arguments, &reply)
+
+	return reply, err
+}
+
 // Mkdir calls the mkdir RPC using the supplied arguments.
 func (t *RPC) Mkdir(path string, perm os.FileMode) (int, error) {
 	arguments := &args.MkdirArgs{
@@ -68,11 +155,26 @@ func (t *RPC) Mkdir(path string, perm os.FileMode) (int, error) {
 	return reply, err
 }
 
+// Mknod calls the mknod RPC using the supplied arguments.
+func (t *RPC) Mknod(path string, mode uint32, dev int, uid int, gid int) (int, error) {
+	arguments := &args.MknodArgs{
+		Path: path,
+		Mode: mode,
+		Dev:  dev,
+		UID:  uid,
+		GID:  gid,
+	}
+	var reply int
+	err := t.Client.Call(t.Name+".Mknod", arguments, &reply)
+	return reply, err
+}
+
 // Chroot calls the chroot RPC using the supplied arguments.
-func (t *RPC) Chroot(root string, method string) (int, error) {
+func (t *RPC) Chroot(root string, method string, retries int) (int, error) {
 	arguments := &args.ChrootArgs{
-		Root:   root,
-		Method: method,
+		Root:    root,
+		Method:  method,
+		Retries: retries,
 	}
 	var reply int
 	err := t.Client.Call(t.Name+".Chroot", arguments, &reply)
@@ -80,19 +182,32 @@ func (t *RPC) Chroot(root string, method string) (int, error) {
 }
 
 // LoopDevice calls the loop device RPC using the supplied arguments.
-func (t *RPC) LoopDevice(image string, mode int, info loop.Info64, maxDevices int, shared bool) (int, error) {
+func (t *RPC) LoopDevice(image string, mode int, info loop.Info64, maxDevices int, retries int, blockSize uint32, shared bool, pool []string) (int, error) {
 	arguments := &args.LoopArgs{
 		Image:      image,
 		Mode:       mode,
 		Info:       info,
 		MaxDevices: maxDevices,
+		Retries:    retries,
+		BlockSize:  blockSize,
 		Shared:     shared,
+		Pool:       pool,
 	}
 	var reply int
 	err := t.Client.Call(t.Name+".LoopDevice", arguments, &reply)
 	return reply, err
 }
 
+// LoopDetach calls the loop detach RPC using the supplied arguments.
+func (t *RPC) LoopDetach(device string) (int, error) {
+	arguments := &args.LoopDetachArgs{
+		Device: device,
+	}
+	var reply int
+	err := t.Client.Call(t.Name+".LoopDetach", arguments, &reply)
+	return reply, err
+}
+
 // SetHostname calls the sethostname RPC using the supplied arguments.
 func (t *RPC) SetHostname(hostname string) (int, error) {
 	arguments := &args.HostnameArgs{
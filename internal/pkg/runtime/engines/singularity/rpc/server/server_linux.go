@@ -6,24 +6,76 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	args "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/rpc"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/bin"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/quota"
 	"github.com/sylabs/singularity/internal/pkg/util/mainthread"
 	"github.com/sylabs/singularity/internal/pkg/util/user"
 	"github.com/sylabs/singularity/pkg/util/crypt"
+	"github.com/sylabs/singularity/pkg/util/fs/proc"
 	"github.com/sylabs/singularity/pkg/util/loop"
 	"github.com/sylabs/singularity/pkg/util/namespaces"
+	"github.com/sylabs/singularity/pkg/util/sysctl"
 )
 
 var diskGID = -1
 
+// chrootRetryDelay is the delay between two attempts of root directory
+// switch operations failing with EBUSY while mounts are still settling
+const chrootRetryDelay = 100 * time.Millisecond
+
+// loopRetryDelay is the base delay between two loop device attach
+// attempts when all devices are busy, it grows with each attempt
+const loopRetryDelay = 100 * time.Millisecond
+
+// retryBusy calls fn until it returns an error other than EBUSY, fn
+// is called again at most retries times
+func retryBusy(retries int, fn func() error) error {
+	var err error
+
+	for i := 0; i <= retries; i++ {
+		if err = fn(); err != syscall.EBUSY {
+			return err
+		}
+		if i < retries {
+			sylog.Debugf("Device or resource busy, retrying in %s", chrootRetryDelay)
+			time.Sleep(chrootRetryDelay)
+		}
+	}
+	return fmt.Errorf("%s after %d attempts", err, retries+1)
+}
+
+// makeMountPrivate applies private propagation to mountpoint if it's
+// a shared mount, this doesn't affect mount points underneath
+func makeMountPrivate(mountpoint string) error {
+	propagation, err := proc.MountPropagation("/proc/self/mountinfo", mountpoint)
+	if err != nil {
+		return err
+	}
+	for _, p := range propagation {
+		if !strings.HasPrefix(p, "shared:") {
+			continue
+		}
+		sylog.Debugf("Apply private mount propagation for %s", mountpoint)
+		if err := syscall.Mount("", mountpoint, "", syscall.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("failed to apply private mount propagation for %s: %s", mountpoint, err)
+		}
+	}
+	return nil
+}
+
 // Methods is a receiver type.
 type Methods int
 
@@ -66,6 +118,160 @@ func (t *Methods) Decrypt(arguments *args.CryptArgs, reply *string) (err error)
 	return err
 }
 
+// Fsck checks and repairs the ext3 file system of a block device
+// with e2fsck, reply contains the e2fsck exit status.
+func (t *Methods) Fsck(arguments *args.FsckArgs, reply *int) error {
+	e2fsck, err := bin.Find("e2fsck")
+	if err != nil {
+		// sbin directories are usually not in user PATH
+		for _, dir := range []string{"/sbin", "/usr/sbin", "/bin", "/usr/bin"} {
+			if path, err := exec.LookPath(filepath.Join(dir, "e2fsck")); err == nil {
+				e2fsck = path
+				break
+			}
+		}
+	}
+	if e2fsck == "" {
+		return fmt.Errorf("e2fsck not found")
+	}
+
+	sylog.Debugf("Running %s -p %s", e2fsck, arguments.Device)
+	out, err := exec.Command(e2fsck, "-p", arguments.Device).CombinedOutput()
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return fmt.Errorf("failed to execute %s: %s", e2fsck, err)
+		}
+		status := exitErr.Sys().(syscall.WaitStatus)
+		// exit status 1 and 2 mean that errors were corrected
+		if status.ExitStatus() > 2 {
+			return fmt.Errorf("file system check failed with exit status %d: %s", status.ExitStatus(), out)
+		}
+		*reply = status.ExitStatus()
+	}
+
+	return nil
+}
+
+// NvCCLI runs nvidia-container-cli to set up NVIDIA GPU devices and
+// libraries in the container root filesystem.
+func (t *Methods) NvCCLI(arguments *args.NvCCLIArgs, reply *int) error {
+	nvCCLI, err := bin.Find("nvidia-container-cli")
+	if err != nil {
+		return fmt.Errorf("nvidia-container-cli not found: %s", err)
+	}
+
+	cmdArgs := make([]string, 0, len(arguments.Flags)+4)
+	if arguments.UserNS {
+		cmdArgs = append(cmdArgs, "--user")
+	}
+	cmdArgs = append(cmdArgs, "configure", fmt.Sprintf("--pid=%d", arguments.Pid))
+	cmdArgs = append(cmdArgs, arguments.Flags...)
+	cmdArgs = append(cmdArgs, arguments.RootFsPath)
+
+	sylog.Debugf("Running %s %s", nvCCLI, strings.Join(cmdArgs, " "))
+	out, err := exec.Command(nvCCLI, cmdArgs...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("nvidia-container-cli failed: %s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Sysctl sets a kernel parameter.
+func (t *Methods) Sysctl(arguments *args.SysctlArgs, reply *int) error {
+	return sysctl.Set(arguments.Key, arguments.Value)
+}
+
+// fuseSuperMagic is the file system type reported by statfs for FUSE mounts
+const fuseSuperMagic = 0x65735546
+
+// squashfuseTimeout is the time to wait for a squashfuse mount to be ready
+const squashfuseTimeout = 10 * time.Second
+
+// Squashfuse mounts the squashfs partition at offset of an image on
+// target with squashfuse running in foreground, the squashfuse process
+// ID is returned once the mount is ready.
+func (t *Methods) Squashfuse(arguments *args.SquashfuseArgs, reply *int) error {
+	squashfuse, err := bin.Find("squashfuse")
+	if err != nil {
+		return fmt.Errorf("squashfuse is required to mount squashfs images in user namespace but was not found: %s", err)
+	}
+
+	image := arguments.Image
+	var extraFiles []*os.File
+
+	// pass a duplicate of the image file descriptor as the first
+	// extra file, the original one is still required by other mounts
+	if strings.HasPrefix(image, "/proc/self/fd/") {
+		fd, err := strconv.ParseUint(strings.TrimPrefix(image, "/proc/self/fd/"), 10, 32)
+		if err != nil {
+			return fmt.Errorf("failed to convert image file descriptor: %v", err)
+		}
+		dup, err := syscall.Dup(int(fd))
+		if err != nil {
+			return fmt.Errorf("failed to duplicate image file descriptor: %s", err)
+		}
+		f := os.NewFile(uintptr(dup), "")
+		defer f.Close()
+		extraFiles = append(extraFiles, f)
+		image = "/proc/self/fd/3"
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(squashfuse, "-f", "-o", fmt.Sprintf("ro,offset=%d", arguments.Offset), image, arguments.Target)
+	cmd.ExtraFiles = extraFiles
+	cmd.Stderr = &stderr
+
+	sylog.Debugf("Running %s", strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start squashfuse: %s", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	for start := time.Now(); time.Since(start) < squashfuseTimeout; time.Sleep(50 * time.Millisecond) {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("squashfuse failed to mount %s: %v: %s", arguments.Target, err, strings.TrimSpace(stderr.String()))
+		default:
+		}
+
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(arguments.Target, &st); err == nil && st.Type == fuseSuperMagic {
+			*reply = cmd.Process.Pid
+			return nil
+		}
+	}
+
+	cmd.Process.Kill()
+	return fmt.Errorf("squashfuse mount of %s not ready after %s", arguments.Target, squashfuseTimeout)
+}
+
+// RemoteMount mounts a remote image on target with a FUSE driver.
+func (t *Methods) RemoteMount(arguments *args.RemoteMountArgs, reply *int) error {
+	if err := os.MkdirAll(arguments.CacheDir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory %s: %s", arguments.CacheDir, err)
+	}
+
+	sylog.Debugf("Running %s %s %s", arguments.Driver, arguments.URL, arguments.Target)
+	cmd := exec.Command(arguments.Driver, arguments.URL, arguments.Target)
+	cmd.Env = append(os.Environ(), "REMOTE_OVERLAY_CACHEDIR="+arguments.CacheDir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to mount %s: %s: %s", arguments.URL, err, out)
+	}
+
+	return nil
+}
+
+// SetProjectQuota limits disk usage of a directory with a project quota.
+func (t *Methods) SetProjectQuota(arguments *args.ProjectQuotaArgs, reply *int) error {
+	return quota.SetProjectQuota(arguments.Path, arguments.Size)
+}
+
 // Mkdir performs a mkdir with the specified arguments.
 func (t *Methods) Mkdir(arguments *args.MkdirArgs, reply *int) (err error) {
 	mainthread.Execute(func() {
@@ -76,6 +282,19 @@ func (t *Methods) Mkdir(arguments *args.MkdirArgs, reply *int) (err error) {
 	return err
 }
 
+// Mknod creates a device node with the specified arguments.
+func (t *Methods) Mknod(arguments *args.MknodArgs, reply *int) (err error) {
+	mainthread.Execute(func() {
+		oldmask := syscall.Umask(0)
+		err = syscall.Mknod(arguments.Path, arguments.Mode, arguments.Dev)
+		syscall.Umask(oldmask)
+		if err == nil {
+			err = os.Lchown(arguments.Path, arguments.UID, arguments.GID)
+		}
+	})
+	return err
+}
+
 // Chroot performs a chroot with the specified arguments.
 func (t *Methods) Chroot(arguments *args.ChrootArgs, reply *int) error {
 	root := arguments.Root
@@ -105,8 +324,21 @@ func (t *Methods) Chroot(arguments *args.ChrootArgs, reply *int) error {
 		}
 		defer oldroot.Close()
 
+		// pivot_root refuses a shared new root or parent mount, only
+		// those are made private, mount points underneath keep the
+		// propagation configured by the caller
+		parent, err := proc.ParentMount(filepath.Dir(root))
+		if err != nil {
+			return fmt.Errorf("failed to find parent mount of %s: %s", root, err)
+		}
+		for _, mountpoint := range []string{root, parent} {
+			if err := makeMountPrivate(mountpoint); err != nil {
+				return err
+			}
+		}
+
 		sylog.Debugf("Called pivot_root on %s\n", root)
-		if err := syscall.PivotRoot(".", "."); err != nil {
+		if err := retryBusy(arguments.Retries, func() error { return syscall.PivotRoot(".", ".") }); err != nil {
 			return fmt.Errorf("pivot_root %s: %s", root, err)
 		}
 
@@ -126,7 +358,7 @@ func (t *Methods) Chroot(arguments *args.ChrootArgs, reply *int) error {
 		}
 	case "move":
 		sylog.Debugf("Move %s as / directory", root)
-		if err := syscall.Mount(".", "/", "", syscall.MS_MOVE, ""); err != nil {
+		if err := retryBusy(arguments.Retries, func() error { return syscall.Mount(".", "/", "", syscall.MS_MOVE, "") }); err != nil {
 			return fmt.Errorf("failed to move %s as / directory: %s", root, err)
 		}
 
@@ -152,11 +384,6 @@ func (t *Methods) Chroot(arguments *args.ChrootArgs, reply *int) error {
 func (t *Methods) LoopDevice(arguments *args.LoopArgs, reply *int) error {
 	var image *os.File
 
-	loopdev := &loop.Device{}
-	loopdev.MaxLoopDevices = arguments.MaxDevices
-	loopdev.Info = &arguments.Info
-	loopdev.Shared = arguments.Shared
-
 	if strings.HasPrefix(arguments.Image, "/proc/self/fd/") {
 		strFd := strings.TrimPrefix(arguments.Image, "/proc/self/fd/")
 		fd, err := strconv.ParseUint(strFd, 10, 32)
@@ -187,11 +414,51 @@ func (t *Methods) LoopDevice(arguments *args.LoopArgs, reply *int) error {
 	defer syscall.Setfsuid(os.Getuid())
 	defer syscall.Setfsgid(os.Getgid())
 
-	err := loopdev.AttachFromFile(image, arguments.Mode, reply)
-	if err != nil {
-		return fmt.Errorf("could not attach image file to loop device: %v", err)
+	// concurrent containers may race for the same free devices,
+	// retry with an increasing delay when all devices were busy
+	for attempt := 0; ; attempt++ {
+		loopdev := &loop.Device{
+			MaxLoopDevices: arguments.MaxDevices,
+			Info:           &arguments.Info,
+			BlockSize:      arguments.BlockSize,
+			Shared:         arguments.Shared,
+			Pool:           arguments.Pool,
+		}
+
+		err := loopdev.AttachFromFile(image, arguments.Mode, reply)
+		if err == nil {
+			return nil
+		}
+		uerr, ok := err.(*loop.UnavailableError)
+		if !ok {
+			return fmt.Errorf("could not attach image file to loop device: %v", err)
+		} else if attempt >= arguments.Retries {
+			return fmt.Errorf("no loop device available after %d attempts on %d devices", attempt+1, uerr.Devices)
+		}
+
+		sylog.Debugf("All loop devices busy, retrying in %s", loopRetryDelay*time.Duration(attempt+1))
+		time.Sleep(loopRetryDelay * time.Duration(attempt+1))
 	}
-	return nil
+}
+
+// LoopDetach detaches a loop device with the specified arguments.
+func (t *Methods) LoopDetach(arguments *args.LoopDetachArgs, reply *int) error {
+	if diskGID == -1 {
+		if gr, err := user.GetGrNam("disk"); err == nil {
+			diskGID = int(gr.GID)
+		} else {
+			diskGID = 0
+		}
+	}
+
+	runtime.LockOSThread()
+	syscall.Setfsuid(0)
+	syscall.Setfsgid(diskGID)
+	defer runtime.UnlockOSThread()
+	defer syscall.Setfsuid(os.Getuid())
+	defer syscall.Setfsgid(os.Getgid())
+
+	return loop.DetachFromPath(arguments.Device)
 }
 
 // SetHostname sets hostname with the specified arguments.
@@ -0,0 +1,117 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	args "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/rpc"
+	"github.com/sylabs/singularity/internal/pkg/test"
+)
+
+func TestChrootPivotPropagation(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "chroot-pivot-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// pivot in a private mount namespace within a dedicated locked
+	// thread terminated with the goroutine, the test goroutine keeps
+	// the host root to remove the temporary directory
+	errCh := make(chan error, 1)
+	skipCh := make(chan string, 1)
+	infoCh := make(chan string, 1)
+
+	go func() {
+		runtime.LockOSThread()
+
+		if err := syscall.Unshare(syscall.CLONE_NEWNS); err != nil {
+			skipCh <- err.Error()
+			return
+		}
+		if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+			errCh <- err
+			return
+		}
+		if err := syscall.Mount("tmpfs", rootfs, "tmpfs", 0, ""); err != nil {
+			errCh <- err
+			return
+		}
+		// a shared new root must be accepted by pivot_root
+		if err := syscall.Mount("", rootfs, "", syscall.MS_SHARED, ""); err != nil {
+			errCh <- err
+			return
+		}
+		sub := filepath.Join(rootfs, "sub")
+		if err := os.Mkdir(sub, 0755); err != nil {
+			errCh <- err
+			return
+		}
+		if err := syscall.Mount("tmpfs", sub, "tmpfs", 0, ""); err != nil {
+			errCh <- err
+			return
+		}
+
+		proc := filepath.Join(rootfs, "proc")
+		if err := os.Mkdir(proc, 0755); err != nil {
+			errCh <- err
+			return
+		}
+		if err := syscall.Mount("/proc", proc, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			errCh <- err
+			return
+		}
+
+		methods := new(Methods)
+		chrootArgs := &args.ChrootArgs{Root: rootfs, Method: "pivot"}
+		if err := methods.Chroot(chrootArgs, nil); err != nil {
+			errCh <- err
+			return
+		}
+
+		b, err := ioutil.ReadFile("/proc/thread-self/mountinfo")
+		if err != nil {
+			errCh <- err
+			return
+		}
+		infoCh <- string(b)
+	}()
+
+	var mountinfo string
+	select {
+	case err := <-errCh:
+		t.Fatalf("unexpected error: %s", err)
+	case reason := <-skipCh:
+		t.Skipf("can't create mount namespace: %s", reason)
+	case mountinfo = <-infoCh:
+	}
+
+	for _, line := range strings.Split(mountinfo, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 7 || fields[4] != "/sub" {
+			continue
+		}
+		if !strings.HasPrefix(fields[6], "shared:") {
+			t.Errorf("propagation of /sub not preserved: %s", line)
+		}
+		return
+	}
+	t.Errorf("/sub not found in container mount points:\n%s", mountinfo)
+}
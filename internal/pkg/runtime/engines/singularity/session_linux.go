@@ -0,0 +1,141 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/reaper"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+const sessionLockSuffix = reaper.LockSuffix
+
+// sessionDir describes a per-invocation session directory, the lock
+// is held by the master process for the container lifetime, loop
+// devices attached for the session are recorded in loops file
+type sessionDir struct {
+	path    string
+	lock    *os.File
+	loops   *os.File
+	overlay string
+}
+
+// newSessionDir creates a session directory for the container process
+// pid under root directory and locks it, stale session directories left
+// by containers not running anymore are removed first
+func newSessionDir(root string, pid int) (*sessionDir, error) {
+	cleanStaleSessions(root)
+
+	name := strconv.Itoa(pid)
+	lockPath := filepath.Join(root, name+sessionLockSuffix)
+
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session lock file %s: %s", lockPath, err)
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to lock session %s: %s", lockPath, err)
+	}
+
+	path := filepath.Join(root, name)
+	if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+		os.Remove(lockPath)
+		lock.Close()
+		return nil, fmt.Errorf("failed to create session directory %s: %s", path, err)
+	}
+
+	loopsPath := path + reaper.LoopsSuffix
+	loops, err := os.OpenFile(loopsPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		os.Remove(path)
+		os.Remove(lockPath)
+		lock.Close()
+		return nil, fmt.Errorf("failed to create session loop record %s: %s", loopsPath, err)
+	}
+
+	return &sessionDir{path: path, lock: lock, loops: loops}, nil
+}
+
+// recordLoop records a loop device attached to image so it can be
+// detached by the reaper if the session is killed
+func (s *sessionDir) recordLoop(device string, image string) error {
+	return reaper.RecordLoop(s.loops, device, image)
+}
+
+// setOverlay records the resolved path of a writable overlay directory
+// stored outside of the session directory to be removed with the session
+func (s *sessionDir) setOverlay(path string) {
+	s.overlay = path
+}
+
+// remove deletes the session directory and releases its lock
+func (s *sessionDir) remove() error {
+	defer s.lock.Close()
+
+	if s.loops != nil {
+		s.loops.Close()
+	}
+
+	if s.overlay != "" {
+		// don't follow a path modified to point elsewhere since creation
+		if resolved, err := filepath.EvalSymlinks(s.overlay); err == nil && resolved == s.overlay {
+			if err := os.RemoveAll(s.overlay); err != nil {
+				sylog.Warningf("Failed to remove writable overlay directory %s: %s", s.overlay, err)
+			}
+		} else if err == nil {
+			sylog.Warningf("Not removing writable overlay directory %s: path resolves to %s", s.overlay, resolved)
+		}
+	}
+
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove session directory %s: %s", s.path, err)
+	}
+	if err := os.Remove(s.path + reaper.LoopsSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove session loop record %s: %s", s.path+reaper.LoopsSuffix, err)
+	}
+	if err := os.Remove(s.path + sessionLockSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove session lock file %s: %s", s.path+sessionLockSuffix, err)
+	}
+	return nil
+}
+
+// cleanStaleSessions reaps session directories under root directory
+// which are not locked anymore and whose owning process is gone
+func cleanStaleSessions(root string) {
+	files, err := ioutil.ReadDir(root)
+	if err != nil {
+		sylog.Debugf("Could not list session directories: %s", err)
+		return
+	}
+
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, sessionLockSuffix) {
+			continue
+		}
+		id := strings.TrimSuffix(name, sessionLockSuffix)
+		pid, err := strconv.Atoi(id)
+		if err != nil {
+			continue
+		}
+		if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+			continue
+		}
+
+		sylog.Debugf("Reaping stale session directory of process %d", pid)
+		if err := reaper.Reap(root, id); err != nil && err != reaper.ErrActive {
+			sylog.Debugf("Could not reap stale session: %s", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+)
+
+func TestSessionDir(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	root, err := ioutil.TempDir("", "session-root-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// stale session of a process which doesn't exist anymore
+	stale := filepath.Join(root, "4194304")
+	if err := os.Mkdir(stale, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(stale+sessionLockSuffix, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pid := os.Getpid()
+
+	dir, err := newSessionDir(root, pid)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := os.Stat(dir.path); err != nil {
+		t.Errorf("session directory not created: %s", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale session directory %s not removed", stale)
+	}
+
+	// session of a running process is locked
+	if _, err := newSessionDir(root, pid); err == nil {
+		t.Errorf("unexpected success while session is locked")
+	}
+
+	// running process session must not be removed
+	cleanStaleSessions(root)
+	if _, err := os.Stat(dir.path); err != nil {
+		t.Errorf("session directory of running process removed: %s", err)
+	}
+
+	overlay := filepath.Join(root, "overlay")
+	if err := os.MkdirAll(filepath.Join(overlay, "upper"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	dir.setOverlay(overlay)
+
+	if err := dir.remove(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := os.Stat(overlay); !os.IsNotExist(err) {
+		t.Errorf("writable overlay directory %s not removed", overlay)
+	}
+	if _, err := os.Stat(dir.path); !os.IsNotExist(err) {
+		t.Errorf("session directory %s not removed", dir.path)
+	}
+	if _, err := os.Stat(dir.path + sessionLockSuffix); !os.IsNotExist(err) {
+		t.Errorf("session lock file not removed")
+	}
+}
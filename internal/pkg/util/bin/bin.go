@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -84,3 +85,83 @@ func cryptsetup(cfgpath string) (string, error) {
 	// use exec.LookPath to verify it's an executable.
 	return exec.LookPath(path)
 }
+
+// programs maps the programs which can be located with Find to their
+// location specified in the configuration file
+var programs = map[string]func(*singularity.FileConfig) string{
+	"mksquashfs": func(c *singularity.FileConfig) string { return c.MksquashfsPath },
+	"unsquashfs": func(c *singularity.FileConfig) string {
+		if c.UnsquashfsPath != "" || c.MksquashfsPath == "" {
+			return c.UnsquashfsPath
+		}
+		// unsquashfs is usually installed along with mksquashfs
+		dir := c.MksquashfsPath
+		if filepath.Base(dir) == "mksquashfs" {
+			dir = filepath.Dir(dir)
+		}
+		return filepath.Join(dir, "unsquashfs")
+	},
+	"squashfuse": func(c *singularity.FileConfig) string { return c.SquashfusePath },
+	"mkfs.ext3":  func(c *singularity.FileConfig) string { return c.MkfsExt3Path },
+	"e2fsck":     func(c *singularity.FileConfig) string { return c.E2fsckPath },
+
+	"nvidia-container-cli": func(c *singularity.FileConfig) string { return c.NvCCLIPath },
+}
+
+// systemPrograms lists the programs executed by the RPC server, the
+// starter runs it with an empty PATH so they are searched in systemDirs
+// instead, ignoring PATH of the calling user
+var systemPrograms = map[string]bool{
+	"nvidia-container-cli": true,
+	"squashfuse":           true,
+}
+
+// systemDirs lists the directories searched for systemPrograms
+var systemDirs = []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"}
+
+// Find looks for the program name in the location specified in the
+// configuration file returning the absolute path to it, if the
+// location is undefined the program is searched in PATH, or in the
+// system directories for programs executed by the RPC server.
+func Find(name string) (string, error) {
+	return find(buildcfg.SINGULARITY_CONF_FILE, name)
+}
+
+// find is the test-friendly version of Find above.
+func find(cfgpath string, name string) (string, error) {
+	location, ok := programs[name]
+	if !ok {
+		return "", errors.Errorf("no configuration directive for %s", name)
+	}
+
+	cfg := singularity.FileConfig{}
+	if err := config.Parser(cfgpath, &cfg); err != nil {
+		return "", errors.Wrap(err, "unable to parse singularity configuration file")
+	}
+
+	path := location(&cfg)
+	if path == "" && systemPrograms[name] {
+		return lookSystemDirs(name)
+	} else if path == "" {
+		return exec.LookPath(name)
+	}
+
+	// configuration entry is either the program or the directory
+	// containing it
+	if filepath.Base(path) != name {
+		path = filepath.Join(path, name)
+	}
+
+	return exec.LookPath(path)
+}
+
+// lookSystemDirs returns the absolute path of the program name found
+// in systemDirs
+func lookSystemDirs(name string) (string, error) {
+	for _, dir := range systemDirs {
+		if path, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.Errorf("%s not found in %s", name, strings.Join(systemDirs, ":"))
+}
@@ -92,3 +92,131 @@ func TestCryptsetup(t *testing.T) {
 		})
 	}
 }
+
+func TestFind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bin-find-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "sbin-e2fsck"), 0755); err != nil {
+		t.Fatalf("cannot create directory: %+v", err)
+	}
+
+	for _, name := range []string{"e2fsck", "unsquashfs", "sbin-e2fsck/e2fsck"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatalf("cannot create fake %s: %+v", name, err)
+		}
+	}
+
+	cases := map[string]struct {
+		program       string
+		config        string
+		expectPath    string
+		expectSuccess bool
+	}{
+		"unknown program": {
+			program:       "true",
+			expectSuccess: false,
+		},
+		"program in config": {
+			program:       "e2fsck",
+			config:        "e2fsck path = " + filepath.Join(dir, "e2fsck"),
+			expectPath:    filepath.Join(dir, "e2fsck"),
+			expectSuccess: true,
+		},
+		"directory in config": {
+			program:       "e2fsck",
+			config:        "e2fsck path = " + dir,
+			expectPath:    filepath.Join(dir, "e2fsck"),
+			expectSuccess: true,
+		},
+		"invalid path": {
+			program:       "e2fsck",
+			config:        "e2fsck path = /invalid/path",
+			expectSuccess: false,
+		},
+		"unsquashfs from mksquashfs directory": {
+			program:       "unsquashfs",
+			config:        "mksquashfs path = " + filepath.Join(dir, "mksquashfs"),
+			expectPath:    filepath.Join(dir, "unsquashfs"),
+			expectSuccess: true,
+		},
+		"directory with program name suffix": {
+			program:       "e2fsck",
+			config:        "e2fsck path = " + filepath.Join(dir, "sbin-e2fsck"),
+			expectPath:    filepath.Join(dir, "sbin-e2fsck", "e2fsck"),
+			expectSuccess: true,
+		},
+		"missing mksquashfs": {
+			program:       "mksquashfs",
+			config:        "mksquashfs path = " + dir,
+			expectSuccess: false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "test.conf")
+			if err != nil {
+				t.Fatalf("cannot create temporary test configuration: %+v", err)
+			}
+			f.Close()
+			defer os.Remove(f.Name())
+
+			ioutil.WriteFile(f.Name(), []byte(tc.config+"\n"), 0644)
+
+			path, err := find(f.Name(), tc.program)
+			switch {
+			case tc.expectSuccess && err != nil:
+				t.Errorf("unexpected error calling find with config = %q, err = %+v", tc.config, err)
+			case tc.expectSuccess && path != tc.expectPath:
+				t.Errorf("calling find with config = %q, expecting %q, got %q", tc.config, tc.expectPath, path)
+			case !tc.expectSuccess && err == nil:
+				t.Errorf("unexpected result calling find with config = %q, got path = %s", tc.config, path)
+			}
+		})
+	}
+}
+
+func TestFindSystemProgram(t *testing.T) {
+	systemDir, err := ioutil.TempDir("", "bin-system-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(systemDir)
+
+	userDir, err := ioutil.TempDir("", "bin-user-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(userDir)
+
+	defaultDirs := systemDirs
+	systemDirs = []string{systemDir}
+	defer func() { systemDirs = defaultDirs }()
+
+	defaultPath := os.Getenv("PATH")
+	os.Setenv("PATH", userDir)
+	defer os.Setenv("PATH", defaultPath)
+
+	// program found in PATH only is ignored
+	nvCCLI := filepath.Join(userDir, "nvidia-container-cli")
+	if err := ioutil.WriteFile(nvCCLI, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("cannot create fake program: %+v", err)
+	}
+	if path, err := find("", "nvidia-container-cli"); err == nil {
+		t.Errorf("unexpected success, got path = %s", path)
+	}
+
+	nvCCLI = filepath.Join(systemDir, "nvidia-container-cli")
+	if err := ioutil.WriteFile(nvCCLI, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("cannot create fake program: %+v", err)
+	}
+	if path, err := find("", "nvidia-container-cli"); err != nil {
+		t.Errorf("unexpected error: %+v", err)
+	} else if path != nvCCLI {
+		t.Errorf("expecting %q, got %q", nvCCLI, path)
+	}
+}
@@ -31,6 +31,36 @@ var alwaysPassKeys = map[string]bool{
 	"FTP_PROXY":   true,
 }
 
+// SetLocaleEnv forwards host locale environment variables (LANG, LANGUAGE
+// and LC_*) to the container, overriding LANG set for a clean environment
+func SetLocaleEnv(g *generate.Generator, env []string) {
+	for _, env := range env {
+		e := strings.SplitN(env, "=", 2)
+		if len(e) != 2 {
+			continue
+		}
+		if e[0] == "LANG" || e[0] == "LANGUAGE" || strings.HasPrefix(e[0], "LC_") {
+			g.AddProcessEnv(e[0], e[1])
+		}
+	}
+}
+
+// UserKeys returns the names of variables set by the user for the
+// container with the SINGULARITYENV_ prefix
+func UserKeys(env []string) []string {
+	var keys []string
+	for _, env := range env {
+		e := strings.SplitN(env, "=", 2)
+		if len(e) != 2 || !strings.HasPrefix(e[0], envPrefix) {
+			continue
+		}
+		if key := strings.TrimPrefix(e[0], envPrefix); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
 // SetContainerEnv cleans environment variables before running the container
 func SetContainerEnv(g *generate.Generator, env []string, cleanEnv bool, homeDest string) {
 	// first deal with special variables that allow user to control $PATH at
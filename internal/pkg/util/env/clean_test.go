@@ -74,6 +74,20 @@ func TestSetContainerEnv(t *testing.T) {
 	}
 }
 
+func TestSetLocaleEnv(t *testing.T) {
+	ociConfig := &oci.Config{}
+	generator := generate.Generator{Config: &ociConfig.Spec}
+
+	SetContainerEnv(&generator, []string{"LANG=fr_FR.UTF-8", "LC_ALL=fr_FR.UTF-8", "FOO=bar"}, true, "/home/tester")
+	SetLocaleEnv(&generator, []string{"LANG=fr_FR.UTF-8", "LC_ALL=fr_FR.UTF-8", "LANGUAGE=fr", "FOO=bar"})
+
+	expected := []string{"HOME=/home/tester", "PATH=/bin:/sbin:/usr/bin:/usr/sbin:/usr/local/bin:/usr/local/sbin",
+		"LANG=fr_FR.UTF-8", "LC_ALL=fr_FR.UTF-8", "LANGUAGE=fr"}
+	if !equal(ociConfig.Process.Env, expected) {
+		t.Errorf("got environment %v, expected %v", ociConfig.Process.Env, expected)
+	}
+}
+
 // equal tells whether a and b contain the same elements.
 // A nil argument is equivalent to an empty slice.
 func equal(a, b []string) bool {
@@ -27,11 +27,13 @@ type Overlay struct {
 	lowerDirs []string
 	upperDir  string
 	workDir   string
+	options   []string
+	flags     uintptr
 }
 
 // New creates and returns an overlay layer manager
 func New() *Overlay {
-	return &Overlay{}
+	return &Overlay{flags: syscall.MS_NODEV}
 }
 
 // Add adds required directory in session layout
@@ -55,20 +57,34 @@ func (o *Overlay) Dir() string {
 }
 
 func (o *Overlay) createOverlay(system *mount.System) error {
-	flags := uintptr(syscall.MS_NODEV)
-	o.lowerDirs = append(o.lowerDirs, o.session.RootFsPath())
-
-	lowerdir := strings.Join(o.lowerDirs, ":")
-	err := system.Points.AddOverlay(mount.LayerTag, o.session.FinalPath(), flags, lowerdir, o.upperDir, o.workDir)
+	points := system.Points.GetByTag(mount.RootfsTag)
+	if len(points) <= 0 {
+		return fmt.Errorf("no root fs image found")
+	}
+	created, err := o.createLayer(points[0].Destination, system)
 	if err != nil {
 		return err
 	}
 
-	points := system.Points.GetByTag(mount.RootfsTag)
-	if len(points) <= 0 {
-		return fmt.Errorf("no root fs image found")
+	// without upper directory, additional lower directories and missing
+	// mount destinations in root filesystem the overlay is a read-only
+	// view of root filesystem, bind it instead to save overlay setup cost
+	if o.upperDir == "" && len(o.lowerDirs) == 1 && created == 0 {
+		sylog.Debugf("Overlay not required, binding root filesystem to final directory")
+		return system.Points.AddBind(mount.LayerTag, o.session.RootFsPath(), o.session.FinalPath(), syscall.MS_BIND|syscall.MS_REC)
 	}
-	return o.createLayer(points[0].Destination, system)
+
+	o.lowerDirs = append(o.lowerDirs, o.session.RootFsPath())
+
+	lowerdir := strings.Join(o.lowerDirs, ":")
+	options := strings.Join(o.options, ",")
+	return system.Points.AddOverlayWithOptions(mount.LayerTag, o.session.FinalPath(), o.flags, lowerdir, o.upperDir, o.workDir, options)
+}
+
+// SetFlags sets mount flags of the overlay merged view (eg: MS_NOSUID),
+// MS_NODEV is set by default
+func (o *Overlay) SetFlags(flags uintptr) {
+	o.flags = flags
 }
 
 // AddLowerDir adds a lower directory to overlay mount
@@ -77,6 +93,12 @@ func (o *Overlay) AddLowerDir(path string) error {
 	return nil
 }
 
+// AddOption adds an overlay option to overlay mount (eg: metacopy=off)
+func (o *Overlay) AddOption(option string) error {
+	o.options = append(o.options, option)
+	return nil
+}
+
 // SetUpperDir sets upper directory to overlay mount
 func (o *Overlay) SetUpperDir(path string) error {
 	if o.upperDir != "" {
@@ -106,13 +128,14 @@ func (o *Overlay) GetWorkDir() string {
 }
 
 // createLayer creates overlay layer based on content of root filesystem
-// given by rootFsPath
-func (o *Overlay) createLayer(rootFsPath string, system *mount.System) error {
+// given by rootFsPath and returns the number of entries created in layer
+func (o *Overlay) createLayer(rootFsPath string, system *mount.System) (int, error) {
 	sessionDir := o.session.Path()
 	st := new(syscall.Stat_t)
+	created := 0
 
 	if sessionDir == "" {
-		return fmt.Errorf("can't determine session path")
+		return 0, fmt.Errorf("can't determine session path")
 	}
 	for _, tag := range mount.GetTagList() {
 		for _, point := range system.Points.GetByTag(tag) {
@@ -144,20 +167,21 @@ func (o *Overlay) createLayer(rootFsPath string, system *mount.System) error {
 			switch st.Mode & syscall.S_IFMT {
 			case syscall.S_IFDIR:
 				if err := o.session.AddDir(dest); err != nil {
-					return err
+					return created, err
 				}
 			default:
 				if point.Type == "" {
 					if err := o.session.AddFile(dest, nil); err != nil {
-						return err
+						return created, err
 					}
 				} else {
 					if err := o.session.AddDir(dest); err != nil {
-						return err
+						return created, err
 					}
 				}
 			}
+			created++
 		}
 	}
-	return o.session.Update()
+	return created, o.session.Update()
 }
@@ -0,0 +1,209 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package overlay
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/layout"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/mount"
+	"golang.org/x/sys/unix"
+)
+
+func TestCreateOverlay(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "overlay-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		dest     string
+		upper    bool
+		lower    bool
+		fstype   string
+		lowerDir string
+	}{
+		{"existing destination", "/opt", false, false, "", ""},
+		{"missing destination", "/data", false, false, "overlay", "/data"},
+		{"upper directory", "/opt", true, false, "overlay", ""},
+		{"additional lower directory", "/opt", false, true, "overlay", ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionPath := filepath.Join(dir, "session", string('a'+rune(i)))
+			if err := os.MkdirAll(sessionPath, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			system := &mount.System{Points: &mount.Points{}}
+			ov := New()
+
+			session, err := layout.NewSession(sessionPath, "tmpfs", 0, 0, system, ov)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := session.Create(); err != nil {
+				t.Fatal(err)
+			}
+			// simulate the root filesystem mount
+			if err := os.Mkdir(filepath.Join(session.RootFsPath(), "opt"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := system.Points.AddBind(mount.RootfsTag, dir, session.RootFsPath(), syscall.MS_BIND); err != nil {
+				t.Fatal(err)
+			}
+			if err := system.Points.AddBind(mount.UserbindsTag, dir, tt.dest, syscall.MS_BIND); err != nil {
+				t.Fatal(err)
+			}
+			if tt.upper {
+				ov.SetUpperDir(filepath.Join(sessionPath, "upper"))
+				ov.SetWorkDir(filepath.Join(sessionPath, "work"))
+			}
+			if tt.lower {
+				ov.AddLowerDir(filepath.Join(sessionPath, "lower"))
+			}
+
+			if err := ov.createOverlay(system); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			points := system.Points.GetByTag(mount.LayerTag)
+			if len(points) != 1 {
+				t.Fatalf("unexpected number of layer mount points: %d", len(points))
+			}
+			if points[0].Type != tt.fstype {
+				t.Errorf("got %q layer mount point instead of %q", points[0].Type, tt.fstype)
+			}
+			if tt.fstype == "" && points[0].Source != session.RootFsPath() {
+				t.Errorf("root filesystem is not bound to final directory")
+			}
+			if tt.lowerDir != "" {
+				if _, err := session.GetPath(filepath.Join(lowerDir, tt.lowerDir)); err != nil {
+					t.Errorf("%s not created in overlay lower directory", tt.lowerDir)
+				}
+			}
+		})
+	}
+}
+
+func TestOverlayNosuid(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "overlay-nosuid-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// mount in a private mount namespace, the locked thread
+	// is terminated with the test goroutine
+	runtime.LockOSThread()
+	if err := syscall.Unshare(syscall.CLONE_NEWNS); err != nil {
+		t.Skipf("can't create mount namespace: %s", err)
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	sessionPath := filepath.Join(dir, "session")
+	if err := os.Mkdir(sessionPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	system := &mount.System{Points: &mount.Points{}}
+	ov := New()
+	ov.SetFlags(syscall.MS_NOSUID | syscall.MS_NODEV)
+
+	session, err := layout.NewSession(sessionPath, "tmpfs", 0, 0, system, ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	upper := filepath.Join(dir, "upper")
+	work := filepath.Join(dir, "work")
+	for _, d := range []string{upper, work} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ov.SetUpperDir(upper)
+	ov.SetWorkDir(work)
+
+	// setuid binary placed in upper directory
+	id, err := exec.LookPath("id")
+	if err != nil {
+		t.Skipf("id command not found")
+	}
+	content, err := ioutil.ReadFile(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(upper, "id"), content, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(upper, "id"), 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := system.Points.AddBind(mount.RootfsTag, dir, session.RootFsPath(), syscall.MS_BIND); err != nil {
+		t.Fatal(err)
+	}
+	if err := ov.createOverlay(system); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	points := system.Points.GetByTag(mount.LayerTag)
+	if len(points) != 1 {
+		t.Fatalf("unexpected number of layer mount points: %d", len(points))
+	}
+	flags, _ := mount.ConvertOptions(points[0].Options)
+	if flags&syscall.MS_NOSUID == 0 || flags&syscall.MS_NODEV == 0 {
+		t.Fatalf("overlay merged view is missing nosuid/nodev flags")
+	}
+
+	lowerdir := filepath.Join(dir, "lower")
+	if err := os.Mkdir(lowerdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	final := session.FinalPath()
+	data := "lowerdir=" + lowerdir + ",upperdir=" + upper + ",workdir=" + work
+	if err := syscall.Mount("overlay", final, "overlay", flags, data); err != nil {
+		t.Skipf("can't mount overlay: %s", err)
+	}
+	defer syscall.Unmount(final, syscall.MNT_DETACH)
+
+	fi, err := os.Stat(filepath.Join(final, "id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSetuid == 0 {
+		t.Fatalf("setuid bit not visible in overlay merged view")
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(final, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Flags&unix.ST_NOSUID == 0 {
+		t.Errorf("setuid binary in upper directory is executable with privileges")
+	}
+}
@@ -29,8 +29,9 @@ type layer interface {
 	Dir() string
 }
 
-// NewSession creates and returns a session directory layout manager
-func NewSession(path string, fstype string, size int, system *mount.System, layer layer) (*Session, error) {
+// NewSession creates and returns a session directory layout manager,
+// flags are additional mount flags applied to the session filesystem
+func NewSession(path string, fstype string, size int, flags uintptr, system *mount.System, layer layer) (*Session, error) {
 	manager := &Manager{}
 	session := &Session{Manager: manager}
 
@@ -47,7 +48,7 @@ func NewSession(path string, fstype string, size int, system *mount.System, laye
 	if size > 0 {
 		options = fmt.Sprintf("mode=1777,size=%dm", size)
 	}
-	err := system.Points.AddFS(mount.SessionTag, path, fstype, syscall.MS_NOSUID, options)
+	err := system.Points.AddFS(mount.SessionTag, path, fstype, syscall.MS_NOSUID|flags, options)
 	if err != nil {
 		return nil, err
 	}
@@ -8,6 +8,7 @@ package mount
 import (
 	"encoding/base64"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 	"syscall"
@@ -26,6 +27,32 @@ const (
 	ErrMountExists = mountError("destination is already in the mount point list")
 )
 
+// Error describes a failed mount operation, Err holds the error
+// returned by the system call (eg: syscall.EPERM, syscall.ENOENT)
+// so callers can check the failure cause
+type Error struct {
+	Tag         AuthorizedTag
+	Source      string
+	Destination string
+	Syscall     string
+	Err         error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("can't %s %s to %s: %s", e.Syscall, e.Source, e.Destination, e.Err)
+}
+
+// Unwrap returns the underlying system call error
+func (e *Error) Unwrap() error { return e.Err }
+
+// IsPermission returns true if the mount failed because of
+// insufficient privileges
+func (e *Error) IsPermission() bool { return os.IsPermission(e.Err) }
+
+// IsNotExist returns true if the mount failed because the
+// source or the destination doesn't exist
+func (e *Error) IsNotExist() bool { return os.IsNotExist(e.Err) }
+
 var mountFlags = []struct {
 	option string
 	flag   uintptr
@@ -143,10 +170,19 @@ var authorizedTags = map[AuthorizedTag]struct {
 
 var authorizedImage = map[string]fsContext{
 	"encryptfs": {true},
+	"ext2":      {true},
 	"ext3":      {true},
+	"ext4":      {true},
 	"squashfs":  {true},
 }
 
+// AuthorizeImage allows images to be mounted with file system type
+// fstype, it's intended for image formats registered at build time
+// with image.RegisterFormat.
+func AuthorizeImage(fstype string) {
+	authorizedImage[fstype] = fsContext{true}
+}
+
 var authorizedFS = map[string]fsContext{
 	"overlay": {true},
 	"tmpfs":   {true},
@@ -561,6 +597,7 @@ func (p *Points) Import(points map[AuthorizedTag][]Point) error {
 				lowerdir := ""
 				upperdir := ""
 				workdir := ""
+				extra := []string{}
 				for _, option := range options {
 					if strings.HasPrefix(option, "lowerdir=") {
 						fmt.Sscanf(option, "lowerdir=%s", &lowerdir)
@@ -568,9 +605,11 @@ func (p *Points) Import(points map[AuthorizedTag][]Point) error {
 						fmt.Sscanf(option, "upperdir=%s", &upperdir)
 					} else if strings.HasPrefix(option, "workdir=") {
 						fmt.Sscanf(option, "workdir=%s", &workdir)
+					} else {
+						extra = append(extra, option)
 					}
 				}
-				if err = p.AddOverlay(tag, point.Destination, flags, lowerdir, upperdir, workdir); err == nil {
+				if err = p.AddOverlayWithOptions(tag, point.Destination, flags, lowerdir, upperdir, workdir, strings.Join(extra, ",")); err == nil {
 					continue
 				}
 			}
@@ -593,6 +632,12 @@ func (p *Points) ImportFromSpec(mounts []specs.Mount) error {
 
 // AddImage adds an image mount point
 func (p *Points) AddImage(tag AuthorizedTag, source string, dest string, fstype string, flags uintptr, offset uint64, sizelimit uint64, key []byte) error {
+	return p.AddImageWithOptions(tag, source, dest, fstype, flags, offset, sizelimit, key, "")
+}
+
+// AddImageWithOptions adds an image mount point with additional file
+// system options (eg: data=journal)
+func (p *Points) AddImageWithOptions(tag AuthorizedTag, source string, dest string, fstype string, flags uintptr, offset uint64, sizelimit uint64, key []byte, extra string) error {
 	options := ""
 	if source == "" {
 		return fmt.Errorf("an image mount point must contain a source")
@@ -611,6 +656,9 @@ func (p *Points) AddImage(tag AuthorizedTag, source string, dest string, fstype
 	}
 	keyB64 := base64.StdEncoding.EncodeToString(key)
 	options = fmt.Sprintf("loop,offset=%d,sizelimit=%d,key=%s,errors=remount-ro", offset, sizelimit, keyB64)
+	if extra != "" {
+		options += "," + extra
+	}
 	return p.add(tag, source, dest, fstype, flags, options)
 }
 
@@ -661,6 +709,12 @@ func (p *Points) GetAllBinds() []Point {
 
 // AddOverlay adds an overlay mount point
 func (p *Points) AddOverlay(tag AuthorizedTag, dest string, flags uintptr, lowerdir string, upperdir string, workdir string) error {
+	return p.AddOverlayWithOptions(tag, dest, flags, lowerdir, upperdir, workdir, "")
+}
+
+// AddOverlayWithOptions adds an overlay mount point with additional
+// overlay options (eg: metacopy=off)
+func (p *Points) AddOverlayWithOptions(tag AuthorizedTag, dest string, flags uintptr, lowerdir string, upperdir string, workdir string, extra string) error {
 	if flags&(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_REC) != 0 {
 		return fmt.Errorf("ms_bind, ms_rec or ms_remount are not valid flags for overlay mount points")
 	}
@@ -685,6 +739,9 @@ func (p *Points) AddOverlay(tag AuthorizedTag, dest string, flags uintptr, lower
 	} else {
 		options = fmt.Sprintf("lowerdir=%s", lowerdir)
 	}
+	if extra != "" {
+		options += "," + extra
+	}
 	return p.add(tag, "overlay", dest, "overlay", flags, options)
 }
 
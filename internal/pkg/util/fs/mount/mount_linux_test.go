@@ -98,6 +98,19 @@ func TestImage(t *testing.T) {
 	if len(points.GetAllImages()) != 0 {
 		t.Errorf("failed to remove image from mount point")
 	}
+
+	if err := points.AddImageWithOptions(RootfsTag, "/fake", "/ext3", "ext3", 0, 0, 10, nil, "data=journal"); err != nil {
+		t.Fatalf("should have passed with ext3 filesystem and data option")
+	}
+	hasData := false
+	for _, option := range points.GetByDest("/ext3")[0].Options {
+		if option == "data=journal" {
+			hasData = true
+		}
+	}
+	if !hasData {
+		t.Errorf("data=journal option wasn't applied")
+	}
 }
 
 func TestOverlay(t *testing.T) {
@@ -164,6 +177,25 @@ func TestOverlay(t *testing.T) {
 	if !hasNoSuid {
 		t.Errorf("option nosuid not applied for /mnt")
 	}
+	points.RemoveAll()
+
+	if err := points.AddOverlayWithOptions(LayerTag, "/mnt", 0, "/lower", "/upper", "/work", "metacopy=off,index=off"); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	overlay = points.GetByDest("/mnt")
+	if len(overlay) != 1 {
+		t.Fatalf("one filesystem mount points should be returned")
+	}
+	extra := 0
+	for _, option := range overlay[0].Options {
+		if option == "metacopy=off" || option == "index=off" {
+			extra++
+		}
+	}
+	if extra != 2 {
+		t.Errorf("overlay options not applied for /mnt: %v", overlay[0].Options)
+	}
 }
 
 func TestFS(t *testing.T) {
@@ -374,7 +406,7 @@ func TestImport(t *testing.T) {
 					Source:      "",
 					Destination: "/opt",
 					Type:        "overlay",
-					Options:     []string{"nosuid", "nodev", "lowerdir=/", "upperdir=/upper", "workdir=/work"},
+					Options:     []string{"nosuid", "nodev", "lowerdir=/", "upperdir=/upper", "workdir=/work", "metacopy=off"},
 				},
 			},
 		},
@@ -403,6 +435,16 @@ func TestImport(t *testing.T) {
 	overlay := points.GetAllOverlays()
 	if len(overlay) != 1 {
 		t.Errorf("wrong number of overlay mount point found")
+	} else {
+		hasMetacopy := false
+		for _, option := range overlay[0].Options {
+			if option == "metacopy=off" {
+				hasMetacopy = true
+			}
+		}
+		if !hasMetacopy {
+			t.Errorf("overlay option metacopy=off not imported")
+		}
 	}
 	bind := points.GetAllBinds()
 	if len(bind) != 1 {
@@ -620,3 +662,46 @@ func TestTag(t *testing.T) {
 		}
 	}
 }
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		permission bool
+		notExist   bool
+	}{
+		{"EPERM", syscall.EPERM, true, false},
+		{"EACCES", syscall.EACCES, true, false},
+		{"ENOENT", syscall.ENOENT, false, true},
+		{"EINVAL", syscall.EINVAL, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error = &Error{
+				Tag:         BindsTag,
+				Source:      "/src",
+				Destination: "/dst",
+				Syscall:     "mount",
+				Err:         tt.err,
+			}
+			merr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("unexpected error type %T", err)
+			}
+			if merr.IsPermission() != tt.permission {
+				t.Errorf("unexpected permission result for %s", tt.err)
+			}
+			if merr.IsNotExist() != tt.notExist {
+				t.Errorf("unexpected not exist result for %s", tt.err)
+			}
+			if merr.Unwrap() != tt.err {
+				t.Errorf("unexpected unwrapped error %s", merr.Unwrap())
+			}
+			expected := fmt.Sprintf("can't mount /src to /dst: %s", tt.err)
+			if err.Error() != expected {
+				t.Errorf("got %q instead of %q", err.Error(), expected)
+			}
+		})
+	}
+}
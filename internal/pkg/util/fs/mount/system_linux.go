@@ -24,6 +24,7 @@ type System struct {
 	Mount          mountFn
 	beforeTagHooks map[AuthorizedTag][]hookFn
 	afterTagHooks  map[AuthorizedTag][]hookFn
+	currentTag     AuthorizedTag
 }
 
 func (b *System) init() {
@@ -57,11 +58,17 @@ func (b *System) RunAfterTag(tag AuthorizedTag, fn hookFn) error {
 	return nil
 }
 
+// CurrentTag returns the tag of mount points being mounted by MountAll
+func (b *System) CurrentTag() AuthorizedTag {
+	return b.currentTag
+}
+
 // MountAll iterates over mount point list and mounts every point
 // by calling hook before/after hook functions
 func (b *System) MountAll() error {
 	b.init()
 	for _, tag := range GetTagList() {
+		b.currentTag = tag
 		for _, fn := range b.beforeTagHooks[tag] {
 			if err := fn(b); err != nil {
 				return fmt.Errorf("hook function for tag %s returns error: %s", tag, err)
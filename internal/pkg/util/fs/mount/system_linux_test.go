@@ -30,6 +30,9 @@ func TestSystem(t *testing.T) {
 
 	mountFn := func(point *Point) error {
 		mnt = true
+		if tag := system.CurrentTag(); tag != BindsTag {
+			t.Errorf("unexpected current tag %s instead of %s", tag, BindsTag)
+		}
 		return nil
 	}
 	beforeHook := func(system *System) error {
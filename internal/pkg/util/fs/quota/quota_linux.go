@@ -0,0 +1,169 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package quota provides project quota support for XFS and ext4
+// file systems.
+package quota
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNotSupported is returned when the file system doesn't support
+// project quotas or when project quotas are not enabled.
+var ErrNotSupported = errors.New("project quota not supported")
+
+const (
+	fsIocFsGetXattr     = 0x801c581f
+	fsIocFsSetXattr     = 0x401c5820
+	fsXflagProjInherit  = 0x200
+	qSetQuota           = 0x800008
+	prjQuota            = 2
+	qifBlimits          = 1
+	quotaBlockSize      = 1024
+	quotactlSubcmdShift = 8
+)
+
+// fsxattr corresponds to struct fsxattr
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// dqblk corresponds to struct if_dqblk
+type dqblk struct {
+	bhardlimit uint64
+	bsoftlimit uint64
+	curspace   uint64
+	ihardlimit uint64
+	isoftlimit uint64
+	curinodes  uint64
+	btime      uint64
+	itime      uint64
+	valid      uint32
+}
+
+// SetProjectQuota assigns a project to the directory pointed by path and
+// limits the disk usage of this project to size bytes. The inode number
+// of the directory is used as project ID, files and directories created
+// underneath inherit from it. ErrNotSupported is returned if project
+// quotas are not available for the file system holding path.
+func SetProjectQuota(path string, size uint64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		return fmt.Errorf("failed to get %s status: %s", path, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	if st.Ino > uint64(^uint32(0)) {
+		return fmt.Errorf("inode number of %s can't be used as project ID", path)
+	}
+	id := uint32(st.Ino)
+
+	attr := fsxattr{}
+	if err := ioctl(f.Fd(), fsIocFsGetXattr, unsafe.Pointer(&attr)); err != nil {
+		if err == syscall.ENOTTY || err == syscall.EOPNOTSUPP {
+			return ErrNotSupported
+		}
+		return fmt.Errorf("failed to get %s attributes: %s", path, err)
+	}
+
+	attr.projid = id
+	attr.xflags |= fsXflagProjInherit
+
+	if err := ioctl(f.Fd(), fsIocFsSetXattr, unsafe.Pointer(&attr)); err != nil {
+		if err == syscall.ENOTTY || err == syscall.EOPNOTSUPP {
+			return ErrNotSupported
+		}
+		return fmt.Errorf("failed to set project ID of %s: %s", path, err)
+	}
+
+	device, err := blockDevice(st.Dev)
+	if err != nil {
+		return err
+	}
+
+	return setQuota(device, id, size)
+}
+
+func setQuota(device string, id uint32, size uint64) error {
+	dev, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+
+	quota := dqblk{
+		bhardlimit: (size + quotaBlockSize - 1) / quotaBlockSize,
+		valid:      qifBlimits,
+	}
+
+	cmd := uintptr(qSetQuota<<quotactlSubcmdShift | prjQuota)
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, cmd, uintptr(unsafe.Pointer(dev)), uintptr(id), uintptr(unsafe.Pointer(&quota)), 0, 0)
+	switch errno {
+	case 0:
+		return nil
+	case syscall.ESRCH, syscall.ENOSYS, syscall.ENOTBLK, syscall.EINVAL:
+		return ErrNotSupported
+	default:
+		return fmt.Errorf("failed to set project quota on %s: %s", device, errno)
+	}
+}
+
+// blockDevice returns the mount source of the file system
+// identified by dev
+func blockDevice(dev uint64) (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	devID := fmt.Sprintf("%d:%d", unix.Major(dev), unix.Minor(dev))
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[2] != devID {
+			continue
+		}
+		// optional fields are terminated by a single hyphen
+		for i := 6; i < len(fields)-2; i++ {
+			if fields[i] == "-" {
+				return fields[i+2], nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", ErrNotSupported
+}
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
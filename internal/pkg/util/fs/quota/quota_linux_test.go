@@ -0,0 +1,42 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package quota
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestBlockDevice(t *testing.T) {
+	var st syscall.Stat_t
+
+	if err := syscall.Stat("/proc", &st); err != nil {
+		t.Fatal(err)
+	}
+	device, err := blockDevice(st.Dev)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if device != "proc" {
+		t.Errorf("got %s as /proc mount source instead of proc", device)
+	}
+
+	if _, err := blockDevice(^uint64(0)); err != ErrNotSupported {
+		t.Errorf("unexpected result for an unknown device: %v", err)
+	}
+}
+
+func TestSetProjectQuota(t *testing.T) {
+	if err := SetProjectQuota("/non/existent/directory", 1024); err == nil {
+		t.Errorf("unexpected success with a non existent directory")
+	}
+	if err := SetProjectQuota("/etc/passwd", 1024); err == nil {
+		t.Errorf("unexpected success with a file")
+	}
+	if err := SetProjectQuota("/proc", 1024); err != ErrNotSupported {
+		t.Errorf("unexpected result with procfs: %v", err)
+	}
+}
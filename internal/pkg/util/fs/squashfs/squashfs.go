@@ -6,34 +6,13 @@
 package squashfs
 
 import (
-	"fmt"
-	"os/exec"
-	"path/filepath"
-	"strings"
-
-	"github.com/sylabs/singularity/internal/pkg/buildcfg"
-	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
-	singularityconfig "github.com/sylabs/singularity/pkg/runtime/engines/singularity/config"
+	"github.com/sylabs/singularity/internal/pkg/util/bin"
 )
 
 // GetPath figures out where the mksquashfs binary is
 // and return an error is not available or not usable.
 func GetPath() (string, error) {
-	// Parse singularity configuration file
-	c := &singularityconfig.FileConfig{}
-	configFile := buildcfg.SINGULARITY_CONF_FILE
-	if err := config.Parser(configFile, c); err != nil {
-		return "", fmt.Errorf("unable to parse singularity.conf file: %s", err)
-	}
-
-	// p is either "" or the string value in the conf file
-	p := c.MksquashfsPath
-
-	// If the path contains the binary name use it as is, otherwise add mksquashfs via filepath.Join
-	if !strings.HasSuffix(c.MksquashfsPath, "mksquashfs") {
-		p = filepath.Join(c.MksquashfsPath, "mksquashfs")
-	}
-
-	// exec.LookPath functions on absolute paths (ignoring $PATH) as well
-	return exec.LookPath(p)
+	// mksquashfs location is either the value in the configuration
+	// file or the binary found in PATH
+	return bin.Find("mksquashfs")
 }
@@ -70,6 +70,8 @@ type Options struct {
 	NoCache bool
 	// ImgCache stores a pointer to the image cache to use
 	ImgCache *cache.Handle
+	// Quiet reduces build scripts output to section headers and errors
+	Quiet bool `json:"quiet"`
 }
 
 // Common code between NewBundle and NewEncryptedBundle
@@ -22,13 +22,27 @@ func setValue(flag *pflag.Flag, value string) error {
 	return nil
 }
 
-// EnvAppendValue combines command line and environment var into a single argument
+// sanitizeValue trims potential spaces around elements of environment
+// variable value if flag is a string slice (eg: FOO="val1 , val2,val3")
+func sanitizeValue(flag *pflag.Flag, value string) string {
+	if flag.Value.Type() != "stringSlice" {
+		return value
+	}
+	vals := strings.Split(value, ",")
+	for i, e := range vals {
+		vals[i] = strings.TrimSpace(e)
+	}
+	return strings.Join(vals, ",")
+}
+
+// EnvAppendValue combines command line and environment var into a single
+// argument, environment values are appended after command line values
 func EnvAppendValue(flag *pflag.Flag, value string) error {
 	v := strings.TrimSpace(value)
 	if v == "" {
 		return nil
 	}
-	return setValue(flag, value)
+	return setValue(flag, sanitizeValue(flag, v))
 }
 
 // EnvSetValue set flag value if CLI option/argument is unset and env var is set
@@ -37,17 +51,7 @@ func EnvSetValue(flag *pflag.Flag, value string) error {
 	if flag.Changed || v == "" {
 		return nil
 	}
-	// if flag is a string slice, sanitize slice by
-	// trimming potential spaces in environment variable
-	// value (eg: FOO="val1 , val2,val3")
-	if flag.Value.Type() == "stringSlice" {
-		vals := strings.Split(v, ",")
-		for i, e := range vals {
-			vals[i] = strings.TrimSpace(e)
-		}
-		v = strings.Join(vals, ",")
-	}
-	return setValue(flag, v)
+	return setValue(flag, sanitizeValue(flag, v))
 }
 
 // EnvHandler defines an environment handler type to set flag's values
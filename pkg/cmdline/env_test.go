@@ -39,6 +39,19 @@ func TestEnvAppendValue(t *testing.T) {
 	}
 }
 
+func TestEnvAppendStringSlice(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	cmd.Flags().StringSlice("bindFlag", []string{}, "")
+	cmd.Flags().Set("bindFlag", "/opt")
+
+	EnvAppendValue(cmd.Flag("bindFlag"), " /data:/mnt:ro , /scratch ")
+	if cmd.Flag("bindFlag").Value.String() != "[/opt,/data:/mnt:ro,/scratch]" {
+		t.Errorf("The flag should be appended with the trimmed values provided, got %s.", cmd.Flag("bindFlag").Value)
+	}
+}
+
 func TestEnvSetValue(t *testing.T) {
 	test.DropPrivilege(t)
 	defer test.ResetPrivilege(t)
@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 	"unsafe"
 )
@@ -25,6 +26,28 @@ const (
 	rocompatBtreeDir    = 0x4
 )
 
+// ext4 file system features, images using them must be mounted
+// with the ext4 file system type
+const (
+	incompatExtents       = 0x40
+	incompat64Bit         = 0x80
+	incompatFlexBg        = 0x200
+	rocompatHugeFile      = 0x8
+	rocompatGdtCsum       = 0x10
+	rocompatDirNlink      = 0x20
+	rocompatExtraIsize    = 0x40
+	rocompatMetadataCsum  = 0x400
+	rocompatOrphanPresent = 0x10000
+)
+
+const (
+	ext3Incompat = incompatFileType | incompatRecover | incompatMetabg
+	ext3Rocompat = rocompatSparseSuper | rocompatLargeFile | rocompatBtreeDir
+	ext4Incompat = ext3Incompat | incompatExtents | incompat64Bit | incompatFlexBg
+	ext4Rocompat = ext3Rocompat | rocompatHugeFile | rocompatGdtCsum | rocompatDirNlink |
+		rocompatExtraIsize | rocompatMetadataCsum | rocompatOrphanPresent
+)
+
 const notValidExt3ImageMessage = "file is not a valid ext3 image"
 
 type extFSInfo struct {
@@ -39,7 +62,8 @@ type extFSInfo struct {
 type ext3Format struct{}
 
 // CheckExt3Header checks if byte content contains a valid ext3 header
-// and returns offset where ext3 partition begin
+// and returns offset where ext3 partition begin, journaled ext4 file
+// systems are accepted as well, see IsExt4
 func CheckExt3Header(b []byte) (uint64, error) {
 	var offset uint64 = extMagicOffset
 
@@ -63,16 +87,31 @@ func CheckExt3Header(b []byte) (uint64, error) {
 	if einfo.Compat&compatHasJournal == 0 {
 		return offset, fmt.Errorf(notValidExt3ImageMessage)
 	}
-	if einfo.Incompat&^(incompatFileType|incompatRecover|incompatMetabg) != 0 {
+	if einfo.Incompat&^ext4Incompat != 0 {
 		return offset, fmt.Errorf(notValidExt3ImageMessage)
 	}
-	if einfo.Rocompat&^(rocompatSparseSuper|rocompatLargeFile|rocompatBtreeDir) != 0 {
+	if einfo.Rocompat&^ext4Rocompat != 0 {
 		return offset, fmt.Errorf(notValidExt3ImageMessage)
 	}
 	offset -= extMagicOffset
 	return offset, nil
 }
 
+// IsExt4 returns whether the ext partition located at offset in r
+// uses ext4 features and must be mounted with ext4 file system type
+func IsExt4(r io.ReaderAt, offset uint64) (bool, error) {
+	einfo := &extFSInfo{}
+
+	sr := io.NewSectionReader(r, int64(offset+extMagicOffset), int64(binary.Size(einfo)))
+	if err := binary.Read(sr, binary.LittleEndian, einfo); err != nil {
+		return false, fmt.Errorf("can't read ext file system header: %s", err)
+	}
+	if !bytes.Equal(einfo.Magic[:], []byte(extMagic)) {
+		return false, fmt.Errorf("not an ext file system")
+	}
+	return einfo.Incompat&^ext3Incompat != 0 || einfo.Rocompat&^ext3Rocompat != 0, nil
+}
+
 func (f *ext3Format) initializer(img *Image, fileinfo os.FileInfo) error {
 	if fileinfo.IsDir() {
 		return debugError("not an ext3 image")
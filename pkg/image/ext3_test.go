@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"testing"
 
 	"github.com/sylabs/singularity/internal/pkg/test"
@@ -133,6 +134,36 @@ func TestCheckExt3Header(t *testing.T) {
 	}
 }
 
+func TestIsExt4(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ext4Testing-")
+	if err != nil {
+		t.Fatalf("impossible to create temporary directory: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, fsType := range []string{"ext3", "ext4"} {
+		path := filepath.Join(dir, fsType+".fs")
+		createFullVirtualBlockDevice(t, path, fsType)
+
+		img, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("impossible to load image for testing: %s", err)
+		}
+		ext4, err := IsExt4(img, 0)
+		img.Close()
+		if err != nil {
+			t.Fatalf("unexpected error with %s image: %s", fsType, err)
+		}
+		if ext4 != (fsType == "ext4") {
+			t.Errorf("%s image detected as ext4: %v", fsType, ext4)
+		}
+	}
+
+	if _, err := IsExt4(bytes.NewReader(make([]byte, bufferSize)), 0); err == nil {
+		t.Errorf("unexpected success with an empty image")
+	}
+}
+
 func TestInitializer(t *testing.T) {
 	test.DropPrivilege(t)
 	defer test.ResetPrivilege(t)
@@ -206,8 +237,8 @@ func TestInitializer(t *testing.T) {
 	_, lookErr = exec.LookPath("mkfs.ext4")
 	if lookErr == nil {
 		err = ext3InitializerTest(t, img, resolvedPath, "ext4")
-		if err == nil {
-			t.Fatal("ext3 initializer test succeeded with a ext4 image while expected to fail")
+		if err != nil {
+			t.Fatalf("ext3 initializer test failed with a valid ext4 image: %s\n", err)
 		}
 	} else {
 		t.Log("mkfs.ext4 command is not available, skipping the test...")
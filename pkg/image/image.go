@@ -53,10 +53,12 @@ func debugErrorf(format string, a ...interface{}) error {
 // ErrUnknownFormat represents an unknown image format error.
 var ErrUnknownFormat = errors.New("image format not recognized")
 
-var registeredFormats = []struct {
+type registeredFormat struct {
 	name   string
 	format format
-}{
+}
+
+var registeredFormats = []registeredFormat{
 	{"sandbox", &sandboxFormat{}},
 	{"sif", &sifFormat{}},
 	{"squashfs", &squashfsFormat{}},
@@ -69,6 +71,73 @@ type format interface {
 	initializer(*Image, os.FileInfo) error
 }
 
+// Format describes an image format handler registered with RegisterFormat.
+type Format interface {
+	// OpenMode returns the flags used to open the image file.
+	OpenMode(writable bool) int
+	// Initializer checks that the opened image file is in this format,
+	// it must return an error created with NotFormatError if it's not,
+	// and it sets image partitions with their type, offset and size.
+	Initializer(img *Image, fileinfo os.FileInfo) error
+}
+
+// NotFormatError returns an error reported by a Format initializer
+// when the image file doesn't match the format, the next registered
+// format is then probed.
+func NotFormatError(format string, a ...interface{}) error {
+	return debugErrorf(format, a...)
+}
+
+// externalFormat adapts a Format to the internal format interface.
+type externalFormat struct {
+	f Format
+}
+
+func (e *externalFormat) openMode(writable bool) int {
+	return e.f.OpenMode(writable)
+}
+
+func (e *externalFormat) initializer(img *Image, fileinfo os.FileInfo) error {
+	return e.f.Initializer(img, fileinfo)
+}
+
+// partitionFstypes maps partition types of registered formats to the
+// file system type used to mount them.
+var partitionFstypes = map[uint32]string{}
+
+// RegisterFormat registers an image format handler probed by Init after
+// built-in formats. Partitions of type partType reported by the handler
+// are mounted with the file system type fstype, partType must not be
+// used by built-in or other registered formats.
+func RegisterFormat(name string, partType uint32, fstype string, f Format) error {
+	if name == "" || fstype == "" || f == nil {
+		return fmt.Errorf("image format name, file system type and handler are required")
+	}
+	for _, rf := range registeredFormats {
+		if rf.name == name {
+			return fmt.Errorf("image format %s is already registered", name)
+		}
+	}
+	switch partType {
+	case SQUASHFS, EXT3, SANDBOX, SIF, ENCRYPTSQUASHFS:
+		return fmt.Errorf("partition type %#x is reserved", partType)
+	}
+	if _, ok := partitionFstypes[partType]; ok {
+		return fmt.Errorf("partition type %#x is already registered", partType)
+	}
+
+	partitionFstypes[partType] = fstype
+	registeredFormats = append(registeredFormats, registeredFormat{name, &externalFormat{f}})
+	return nil
+}
+
+// PartitionFstype returns the file system type used to mount partitions
+// of type partType reported by a registered format.
+func PartitionFstype(partType uint32) (string, bool) {
+	fstype, ok := partitionFstypes[partType]
+	return fstype, ok
+}
+
 // Section identifies and locates a data section in image object.
 type Section struct {
 	Size   uint64 `json:"size"`
@@ -82,15 +151,18 @@ type Section struct {
 // image format like SIF contains descriptors pointing to chunk of
 // data, chunks position and size are stored as image sections.
 type Image struct {
-	Path       string    `json:"path"`
-	Name       string    `json:"name"`
-	Type       int       `json:"type"`
-	File       *os.File  `json:"-"`
-	Fd         uintptr   `json:"fd"`
-	Source     string    `json:"source"`
-	Writable   bool      `json:"writable"`
-	Partitions []Section `json:"partitions"`
-	Sections   []Section `json:"sections"`
+	Path     string   `json:"path"`
+	Name     string   `json:"name"`
+	Type     int      `json:"type"`
+	File     *os.File `json:"-"`
+	Fd       uintptr  `json:"fd"`
+	Source   string   `json:"source"`
+	Writable bool     `json:"writable"`
+	// Architecture is the GOARCH-style architecture the image was
+	// built for, empty if unknown
+	Architecture string    `json:"architecture"`
+	Partitions   []Section `json:"partitions"`
+	Sections     []Section `json:"sections"`
 }
 
 // AuthorizedPath checks if image is in a path supplied in paths
@@ -161,6 +233,33 @@ func (i *Image) HasRootFs() bool {
 	return false
 }
 
+// Search returns the path of the image name found in the list of
+// directories dirs, name then name.sif are looked up in each directory.
+// name is returned unchanged if it's a path, if it exists in the current
+// directory or if it's not found.
+func Search(name string, dirs []string) string {
+	if len(dirs) == 0 || strings.Contains(name, "/") {
+		return name
+	}
+	if _, err := os.Stat(name); err == nil {
+		return name
+	}
+
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		for _, file := range []string{name, name + ".sif"} {
+			path := filepath.Join(dir, file)
+			if _, err := os.Stat(path); err == nil {
+				sylog.Verbosef("Image %s found in search path directory %s", name, dir)
+				return path
+			}
+		}
+	}
+	return name
+}
+
 // ResolvePath returns a resolved absolute path.
 func ResolvePath(path string) (string, error) {
 	abspath, err := filepath.Abs(path)
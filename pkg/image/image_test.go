@@ -441,3 +441,127 @@ func TestAuthorizedGroup(t *testing.T) {
 		})
 	}
 }
+
+const testFormatMagic = "TESTFMT"
+
+type testFormat struct{}
+
+func (f *testFormat) OpenMode(writable bool) int {
+	return os.O_RDONLY
+}
+
+func (f *testFormat) Initializer(img *Image, fileinfo os.FileInfo) error {
+	b := make([]byte, len(testFormatMagic))
+	if _, err := io.ReadFull(img.File, b); err != nil || string(b) != testFormatMagic {
+		return NotFormatError("not a test format image")
+	}
+	img.Partitions = []Section{
+		{
+			Offset: uint64(len(testFormatMagic)),
+			Size:   uint64(fileinfo.Size()) - uint64(len(testFormatMagic)),
+			Type:   0x2000,
+			Name:   RootFs,
+		},
+	}
+	return nil
+}
+
+func TestRegisterFormat(t *testing.T) {
+	if err := RegisterFormat("testfmt", 0x2000, "testfs", &testFormat{}); err != nil {
+		t.Fatalf("unexpected error while registering format: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		format   string
+		partType uint32
+	}{
+		{"duplicate name", "testfmt", 0x2001},
+		{"duplicate partition type", "testfmt2", 0x2000},
+		{"reserved partition type", "testfmt3", SQUASHFS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RegisterFormat(tt.format, tt.partType, "testfs", &testFormat{}); err == nil {
+				t.Errorf("unexpected success while registering format %s", tt.format)
+			}
+		})
+	}
+
+	if fstype, ok := PartitionFstype(0x2000); !ok || fstype != "testfs" {
+		t.Errorf("unexpected file system type %q for registered partition type", fstype)
+	}
+	if _, ok := PartitionFstype(0x2001); ok {
+		t.Errorf("unexpected file system type for unregistered partition type")
+	}
+
+	f, err := ioutil.TempFile("", "testfmt-")
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(testFormatMagic + "rootfs data"); err != nil {
+		t.Fatalf("failed to write temporary file: %s", err)
+	}
+	f.Close()
+
+	img, err := Init(f.Name(), false)
+	if err != nil {
+		t.Fatalf("failed to initialize image: %s", err)
+	}
+	defer img.File.Close()
+
+	if !img.HasRootFs() || img.Partitions[0].Type != 0x2000 {
+		t.Errorf("registered format partitions not reported: %+v", img.Partitions)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-path-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := filepath.Join(dir, "first")
+	second := filepath.Join(dir, "second")
+	for _, d := range []string{first, second} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{
+		filepath.Join(first, "tensorflow.sif"),
+		filepath.Join(second, "tensorflow.sif"),
+		filepath.Join(second, "ubuntu"),
+	} {
+		if err := ioutil.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirs := []string{first, "", second}
+
+	tests := []struct {
+		name     string
+		image    string
+		dirs     []string
+		expected string
+	}{
+		{"no search path", "tensorflow", nil, "tensorflow"},
+		{"relative path", "./tensorflow", dirs, "./tensorflow"},
+		{"absolute path", "/tensorflow", dirs, "/tensorflow"},
+		{"sif suffix", "tensorflow", dirs, filepath.Join(first, "tensorflow.sif")},
+		{"exact name", "ubuntu", dirs, filepath.Join(second, "ubuntu")},
+		{"not found", "centos", dirs, "centos"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if path := Search(tt.image, tt.dirs); path != tt.expected {
+				t.Errorf("got %s instead of %s", path, tt.expected)
+			}
+		})
+	}
+}
@@ -28,6 +28,25 @@ func (s *Squashfs) HasMksquashfs() bool {
 	return s.MksquashfsPath != ""
 }
 
+// HasXattrs returns if mksquashfs binary supports extended attributes,
+// mksquashfs compiled without xattr support doesn't list -xattrs option
+// in its usage
+func (s *Squashfs) HasXattrs() bool {
+	var out bytes.Buffer
+
+	if !s.HasMksquashfs() {
+		return false
+	}
+
+	cmd := exec.Command(s.MksquashfsPath, "-help")
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// mksquashfs exits with a non zero status when displaying usage
+	cmd.Run()
+
+	return bytes.Contains(out.Bytes(), []byte("-xattrs"))
+}
+
 func (s *Squashfs) create(files []string, dest string, opts []string) error {
 	var stderr bytes.Buffer
 
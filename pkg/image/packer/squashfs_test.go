@@ -77,4 +77,14 @@ func TestSquashfs(t *testing.T) {
 
 	// ensure we can extract these files from squashfs
 	checkArchive(t, image.Name(), []string{"squashfs.go", "squashfs_test.go"})
+
+	if s.HasXattrs() {
+		if err := s.Create([]string{"."}, image.Name(), []string{"-noappend", "-xattrs"}); err != nil {
+			t.Errorf("unexpected error while creating squashfs with xattrs: %s", err)
+		}
+	}
+	s.MksquashfsPath = ""
+	if s.HasXattrs() {
+		t.Errorf("unexpected xattrs support with empty mksquashfs path")
+	}
 }
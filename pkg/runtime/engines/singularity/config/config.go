@@ -19,12 +19,22 @@ type FileConfig struct {
 	ConfigPasswd            bool     `default:"yes" authorized:"yes,no" directive:"config passwd"`
 	ConfigGroup             bool     `default:"yes" authorized:"yes,no" directive:"config group"`
 	ConfigResolvConf        bool     `default:"yes" authorized:"yes,no" directive:"config resolv_conf"`
+	ConfigMtab              bool     `default:"no" authorized:"yes,no" directive:"config mtab"`
+	ConfigHostname          bool     `default:"yes" authorized:"yes,no" directive:"config hostname"`
+	ConfigCACertificates    bool     `default:"no" authorized:"yes,no" directive:"config ca certificates"`
+	ConfigLocale            bool     `default:"no" authorized:"yes,no" directive:"config locale"`
+	SecureProfile           bool     `default:"no" authorized:"yes,no" directive:"secure profile"`
 	MountProc               bool     `default:"yes" authorized:"yes,no" directive:"mount proc"`
 	MountSys                bool     `default:"yes" authorized:"yes,no" directive:"mount sys"`
+	MountSysReadonly        bool     `default:"no" authorized:"yes,no" directive:"mount sys readonly"`
+	MountSysClassNet        bool     `default:"no" authorized:"yes,no" directive:"mount sys class net"`
 	MountDevPts             bool     `default:"yes" authorized:"yes,no" directive:"mount devpts"`
+	MountDevLog             bool     `default:"no" authorized:"yes,no" directive:"mount dev log"`
 	MountHome               bool     `default:"yes" authorized:"yes,no" directive:"mount home"`
+	TemporaryHome           bool     `default:"yes" authorized:"yes,no" directive:"temporary home"`
 	MountTmp                bool     `default:"yes" authorized:"yes,no" directive:"mount tmp"`
 	MountHostfs             bool     `default:"no" authorized:"yes,no" directive:"mount hostfs"`
+	MountHostfsOpt          bool     `default:"yes" authorized:"yes,no" directive:"mount hostfs opt"`
 	UserBindControl         bool     `default:"yes" authorized:"yes,no" directive:"user bind control"`
 	EnableUnderlay          bool     `default:"yes" authorized:"yes,no" directive:"enable underlay"`
 	MountSlave              bool     `default:"yes" authorized:"yes,no" directive:"mount slave"`
@@ -32,29 +42,82 @@ type FileConfig struct {
 	AllowContainerExtfs     bool     `default:"yes" authorized:"yes,no" directive:"allow container extfs"`
 	AllowContainerDir       bool     `default:"yes" authorized:"yes,no" directive:"allow container dir"`
 	AlwaysUseNv             bool     `default:"no" authorized:"yes,no" directive:"always use nv"`
+	NvCudaCache             bool     `default:"yes" authorized:"yes,no" directive:"nv cuda cache"`
+	UseNvCCLI               bool     `default:"no" authorized:"yes,no" directive:"use nvidia container cli"`
+	SchedulerIntegration    bool     `default:"no" authorized:"yes,no" directive:"scheduler integration"`
 	SharedLoopDevices       bool     `default:"no" authorized:"yes,no" directive:"shared loop devices"`
+	LoopDirectIO            bool     `default:"no" authorized:"yes,no" directive:"loop direct io"`
+	RootfsPrefetch          bool     `default:"no" authorized:"yes,no" directive:"rootfs prefetch"`
+	RootfsNoatime           bool     `default:"no" authorized:"yes,no" directive:"rootfs noatime"`
+	RequireSignedContainers bool     `default:"no" authorized:"yes,no" directive:"require signed containers"`
+	SquashfsErrorsContinue  bool     `default:"no" authorized:"yes,no" directive:"squashfs errors continue"`
+	SessiondirNoexec        bool     `default:"no" authorized:"yes,no" directive:"sessiondir noexec"`
 	MaxLoopDevices          uint     `default:"256" directive:"max loop devices"`
+	LoopAttachRetries       uint     `default:"3" directive:"loop attach retries"`
+	ChrootRetries           uint     `default:"4" directive:"chroot retries"`
 	SessiondirMaxSize       uint     `default:"16" directive:"sessiondir max size"`
+	MaxBindPoints           uint     `default:"0" directive:"max bind points"`
+	WorkdirMinFreeSpace     uint     `default:"0" directive:"workdir min free space"`
+	OomScoreAdj             int      `default:"0" directive:"oom score adj"`
+	WorkdirFreeSpaceCheck   string   `default:"warn" authorized:"warn,error" directive:"workdir free space check"`
+	StrictBindCheck         bool     `default:"no" authorized:"yes,no" directive:"strict bind check"`
+	ReadonlyBindCheck       string   `default:"warn" authorized:"no,warn,error" directive:"readonly bind check"`
 	MountDev                string   `default:"yes" authorized:"yes,no,minimal" directive:"mount dev"`
+	DevProcSymlinks         string   `default:"auto" authorized:"yes,no,auto" directive:"dev proc symlinks"`
+	MountProcReadonly       string   `default:"unprivileged" authorized:"yes,no,unprivileged" directive:"mount proc readonly"`
 	EnableOverlay           string   `default:"try" authorized:"yes,no,try" directive:"enable overlay"`
+	OverlayStrict           bool     `default:"no" authorized:"yes,no" directive:"overlay strict"`
+	OverlayMetacopy         string   `default:"default" authorized:"yes,no,default" directive:"overlay metacopy"`
+	OverlayFsck             bool     `default:"no" authorized:"yes,no" directive:"overlay fsck"`
+	OverlaySync             string   `default:"default" authorized:"default,journal,writeback,sync" directive:"overlay sync"`
+	OverlayLockPolicy       string   `default:"fail" authorized:"fail,readonly,wait" directive:"overlay lock policy"`
+	RemoteOverlayDriver     string   `directive:"remote overlay driver"`
+	RemoteOverlayCacheDir   string   `directive:"remote overlay cache dir"`
+	RootfsFstype            string   `directive:"rootfs fstype"`
+	SignatureKeyring        string   `directive:"signature keyring"`
 	BindPath                []string `default:"/etc/localtime,/etc/hosts" directive:"bind path"`
+	SchedulerBindPath       []string `directive:"scheduler bind path"`
+	SysWritablePath         []string `directive:"sys writable path"`
+	HostfsOptPath           []string `directive:"hostfs opt path"`
+	MountFlags              []string `directive:"mount flags"`
+	UserBindDenyDest        []string `directive:"user bind deny dest"`
+	CwdSkipPath             []string `default:"/,/etc,/bin,/mnt,/usr,/var,/opt,/sbin,/lib,/lib64" directive:"cwd skip path"`
+	LoopDevicePool          []string `directive:"loop device pool"`
+	LibrariesBindOrder      []string `default:"containlibs,nv" directive:"libraries bind order"`
+	EnvDeny                 []string `default:"LD_PRELOAD,LD_AUDIT,LD_LIBRARY_PATH" directive:"env deny"`
 	LimitContainerOwners    []string `directive:"limit container owners"`
 	LimitContainerGroups    []string `directive:"limit container groups"`
 	LimitContainerPaths     []string `directive:"limit container paths"`
+	ImageSearchPath         []string `directive:"image search path"`
 	AutofsBugPath           []string `directive:"autofs bug path"`
 	RootDefaultCapabilities string   `default:"full" authorized:"full,file,no" directive:"root default capabilities"`
 	MemoryFSType            string   `default:"tmpfs" authorized:"tmpfs,ramfs" directive:"memory fs type"`
+	ScratchBacking          string   `default:"workdir" authorized:"workdir,tmpfs" directive:"scratch backing"`
+	ScratchProjectQuota     bool     `default:"no" authorized:"yes,no" directive:"scratch project quota"`
+	WritableTmpfsBacking    string   `default:"tmpfs" authorized:"tmpfs,workdir" directive:"writable tmpfs backing"`
 	CniConfPath             string   `directive:"cni configuration path"`
 	CniPluginPath           string   `directive:"cni plugin path"`
 	MksquashfsPath          string   `directive:"mksquashfs path"`
+	UnsquashfsPath          string   `directive:"unsquashfs path"`
+	SquashfusePath          string   `directive:"squashfuse path"`
+	MkfsExt3Path            string   `directive:"mkfs.ext3 path"`
+	E2fsckPath              string   `directive:"e2fsck path"`
+	NvCCLIPath              string   `directive:"nvidia container cli path"`
 	CryptsetupPath          string   `directive:"cryptsetup path"`
+	BusyboxPath             string   `directive:"busybox path"`
 }
 
 // JSONConfig stores engine specific confguration that is allowed to be set by the user
 type JSONConfig struct {
 	ScratchDir        []string      `json:"scratchdir,omitempty"`
 	OverlayImage      []string      `json:"overlayImage,omitempty"`
+	OverlaySubdir     string        `json:"overlaySubdir,omitempty"`
+	OverlayMounts     []string      `json:"overlayMounts,omitempty"`
+	RemoteOverlay     []string      `json:"remoteOverlay,omitempty"`
+	LayerHint         bool          `json:"layerHint,omitempty"`
 	BindPath          []string      `json:"bindpath,omitempty"`
+	EnvOverrides      []string      `json:"envOverrides,omitempty"`
+	SecureRelax       []string      `json:"secureRelax,omitempty"`
 	NetworkArgs       []string      `json:"networkArgs,omitempty"`
 	Security          []string      `json:"security,omitempty"`
 	LibrariesPath     []string      `json:"librariesPath,omitempty"`
@@ -64,11 +127,16 @@ type JSONConfig struct {
 	Image             string        `json:"image"`
 	Workdir           string        `json:"workdir,omitempty"`
 	CgroupsPath       string        `json:"cgroupsPath,omitempty"`
+	SchedulerJob      string        `json:"schedulerJob,omitempty"`
 	HomeSource        string        `json:"homedir,omitempty"`
 	HomeDest          string        `json:"homeDest,omitempty"`
 	Command           string        `json:"command,omitempty"`
 	Shell             string        `json:"shell,omitempty"`
+	LoginShell        bool          `json:"loginShell,omitempty"`
+	Entrypoint        string        `json:"entrypoint,omitempty"`
+	DiagnosticFile    string        `json:"diagnosticFile,omitempty"`
 	TmpDir            string        `json:"tmpdir,omitempty"`
+	ScratchSize       string        `json:"scratchSize,omitempty"`
 	AddCaps           string        `json:"addCaps,omitempty"`
 	DropCaps          string        `json:"dropCaps,omitempty"`
 	Hostname          string        `json:"hostname,omitempty"`
@@ -77,10 +145,18 @@ type JSONConfig struct {
 	Cwd               string        `json:"cwd,omitempty"`
 	EncryptionKey     []byte        `json:"encryptionKey,omitempty"`
 	TargetUID         int           `json:"targetUID,omitempty"`
+	User              string        `json:"user,omitempty"`
+	GroupAdd          []string      `json:"groupAdd,omitempty"`
+	Scheduling        string        `json:"scheduling,omitempty"`
+	Umask             string        `json:"umask,omitempty"`
+	OomScoreAdj       string        `json:"oomScoreAdj,omitempty"`
 	WritableImage     bool          `json:"writableImage,omitempty"`
 	WritableTmpfs     bool          `json:"writableTmpfs,omitempty"`
+	ImageTmpfs        bool          `json:"imageTmpfs,omitempty"`
 	Contain           bool          `json:"container,omitempty"`
 	Nv                bool          `json:"nv,omitempty"`
+	NvCCLI            bool          `json:"nvCCLI,omitempty"`
+	KernelModules     bool          `json:"kernelModules,omitempty"`
 	CustomHome        bool          `json:"customHome,omitempty"`
 	Instance          bool          `json:"instance,omitempty"`
 	InstanceJoin      bool          `json:"instanceJoin,omitempty"`
@@ -94,6 +170,9 @@ type JSONConfig struct {
 	DeleteImage       bool          `json:"deleteImage,omitempty"`
 	Fakeroot          bool          `json:"fakeroot,omitempty"`
 	SignalPropagation bool          `json:"signalPropagation,omitempty"`
+	ReadyFile         string        `json:"readyFile,omitempty"`
+	ReadyCommand      string        `json:"readyCommand,omitempty"`
+	ReadyTimeout      int           `json:"readyTimeout,omitempty"`
 }
 
 // SetImage sets the container image path to be used by EngineConfig.JSON.
@@ -136,6 +215,85 @@ func (e *EngineConfig) GetOverlayImage() []string {
 	return e.JSON.OverlayImage
 }
 
+// SetOverlaySubdir sets the path relative to the writable overlay image
+// root where upper and work directories are located.
+func (e *EngineConfig) SetOverlaySubdir(path string) {
+	e.JSON.OverlaySubdir = path
+}
+
+// GetOverlaySubdir retrieves the writable overlay image subdirectory.
+func (e *EngineConfig) GetOverlaySubdir() string {
+	return e.JSON.OverlaySubdir
+}
+
+// SetOverlayMounts sets the list of <subdir>:<dest> specifications of
+// writable overlays mounted at dest with upper and work directories
+// located in subdir of the writable overlay image.
+func (e *EngineConfig) SetOverlayMounts(mounts []string) {
+	e.JSON.OverlayMounts = mounts
+}
+
+// GetOverlayMounts retrieves the writable overlay mount specifications.
+func (e *EngineConfig) GetOverlayMounts() []string {
+	return e.JSON.OverlayMounts
+}
+
+// SetRemoteOverlay sets the list of HTTP(S) URLs of squashfs images
+// used as read-only overlay lower directories.
+func (e *EngineConfig) SetRemoteOverlay(urls []string) {
+	e.JSON.RemoteOverlay = urls
+}
+
+// GetRemoteOverlay retrieves the list of remote overlay image URLs.
+func (e *EngineConfig) GetRemoteOverlay() []string {
+	return e.JSON.RemoteOverlay
+}
+
+// SetLayerHint sets if the image expects bind points to be created
+// by overlay or underlay.
+func (e *EngineConfig) SetLayerHint(hint bool) {
+	e.JSON.LayerHint = hint
+}
+
+// GetLayerHint returns if the image expects bind points to be created
+// by overlay or underlay.
+func (e *EngineConfig) GetLayerHint() bool {
+	return e.JSON.LayerHint
+}
+
+// SetReadyFile sets the path of the file or socket created in the
+// container when an instance is ready.
+func (e *EngineConfig) SetReadyFile(path string) {
+	e.JSON.ReadyFile = path
+}
+
+// GetReadyFile retrieves the path of the instance readiness file.
+func (e *EngineConfig) GetReadyFile() string {
+	return e.JSON.ReadyFile
+}
+
+// SetReadyCommand sets the command executed in the container to check
+// if an instance is ready.
+func (e *EngineConfig) SetReadyCommand(command string) {
+	e.JSON.ReadyCommand = command
+}
+
+// GetReadyCommand retrieves the instance readiness command.
+func (e *EngineConfig) GetReadyCommand() string {
+	return e.JSON.ReadyCommand
+}
+
+// SetReadyTimeout sets the maximum number of seconds to wait for an
+// instance to be ready.
+func (e *EngineConfig) SetReadyTimeout(timeout int) {
+	e.JSON.ReadyTimeout = timeout
+}
+
+// GetReadyTimeout retrieves the instance readiness timeout in seconds.
+func (e *EngineConfig) GetReadyTimeout() int {
+	return e.JSON.ReadyTimeout
+}
+
 // SetContain sets contain flag.
 func (e *EngineConfig) SetContain(contain bool) {
 	e.JSON.Contain = contain
@@ -156,6 +314,28 @@ func (e *EngineConfig) GetNv() bool {
 	return e.JSON.Nv
 }
 
+// SetNvCCLI sets flag to delegate NVIDIA GPU setup to nvidia-container-cli.
+func (e *EngineConfig) SetNvCCLI(nvCCLI bool) {
+	e.JSON.NvCCLI = nvCCLI
+}
+
+// GetNvCCLI returns if NVIDIA GPU setup is delegated to nvidia-container-cli.
+func (e *EngineConfig) GetNvCCLI() bool {
+	return e.JSON.NvCCLI
+}
+
+// SetKernelModules sets flag to bind host kernel modules and sources
+// into container.
+func (e *EngineConfig) SetKernelModules(val bool) {
+	e.JSON.KernelModules = val
+}
+
+// GetKernelModules returns if host kernel modules and sources are
+// bound into container or not.
+func (e *EngineConfig) GetKernelModules() bool {
+	return e.JSON.KernelModules
+}
+
 // SetWorkdir sets a work directory path.
 func (e *EngineConfig) SetWorkdir(name string) {
 	e.JSON.Workdir = name
@@ -176,6 +356,17 @@ func (e *EngineConfig) GetScratchDir() []string {
 	return e.JSON.ScratchDir
 }
 
+// SetScratchSize sets the size of tmpfs backing scratch directories
+// when no working directory is used.
+func (e *EngineConfig) SetScratchSize(size string) {
+	e.JSON.ScratchSize = size
+}
+
+// GetScratchSize retrieves the size of tmpfs backing scratch directories.
+func (e *EngineConfig) GetScratchSize() string {
+	return e.JSON.ScratchSize
+}
+
 // SetHomeSource sets the source home directory path.
 func (e *EngineConfig) SetHomeSource(source string) {
 	e.JSON.HomeSource = source
@@ -216,6 +407,55 @@ func (e *EngineConfig) GetBindPath() []string {
 	return e.JSON.BindPath
 }
 
+// SetEnvOverrides sets the list of environment variables explicitly
+// set by the user with SINGULARITYENV_ prefix.
+func (e *EngineConfig) SetEnvOverrides(keys []string) {
+	e.JSON.EnvOverrides = keys
+}
+
+// GetEnvOverrides retrieves the list of environment variables explicitly
+// set by the user.
+func (e *EngineConfig) GetEnvOverrides() []string {
+	return e.JSON.EnvOverrides
+}
+
+// SecureProfileFeatures lists features enabled by 'secure profile'
+// directive which can be relaxed individually by the user.
+var SecureProfileFeatures = []string{
+	"contain",
+	"cleanenv",
+	"no-new-privs",
+	"seccomp",
+	"proc-readonly",
+	"private-dev",
+}
+
+// SetSecureRelax sets the list of secure profile features relaxed
+// by the user.
+func (e *EngineConfig) SetSecureRelax(features []string) {
+	e.JSON.SecureRelax = features
+}
+
+// GetSecureRelax retrieves the list of secure profile features relaxed
+// by the user.
+func (e *EngineConfig) GetSecureRelax() []string {
+	return e.JSON.SecureRelax
+}
+
+// SecureProfile returns whether the secure profile feature applies,
+// that is if the profile is enabled and the feature isn't relaxed.
+func (e *EngineConfig) SecureProfile(feature string) bool {
+	if !e.File.SecureProfile {
+		return false
+	}
+	for _, f := range e.JSON.SecureRelax {
+		if f == feature {
+			return false
+		}
+	}
+	return true
+}
+
 // SetCommand sets action command to execute.
 func (e *EngineConfig) SetCommand(command string) {
 	e.JSON.Command = command
@@ -236,6 +476,40 @@ func (e *EngineConfig) GetShell() string {
 	return e.JSON.Shell
 }
 
+// SetLoginShell sets if shell command starts a login shell.
+func (e *EngineConfig) SetLoginShell(login bool) {
+	e.JSON.LoginShell = login
+}
+
+// GetLoginShell returns if shell command starts a login shell.
+func (e *EngineConfig) GetLoginShell() bool {
+	return e.JSON.LoginShell
+}
+
+// SetEntrypoint sets the program executed in place of the
+// container runscript by run command.
+func (e *EngineConfig) SetEntrypoint(entrypoint string) {
+	e.JSON.Entrypoint = entrypoint
+}
+
+// GetEntrypoint returns the program executed in place of the
+// container runscript by run command.
+func (e *EngineConfig) GetEntrypoint() string {
+	return e.JSON.Entrypoint
+}
+
+// SetDiagnosticFile sets the path of the JSON diagnostic file written
+// when container creation fails.
+func (e *EngineConfig) SetDiagnosticFile(path string) {
+	e.JSON.DiagnosticFile = path
+}
+
+// GetDiagnosticFile returns the path of the JSON diagnostic file written
+// when container creation fails.
+func (e *EngineConfig) GetDiagnosticFile() string {
+	return e.JSON.DiagnosticFile
+}
+
 // SetTmpDir sets temporary directory path.
 func (e *EngineConfig) SetTmpDir(name string) {
 	e.JSON.TmpDir = name
@@ -426,6 +700,18 @@ func (e *EngineConfig) GetWritableTmpfs() bool {
 	return e.JSON.WritableTmpfs
 }
 
+// SetImageWritableTmpfs sets if writable tmpfs was requested by image
+// runtime options rather than by the user.
+func (e *EngineConfig) SetImageWritableTmpfs(image bool) {
+	e.JSON.ImageTmpfs = image
+}
+
+// GetImageWritableTmpfs returns if writable tmpfs was requested by image
+// runtime options rather than by the user.
+func (e *EngineConfig) GetImageWritableTmpfs() bool {
+	return e.JSON.ImageTmpfs
+}
+
 // SetSecurity sets security feature arguments
 func (e *EngineConfig) SetSecurity(security []string) {
 	e.JSON.Security = security
@@ -446,6 +732,18 @@ func (e *EngineConfig) GetCgroupsPath() string {
 	return e.JSON.CgroupsPath
 }
 
+// SetSchedulerJob sets the batch scheduler job ID the container is
+// started in, container cgroup is then created in the job cgroup.
+func (e *EngineConfig) SetSchedulerJob(id string) {
+	e.JSON.SchedulerJob = id
+}
+
+// GetSchedulerJob returns the batch scheduler job ID the container is
+// started in.
+func (e *EngineConfig) GetSchedulerJob() string {
+	return e.JSON.SchedulerJob
+}
+
 // SetTargetUID sets target UID to execute the container process as user ID
 func (e *EngineConfig) SetTargetUID(uid int) {
 	e.JSON.TargetUID = uid
@@ -456,6 +754,62 @@ func (e *EngineConfig) GetTargetUID() int {
 	return e.JSON.TargetUID
 }
 
+// SetUser sets the user and group specification (uid:gid or name)
+// to execute the container process as.
+func (e *EngineConfig) SetUser(user string) {
+	e.JSON.User = user
+}
+
+// GetUser returns the user and group specification.
+func (e *EngineConfig) GetUser() string {
+	return e.JSON.User
+}
+
+// SetGroupAdd sets the supplementary groups (name or gid) of the
+// container process.
+func (e *EngineConfig) SetGroupAdd(groups []string) {
+	e.JSON.GroupAdd = groups
+}
+
+// GetGroupAdd returns the supplementary groups of the container process.
+func (e *EngineConfig) GetGroupAdd() []string {
+	return e.JSON.GroupAdd
+}
+
+// SetScheduling sets the container process scheduling policy and
+// priority in the form policy[:priority].
+func (e *EngineConfig) SetScheduling(sched string) {
+	e.JSON.Scheduling = sched
+}
+
+// GetScheduling returns the container process scheduling policy.
+func (e *EngineConfig) GetScheduling() string {
+	return e.JSON.Scheduling
+}
+
+// SetUmask sets the container process umask as an octal string,
+// the umask is left untouched if empty.
+func (e *EngineConfig) SetUmask(umask string) {
+	e.JSON.Umask = umask
+}
+
+// GetUmask returns the container process umask.
+func (e *EngineConfig) GetUmask() string {
+	return e.JSON.Umask
+}
+
+// SetOomScoreAdj sets the OOM score adjustment requested for the
+// container process, the configured value is used if empty.
+func (e *EngineConfig) SetOomScoreAdj(adj string) {
+	e.JSON.OomScoreAdj = adj
+}
+
+// GetOomScoreAdj returns the OOM score adjustment requested for the
+// container process.
+func (e *EngineConfig) GetOomScoreAdj() string {
+	return e.JSON.OomScoreAdj
+}
+
 // SetTargetGID sets target GIDs to execute container process as group IDs
 func (e *EngineConfig) SetTargetGID(gid []int) {
 	e.JSON.TargetGID = gid
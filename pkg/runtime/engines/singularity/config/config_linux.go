@@ -8,6 +8,7 @@ package singularity
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 
 	"github.com/sylabs/singularity/internal/pkg/cgroups"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config/oci"
@@ -16,13 +17,14 @@ import (
 
 // EngineConfig stores both the JSONConfig and the FileConfig
 type EngineConfig struct {
-	JSON      *JSONConfig                `json:"jsonConfig"`
-	OciConfig *oci.Config                `json:"ociConfig"`
-	File      *FileConfig                `json:"-"`
-	Network   *network.Setup             `json:"-"`
-	Cgroups   *cgroups.Manager           `json:"-"`
-	CryptDev  string                     `json:"-"`
-	Plugin    map[string]json.RawMessage `json:"plugin"` // Plugin is the raw JSON representation of the plugin configurations
+	JSON           *JSONConfig                `json:"jsonConfig"`
+	OciConfig      *oci.Config                `json:"ociConfig"`
+	File           *FileConfig                `json:"-"`
+	Network        *network.Setup             `json:"-"`
+	Cgroups        *cgroups.Manager           `json:"-"`
+	CryptDev       string                     `json:"-"`
+	SquashfusePids []int                      `json:"-"`
+	Plugin         map[string]json.RawMessage `json:"plugin"` // Plugin is the raw JSON representation of the plugin configurations
 }
 
 // FuseInfo stores the FUSE-related information required or provided by
@@ -136,3 +138,51 @@ func (e *EngineConfig) SetPluginFuseFd(name string, fd int) error {
 
 	return nil
 }
+
+// redactedValue replaces secret values in configuration dump
+const redactedValue = "REDACTED"
+
+// secretEnvKeys lists patterns identifying environment variables
+// holding secrets
+var secretEnvKeys = []string{"PASSWORD", "PASSPHRASE", "TOKEN", "SECRET", "KEY"}
+
+// DumpJSON returns an indented JSON representation of the effective
+// engine configuration including configuration file directives for
+// debugging purpose, the encryption key is omitted and values of
+// environment variables looking like secrets are redacted
+func (e *EngineConfig) DumpJSON() ([]byte, error) {
+	jsonConfig := *e.JSON
+	jsonConfig.EncryptionKey = nil
+
+	ociConfig := *e.OciConfig
+	if ociConfig.Process != nil {
+		process := *ociConfig.Process
+		process.Env = make([]string, len(ociConfig.Process.Env))
+		for i, env := range ociConfig.Process.Env {
+			process.Env[i] = redactEnv(env)
+		}
+		ociConfig.Process = &process
+	}
+
+	return json.MarshalIndent(&struct {
+		File      *FileConfig `json:"file"`
+		JSON      *JSONConfig `json:"jsonConfig"`
+		OciConfig *oci.Config `json:"ociConfig"`
+	}{e.File, &jsonConfig, &ociConfig}, "", "  ")
+}
+
+// redactEnv redacts value of an environment variable KEY=VALUE if
+// its name matches a secret pattern
+func redactEnv(env string) string {
+	kv := strings.SplitN(env, "=", 2)
+	if len(kv) != 2 {
+		return env
+	}
+	key := strings.ToUpper(kv[0])
+	for _, pattern := range secretEnvKeys {
+		if strings.Contains(key, pattern) {
+			return kv[0] + "=" + redactedValue
+		}
+	}
+	return env
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestDumpJSON(t *testing.T) {
+	e := NewConfig()
+	e.File.MountHome = true
+	e.SetImage("/image.sif")
+	e.SetEncryptionKey([]byte("encryption-secret"))
+	e.OciConfig.Process = &specs.Process{
+		Env: []string{"HOME=/home/user", "SINGULARITY_DOCKER_PASSWORD=docker-secret", "GITHUB_TOKEN=token-secret"},
+	}
+
+	data, err := e.DumpJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, s := range []string{`"MountHome": true`, `"image": "/image.sif"`, "HOME=/home/user", "SINGULARITY_DOCKER_PASSWORD=" + redactedValue} {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Errorf("%s not found in configuration dump", s)
+		}
+	}
+	for _, s := range []string{"secret", "encryptionKey"} {
+		if bytes.Contains(data, []byte(s)) {
+			t.Errorf("%s found in configuration dump", s)
+		}
+	}
+
+	if e.OciConfig.Process.Env[1] != "SINGULARITY_DOCKER_PASSWORD=docker-secret" {
+		t.Errorf("original configuration modified")
+	}
+	if len(e.GetEncryptionKey()) == 0 {
+		t.Errorf("original encryption key modified")
+	}
+}
+
+func TestSecureProfile(t *testing.T) {
+	e := NewConfig()
+
+	if e.SecureProfile("contain") {
+		t.Errorf("secure profile feature applied while profile is disabled")
+	}
+
+	e.File.SecureProfile = true
+	e.SetSecureRelax([]string{"seccomp"})
+
+	for _, feature := range SecureProfileFeatures {
+		expected := feature != "seccomp"
+		if applied := e.SecureProfile(feature); applied != expected {
+			t.Errorf("got %v for feature %s instead of %v", applied, feature, expected)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+// RuntimeOptionsName is the name of the SIF JSON data object holding
+// runtime options advertised by an image.
+const RuntimeOptionsName = "runtime-options.json"
+
+// RuntimeOptions describes runtime options an image requires, they are
+// applied as defaults and are overridden by options set by the user.
+// Supported keys are:
+//   - network: network type to use, implies a network namespace (eg: "none")
+//   - cleanEnv: don't pass caller environment (like --cleanenv), the
+//     caller can still inherit its environment with --env-pass
+//   - contain: use minimal /dev and empty other directories (like --contain)
+//   - noHome: don't mount user home directory (like --no-home)
+//   - writableTmpfs: use a writable tmpfs overlay (like --writable-tmpfs)
+//   - layer: the image expects bind points missing in its root filesystem
+//     to be created by overlay or underlay, a warning is displayed early on
+//     hosts providing neither of them, it's recorded by the SIF assembler
+//     when default bind points like /etc/hosts are missing
+//   - binds: list of src[:dst[:options]] host paths bound by default (like
+//     --bind), they are subject to the same restrictions than user binds, a
+//     user bind with the same destination takes precedence and all of them
+//     are ignored with --no-image-binds
+type RuntimeOptions struct {
+	Network       string   `json:"network,omitempty"`
+	CleanEnv      bool     `json:"cleanEnv,omitempty"`
+	Contain       bool     `json:"contain,omitempty"`
+	NoHome        bool     `json:"noHome,omitempty"`
+	WritableTmpfs bool     `json:"writableTmpfs,omitempty"`
+	Layer         bool     `json:"layer,omitempty"`
+	Binds         []string `json:"binds,omitempty"`
+}
+
+// GetRuntimeOptions returns runtime options advertised by a SIF image,
+// nil is returned if the image doesn't provide runtime options.
+func GetRuntimeOptions(img *image.Image) (*RuntimeOptions, error) {
+	if img.Type != image.SIF {
+		return nil, nil
+	}
+
+	r, err := image.NewSectionReader(img, RuntimeOptionsName, -1)
+	if err == image.ErrNoSection {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("while reading %s: %s", RuntimeOptionsName, err)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %s", RuntimeOptionsName, err)
+	}
+
+	options := &RuntimeOptions{}
+	if err := json.Unmarshal(data, options); err != nil {
+		return nil, fmt.Errorf("while decoding %s: %s", RuntimeOptionsName, err)
+	}
+
+	// keys unknown to this version, set by a newer one, are ignored
+	// without discarding the supported options
+	keys := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("while decoding %s: %s", RuntimeOptionsName, err)
+	}
+	for _, key := range unknownRuntimeOptions(keys) {
+		sylog.Warningf("Ignoring unsupported image runtime option %q", key)
+	}
+
+	return options, nil
+}
+
+// unknownRuntimeOptions returns the sorted list of keys not matching
+// a RuntimeOptions field
+func unknownRuntimeOptions(keys map[string]json.RawMessage) []string {
+	t := reflect.TypeOf(RuntimeOptions{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		delete(keys, name)
+	}
+
+	unknown := make([]string, 0, len(keys))
+	for key := range keys {
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+
+	return unknown
+}
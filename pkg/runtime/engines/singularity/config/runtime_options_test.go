@@ -0,0 +1,86 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+func TestGetRuntimeOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		imgType  int
+		section  string
+		data     string
+		expected *RuntimeOptions
+		fail     bool
+	}{
+		{"sandbox", image.SANDBOX, RuntimeOptionsName, `{"contain": true}`, nil, false},
+		{"no runtime options", image.SIF, "oci-config.json", `{}`, nil, false},
+		{"runtime options", image.SIF, RuntimeOptionsName, `{"network": "none", "noHome": true}`, &RuntimeOptions{Network: "none", NoHome: true}, false},
+		{"clean environment", image.SIF, RuntimeOptionsName, `{"cleanEnv": true}`, &RuntimeOptions{CleanEnv: true}, false},
+		{"layer", image.SIF, RuntimeOptionsName, `{"layer": true}`, &RuntimeOptions{Layer: true}, false},
+		{"binds", image.SIF, RuntimeOptionsName, `{"binds": ["/data", "/ref:/mnt:ro"]}`, &RuntimeOptions{Binds: []string{"/data", "/ref:/mnt:ro"}}, false},
+		{"unknown key", image.SIF, RuntimeOptionsName, `{"privileged": true, "cleanEnv": true, "contain": true}`, &RuntimeOptions{CleanEnv: true, Contain: true}, false},
+		{"bad json", image.SIF, RuntimeOptionsName, `{`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "runtime-options-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			if _, err := f.WriteString(tt.data); err != nil {
+				t.Fatal(err)
+			}
+
+			img := &image.Image{
+				Type: tt.imgType,
+				File: f,
+				Sections: []image.Section{
+					{Name: tt.section, Size: uint64(len(tt.data))},
+				},
+			}
+
+			options, err := GetRuntimeOptions(img)
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(options, tt.expected) {
+				t.Errorf("got %+v instead of %+v", options, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUnknownRuntimeOptions(t *testing.T) {
+	keys := map[string]json.RawMessage{
+		"network":    nil,
+		"writableFs": nil,
+		"binds":      nil,
+		"privileged": nil,
+	}
+
+	unknown := unknownRuntimeOptions(keys)
+	if expected := []string{"privileged", "writableFs"}; !reflect.DeepEqual(unknown, expected) {
+		t.Errorf("got unknown keys %v instead of %v", unknown, expected)
+	}
+}
@@ -470,6 +470,52 @@ func getSignerIdentity(keyring *sypgp.Handle, v *sif.Descriptor, block *clearsig
 	return "", false, err
 }
 
+// VerifyFp verifies signatures of the primary partition of an already
+// opened SIF container against keys of keyring, and returns the identity
+// of the first signer whose signature and partition hash are valid.
+func VerifyFp(fp *os.File, keyring openpgp.EntityList) (string, error) {
+	fimg, err := sif.LoadContainerFp(fp, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to load SIF container file: %s", err)
+	}
+
+	signatures, descr, err := getSigsPrimPart(&fimg)
+	if err != nil {
+		return "", err
+	}
+
+	sifhash := computeHashStr(&fimg, descr)
+
+	for _, v := range signatures {
+		fingerprint, err := v.GetEntityString()
+		if err != nil {
+			sylog.Debugf("could not get the signing entity fingerprint from partition ID: %d: %s", v.ID, err)
+			continue
+		}
+
+		block, _ := clearsign.Decode(v.GetData(&fimg))
+		if block == nil {
+			sylog.Debugf("signature key (%s) corrupted, unable to read data", fingerprint)
+			continue
+		}
+
+		signer, err := openpgp.CheckDetachedSignature(keyring, bytes.NewBuffer(block.Bytes), block.ArmoredSignature.Body)
+		if err != nil {
+			sylog.Debugf("signature key (%s) not verified: %s", fingerprint, err)
+			continue
+		}
+
+		if !bytes.Equal(bytes.TrimRight(block.Plaintext, "\n"), []byte(sifhash)) {
+			sylog.Debugf("key (%s) hash differs, data may be corrupted", fingerprint)
+			continue
+		}
+
+		return getFirstIdentity(signer), nil
+	}
+
+	return "", ErrVerificationFail
+}
+
 func getSignEntities(fimg *sif.FileImage) ([]string, error) {
 	// get all signature blocks (signatures) for ID/GroupID selected (descr) from SIF file
 	signatures, _, err := getSigsPrimPart(fimg)
@@ -60,6 +60,65 @@ func ParseMountInfo(path string) (map[string][]string, error) {
 	return mp, nil
 }
 
+// MountOptions parses mountinfo pointing to path and returns the
+// per-mount options of the topmost file system mounted on mountpoint
+func MountOptions(path string, mountpoint string) ([]string, error) {
+	var options []string
+
+	p, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s: %s", path, err)
+	}
+	defer p.Close()
+
+	scanner := bufio.NewScanner(p)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 5 && fields[4] == mountpoint {
+			options = strings.Split(fields[5], ",")
+		}
+	}
+	if options == nil {
+		return nil, fmt.Errorf("no mount point %s found in %s", mountpoint, path)
+	}
+	return options, nil
+}
+
+// MountPropagation parses mountinfo pointing to path and returns the
+// propagation fields (shared:X, master:X ...) of the topmost file
+// system mounted on mountpoint, an empty list means private
+func MountPropagation(path string, mountpoint string) ([]string, error) {
+	var propagation []string
+	found := false
+
+	p, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s: %s", path, err)
+	}
+	defer p.Close()
+
+	scanner := bufio.NewScanner(p)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 || fields[4] != mountpoint {
+			continue
+		}
+		found = true
+		propagation = nil
+		// optional fields are terminated by a single hyphen
+		for _, f := range fields[6:] {
+			if f == "-" {
+				break
+			}
+			propagation = append(propagation, f)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no mount point %s found in %s", mountpoint, path)
+	}
+	return propagation, nil
+}
+
 // ParentMount parses mountinfo and return the path of parent
 // mount point for which the provided path is mounted in
 func ParentMount(path string) (string, error) {
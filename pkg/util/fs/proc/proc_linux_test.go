@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"reflect"
 	"syscall"
 	"testing"
 
@@ -122,6 +123,95 @@ func TestParseMountInfo(t *testing.T) {
 	}
 }
 
+func TestMountOptions(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	tmpfile, err := ioutil.TempFile("", "mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(mountInfoData)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	options, err := MountOptions(tmpfile.Name(), "/sys/fs/cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options) == 0 || options[0] != "ro" {
+		t.Errorf("got %v options instead of ro,nosuid,nodev,noexec", options)
+	}
+
+	options, err = MountOptions(tmpfile.Name(), "/home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options) == 0 || options[0] != "rw" {
+		t.Errorf("got %v options instead of rw,noatime,nodiratime", options)
+	}
+
+	if _, err := MountOptions(tmpfile.Name(), "/non-existent"); err == nil {
+		t.Errorf("should have failed with non existent mount point")
+	}
+}
+
+func TestMountPropagation(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	tmpfile, err := ioutil.TempFile("", "mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	data := mountInfoData + `
+400 28 0:60 / /mnt/private rw,relatime - tmpfs tmpfs rw
+401 28 0:61 / /mnt/slave rw,relatime master:1 - tmpfs tmpfs rw
+402 28 0:62 / /mnt/both rw,relatime shared:70 master:1 - tmpfs tmpfs rw
+`
+	if _, err := tmpfile.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mountpoint  string
+		propagation []string
+		fail        bool
+	}{
+		{"/home", []string{"shared:33"}, false},
+		{"/mnt/private", nil, false},
+		{"/mnt/slave", []string{"master:1"}, false},
+		{"/mnt/both", []string{"shared:70", "master:1"}, false},
+		{"/non-existent", nil, true},
+	}
+
+	for _, tt := range tests {
+		propagation, err := MountPropagation(tmpfile.Name(), tt.mountpoint)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("unexpected success for %s", tt.mountpoint)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("unexpected error for %s: %s", tt.mountpoint, err)
+			continue
+		}
+		if !reflect.DeepEqual(propagation, tt.propagation) {
+			t.Errorf("got propagation %v for %s instead of %v", propagation, tt.mountpoint, tt.propagation)
+		}
+	}
+}
+
 func TestExtractPid(t *testing.T) {
 	procList := []struct {
 		path string
@@ -5,11 +5,29 @@
 
 package loop
 
+import "fmt"
+
+// UnavailableError is returned when no free loop device was found
+// among the scanned devices
+type UnavailableError struct {
+	Devices int
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("no loop devices available among %d devices", e.Devices)
+}
+
 // Device describes a loop device
 type Device struct {
 	MaxLoopDevices int
 	Shared         bool
 	Info           *Info64
+	// BlockSize is the logical block size set on the loop device
+	// before enabling direct I/O, the kernel default is kept if zero
+	BlockSize uint32
+	// Pool is a list of loop devices or directories containing
+	// loop devices to choose from instead of creating them in /dev
+	Pool []string
 }
 
 // Loop device flags values
@@ -37,15 +55,16 @@ const (
 
 // Loop device IOCTL commands
 const (
-	CmdSetFd       = 0x4C00
-	CmdClrFd       = 0x4C01
-	CmdSetStatus   = 0x4C02
-	CmdGetStatus   = 0x4C03
-	CmdSetStatus64 = 0x4C04
-	CmdGetStatus64 = 0x4C05
-	CmdChangeFd    = 0x4C06
-	CmdSetCapacity = 0x4C07
-	CmdSetDirectIO = 0x4C08
+	CmdSetFd        = 0x4C00
+	CmdClrFd        = 0x4C01
+	CmdSetStatus    = 0x4C02
+	CmdGetStatus    = 0x4C03
+	CmdSetStatus64  = 0x4C04
+	CmdGetStatus64  = 0x4C05
+	CmdChangeFd     = 0x4C06
+	CmdSetCapacity  = 0x4C07
+	CmdSetDirectIO  = 0x4C08
+	CmdSetBlockSize = 0x4C09
 )
 
 // Info64 contains information about a loop device.
@@ -7,11 +7,18 @@ package loop
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
 	"unsafe"
 
+	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/util/fs/lock"
+	"golang.org/x/sys/unix"
 )
 
 // AttachFromFile finds a free loop device, opens it, and stores file descriptor
@@ -32,6 +39,25 @@ func (loop *Device) AttachFromFile(image *os.File, mode int, number *int) error
 	imageIno := st.Ino
 	imageDev := st.Dev
 
+	var pool []string
+
+	maxDevices := loop.MaxLoopDevices
+
+	if len(loop.Pool) > 0 {
+		pool, err = PoolDevices(loop.Pool)
+		if err != nil {
+			return err
+		}
+		if len(pool) == 0 {
+			return fmt.Errorf("no loop devices found in pool %s", strings.Join(loop.Pool, ","))
+		}
+		if len(pool) < maxDevices {
+			maxDevices = len(pool)
+		}
+	} else if _, err := os.Stat("/dev/loop-control"); err != nil {
+		return fmt.Errorf("/dev/loop-control is not available and no loop device pool was provided")
+	}
+
 	fd, err := lock.Exclusive("/dev")
 	if err != nil {
 		return err
@@ -40,10 +66,10 @@ func (loop *Device) AttachFromFile(image *os.File, mode int, number *int) error
 
 	freeDevice := -1
 
-	for device := 0; device <= loop.MaxLoopDevices; device++ {
+	for device := 0; device <= maxDevices; device++ {
 		*number = device
 
-		if device == loop.MaxLoopDevices {
+		if device == maxDevices {
 			if loop.Shared {
 				loop.Shared = false
 				if freeDevice != -1 {
@@ -51,11 +77,18 @@ func (loop *Device) AttachFromFile(image *os.File, mode int, number *int) error
 					continue
 				}
 			}
-			return fmt.Errorf("no loop devices available")
+			return &UnavailableError{Devices: maxDevices}
 		}
 
 		path = fmt.Sprintf("/dev/loop%d", device)
-		if fi, err := os.Stat(path); err != nil {
+		if pool != nil {
+			path = pool[device]
+			fi, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("could not get %s status: %s", path, err)
+			}
+			*number = int(unix.Minor(fi.Sys().(*syscall.Stat_t).Rdev))
+		} else if fi, err := os.Stat(path); err != nil {
 			dev := int((7 << 8) | (device & 0xff) | ((device & 0xfff00) << 12))
 			esys := syscall.Mknod(path, syscall.S_IFBLK|0660, dev)
 			if errno, ok := esys.(syscall.Errno); ok {
@@ -93,6 +126,17 @@ func (loop *Device) AttachFromFile(image *os.File, mode int, number *int) error
 				syscall.Close(loopFd)
 				continue
 			}
+			// another process may have grabbed and released the device
+			// between our set fd and set status calls, detach it and
+			// try with the next device
+			if _, _, esys := syscall.Syscall(syscall.SYS_IOCTL, uintptr(loopFd), CmdSetStatus64, uintptr(unsafe.Pointer(loop.Info))); esys != 0 {
+				syscall.Syscall(syscall.SYS_IOCTL, uintptr(loopFd), CmdClrFd, 0)
+				syscall.Close(loopFd)
+				if esys == syscall.EBUSY || esys == syscall.EAGAIN {
+					continue
+				}
+				return fmt.Errorf("failed to set loop flags on loop device: %s", esys)
+			}
 			break
 		}
 	}
@@ -101,13 +145,119 @@ func (loop *Device) AttachFromFile(image *os.File, mode int, number *int) error
 		return fmt.Errorf("failed to set close-on-exec on loop device %s: %s", path, err.Error())
 	}
 
-	if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(loopFd), CmdSetStatus64, uintptr(unsafe.Pointer(loop.Info))); err != 0 {
-		return fmt.Errorf("failed to set loop flags on loop device: %s", syscall.Errno(err))
+	if loop.Info.Flags&FlagsDirectIO != 0 {
+		loop.setDirectIO(loopFd, path)
 	}
 
 	return nil
 }
 
+// setDirectIO enables direct I/O on the loop device opened with fd,
+// the loop logical block size is first aligned on BlockSize when set.
+// The loop device keeps using buffered I/O if the backing file system
+// doesn't support direct I/O (eg: tmpfs, overlay)
+func (loop *Device) setDirectIO(fd int, path string) {
+	if loop.BlockSize > 0 {
+		if _, _, esys := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), CmdSetBlockSize, uintptr(loop.BlockSize)); esys != 0 {
+			sylog.Warningf("Could not set loop device %s block size to %d bytes, using buffered I/O: %s", path, loop.BlockSize, esys)
+			return
+		}
+	}
+	if _, _, esys := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), CmdSetDirectIO, 1); esys != 0 {
+		sylog.Warningf("Could not enable direct I/O on loop device %s, using buffered I/O: %s", path, esys)
+	}
+}
+
+// PoolDevices returns the sorted list of loop block devices found in
+// pool, a pool entry is either a loop device or a directory containing
+// loop devices
+func PoolDevices(pool []string) ([]string, error) {
+	devices := make([]string, 0)
+
+	for _, p := range pool {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("could not get %s status: %s", p, err)
+		}
+
+		entries := []string{p}
+		if fi.IsDir() {
+			entries, err = filepath.Glob(filepath.Join(p, "*"))
+			if err != nil {
+				return nil, fmt.Errorf("could not read directory %s: %s", p, err)
+			}
+			sort.Strings(entries)
+		}
+
+		for _, e := range entries {
+			fi, err := os.Stat(e)
+			if err != nil || fi.Mode()&os.ModeDevice == 0 || fi.Mode()&os.ModeCharDevice != 0 {
+				continue
+			}
+			if unix.Major(fi.Sys().(*syscall.Stat_t).Rdev) != 7 {
+				continue
+			}
+			devices = append(devices, e)
+		}
+	}
+
+	return devices, nil
+}
+
+// PoolDevicePath returns the path of the loop device identified by
+// number in pool
+func PoolDevicePath(pool []string, number int) (string, error) {
+	devices, err := PoolDevices(pool)
+	if err != nil {
+		return "", err
+	}
+	for _, d := range devices {
+		fi, err := os.Stat(d)
+		if err != nil {
+			continue
+		}
+		if int(unix.Minor(fi.Sys().(*syscall.Stat_t).Rdev)) == number {
+			return d, nil
+		}
+	}
+	return "", fmt.Errorf("loop device %d not found in pool", number)
+}
+
+// BlockSize returns the logical block size of the block device
+// holding the file pointed by path. Loop device offset must be
+// aligned on this size to use direct I/O. If the file doesn't
+// reside on a block device, the default of 512 bytes is returned.
+func BlockSize(path string) (uint64, error) {
+	const defaultBlockSize = 512
+
+	var st syscall.Stat_t
+
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, fmt.Errorf("failed to get %s status: %s", path, err)
+	}
+
+	dev := fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(st.Dev), unix.Minor(st.Dev))
+	sysPath, err := filepath.EvalSymlinks(dev)
+	if err != nil {
+		return defaultBlockSize, nil
+	}
+
+	// partitions don't have queue directory, look at parent device
+	for _, p := range []string{sysPath, filepath.Dir(sysPath)} {
+		b, err := ioutil.ReadFile(filepath.Join(p, "queue", "logical_block_size"))
+		if err != nil {
+			continue
+		}
+		size, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil || size == 0 {
+			return 0, fmt.Errorf("bad logical block size for device %s", dev)
+		}
+		return size, nil
+	}
+
+	return defaultBlockSize, nil
+}
+
 // AttachFromPath finds a free loop device, opens it, and stores file descriptor
 // of opened image path
 func (loop *Device) AttachFromPath(image string, mode int, number *int) error {
@@ -136,3 +286,19 @@ func GetStatusFromPath(path string) (*Info64, error) {
 	}
 	return GetStatusFromFd(loop.Fd())
 }
+
+// DetachFromPath detaches the file associated with the loop device path,
+// if the loop device is still in use the kernel detaches it once released
+func DetachFromPath(path string) error {
+	loop, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open loop device %s: %s", path, err)
+	}
+	defer loop.Close()
+
+	_, _, esys := syscall.Syscall(syscall.SYS_IOCTL, loop.Fd(), CmdClrFd, 0)
+	if esys != syscall.ENXIO && esys != 0 {
+		return fmt.Errorf("failed to detach loop device %s: %s", path, esys.Error())
+	}
+	return nil
+}
@@ -7,7 +7,9 @@ package loop
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
 
@@ -99,5 +101,111 @@ func TestLoop(t *testing.T) {
 	loopDev.MaxLoopDevices = 0
 	if err := loopDev.AttachFromPath("/etc/group", os.O_RDONLY, &loopTwo); err == nil {
 		t.Errorf("unexpected success with MaxLoopDevices = 0")
+	} else if _, ok := err.(*UnavailableError); !ok {
+		t.Errorf("unexpected error with MaxLoopDevices = 0: %s", err)
 	}
 }
+
+func TestBlockSize(t *testing.T) {
+	if _, err := BlockSize("/non/existent/file"); err == nil {
+		t.Errorf("unexpected success with a non existent file")
+	}
+
+	size, err := BlockSize("/etc/passwd")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if size < 512 || size&(size-1) != 0 {
+		t.Errorf("unexpected block size %d", size)
+	}
+}
+
+func TestLoopPool(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "loop-pool-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// pool directory with a loop device and unrelated files
+	for _, n := range []int{42, 43} {
+		path := filepath.Join(dir, fmt.Sprintf("pool-loop%d", n))
+		if err := syscall.Mknod(path, syscall.S_IFBLK|0600, (7<<8)|n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	devices, err := PoolDevices([]string{dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("unexpected number of pool devices: %d", len(devices))
+	}
+	if _, err := PoolDevices([]string{"/non/existent"}); err == nil {
+		t.Errorf("unexpected success with a non existent pool")
+	}
+
+	loopDev := &Device{
+		MaxLoopDevices: 256,
+		Info:           &Info64{Flags: FlagsAutoClear | FlagsReadOnly},
+		Pool:           []string{filepath.Join(dir, "file")},
+	}
+
+	number := -1
+	if err := loopDev.AttachFromPath("/etc/passwd", os.O_RDONLY, &number); err == nil {
+		t.Errorf("unexpected success with an empty pool")
+	}
+
+	loopDev.Pool = []string{dir}
+	if err := loopDev.AttachFromPath("/etc/passwd", os.O_RDONLY, &number); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if number != 42 && number != 43 {
+		t.Errorf("loop device %d not chosen from pool", number)
+	}
+
+	path, err := PoolDevicePath(loopDev.Pool, number)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if path != filepath.Join(dir, fmt.Sprintf("pool-loop%d", number)) {
+		t.Errorf("unexpected pool device path %s", path)
+	}
+	if _, err := PoolDevicePath(loopDev.Pool, 0); err == nil {
+		t.Errorf("unexpected success with a loop device outside of pool")
+	}
+}
+
+func TestDirectIOFallback(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	// tmpfs doesn't support direct I/O
+	f, err := ioutil.TempFile("/dev/shm", "loop-")
+	if err != nil {
+		t.Skipf("could not create file in /dev/shm: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := f.Truncate(1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+
+	loopDev := &Device{
+		MaxLoopDevices: 256,
+		Info:           &Info64{Flags: FlagsAutoClear | FlagsDirectIO},
+		BlockSize:      4096,
+	}
+
+	number := -1
+	if err := loopDev.AttachFromFile(f, os.O_RDWR, &number); err != nil {
+		t.Fatalf("unexpected error with direct I/O on tmpfs: %s", err)
+	}
+	DetachFromPath(fmt.Sprintf("/dev/loop%d", number))
+}
@@ -33,3 +33,8 @@ func GetStatusFromFd(fd uintptr) (*Info64, error) {
 func GetStatusFromPath(path string) (*Info64, error) {
 	return nil, fmt.Errorf("unsupported on this platform")
 }
+
+// DetachFromPath detaches the file associated with the loop device path
+func DetachFromPath(path string) error {
+	return fmt.Errorf("unsupported on this platform")
+}
@@ -10,11 +10,13 @@ import (
 	"bytes"
 	"debug/elf"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 )
@@ -213,9 +215,8 @@ func IpcsPath(envPath string) []string {
 
 		// nvidia-container-cli may not be installed, check
 		// default path
-		_, err := os.Stat(persistencedSocket)
-		if os.IsNotExist(err) {
-			sylog.Verbosef("persistenced socket %s not found", persistencedSocket)
+		if err := checkSocket(persistencedSocket); err != nil {
+			sylog.Verbosef("skipping persistenced socket: %s", err)
 		} else {
 			nvidiaFiles = append(nvidiaFiles, persistencedSocket)
 		}
@@ -223,3 +224,20 @@ func IpcsPath(envPath string) []string {
 
 	return nvidiaFiles
 }
+
+// checkSocket returns an error if path is not a socket or if there
+// is no process listening on it (eg: stale socket left by daemon).
+func checkSocket(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return fmt.Errorf("daemon not listening on %s: %s", path, err)
+	}
+	return conn.Close()
+}